@@ -0,0 +1,49 @@
+// Suggests the tags the user has used before in the tag fields of the
+// edit page. The fields hold lists separated by commas, so only the
+// tag being typed, after the last comma, is completed.
+(function () {
+  var list = document.getElementById("tag-suggestions");
+  if (!list) {
+    return;
+  }
+  var pending = null;
+
+  function suggest(input) {
+    var value = input.value;
+    var cut = value.lastIndexOf(",") + 1;
+    var before = value.slice(0, cut);
+    if (before !== "") {
+      before += " ";
+    }
+    var typed = value.slice(cut).trim();
+    if (pending) {
+      pending.abort();
+    }
+    if (typed === "") {
+      list.innerHTML = "";
+      return;
+    }
+    pending = new XMLHttpRequest();
+    pending.open("GET", "/tags?q=" + encodeURIComponent(typed));
+    pending.onload = function () {
+      if (this.status !== 200) {
+        return;
+      }
+      list.innerHTML = "";
+      JSON.parse(this.responseText).forEach(function (name) {
+        var option = document.createElement("option");
+        option.value = before + name;
+        list.appendChild(option);
+      });
+    };
+    pending.send();
+  }
+
+  var inputs = document.querySelectorAll(".tag-input");
+  for (var i = 0; i < inputs.length; i++) {
+    inputs[i].setAttribute("list", "tag-suggestions");
+    inputs[i].addEventListener("input", function () {
+      suggest(this);
+    });
+  }
+})();
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"photo-gallery/models"
 )
 
 type PostgresConfig struct {
@@ -35,12 +36,52 @@ func DefaultPostgresConfig() PostgresConfig {
 	}
 }
 
+// StorageConfig selects where the bytes of images are kept.
+// Backend is either "disk", which keeps them under Path,
+// or "s3", which keeps them in the configured S3 bucket.
+type StorageConfig struct {
+	Backend string          `json:"backend"`
+	Path    string          `json:"path"`
+	S3      models.S3Config `json:"s3"`
+}
+
+func (c StorageConfig) BlobStore() (models.BlobStore, error) {
+	switch c.Backend {
+	case "", "disk":
+		path := c.Path
+		if path == "" {
+			path = DefaultStorageConfig().Path
+		}
+		return models.NewDiskStore(path), nil
+	case "s3":
+		store, err := models.NewS3Store(c.S3)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", c.Backend)
+	}
+}
+
+func DefaultStorageConfig() StorageConfig {
+	return StorageConfig{
+		Backend: "disk",
+		Path:    "images",
+	}
+}
+
 type Config struct {
-	Port     int            `json:"port"`
-	Env      string         `json:"env"`
-	Pepper   string         `json:"pepper"`
-	HMACkey  string         `json:"hamc_key"`
-	Database PostgresConfig `json:"database"`
+	Port     int                 `json:"port"`
+	Env      string              `json:"env"`
+	Pepper   string              `json:"pepper"`
+	HMACkey  string              `json:"hamc_key"`
+	Database PostgresConfig      `json:"database"`
+	Images   models.ImageConfig  `json:"images"`
+	Storage  StorageConfig       `json:"storage"`
+	Uploads  models.UploadConfig `json:"uploads"`
+	// Workers is the number of background jobs run at once.
+	Workers int `json:"workers"`
 }
 
 func (c *Config) IsProd() bool {
@@ -54,6 +95,24 @@ func DefaultConfig() Config {
 		Pepper:   "secret-random-string-dev",
 		HMACkey:  "secret-hmac-key-dev",
 		Database: DefaultPostgresConfig(),
+		Images: models.ImageConfig{
+			Renditions:         models.DefaultRenditions,
+			MaxBytes:           models.DefaultMaxBytes,
+			MaxRequestBytes:    models.DefaultMaxRequestBytes,
+			MaxPixels:          models.DefaultMaxPixels,
+			MaxDimension:       models.DefaultMaxDimension,
+			QuotaBytes:         models.DefaultQuotaBytes,
+			MaxArchiveEntries:  models.DefaultMaxArchiveEntries,
+			MaxArchiveBytes:    models.DefaultMaxArchiveBytes,
+			TrashRetentionDays: models.DefaultTrashRetentionDays,
+		},
+		Storage: DefaultStorageConfig(),
+		Uploads: models.UploadConfig{
+			Path:        "uploads",
+			ExpireHours: models.DefaultUploadExpireHours,
+			MaxPending:  models.DefaultUploadMaxPending,
+		},
+		Workers: models.DefaultJobWorkers,
 	}
 }
 
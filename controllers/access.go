@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"net/http"
+	"photo-gallery/context"
+	"photo-gallery/models"
+	"photo-gallery/policy"
+)
+
+// via is how a visitor came to a gallery, which its visibility goes by.
+type via int
+
+const (
+	// viaID is the numeric ID of the gallery, which anyone can guess.
+	viaID via = iota
+	// viaLink is the share slug of the gallery, which can't be guessed.
+	viaLink
+	// viaImage is the URL of an image, which can't be guessed either.
+	// The images of a page served through a share link load with the
+	// cookie it set, see galleryShared.
+	viaImage
+	// viaShareLink is a share link which was checked already.
+	viaShareLink
+)
+
+// access works out who is asking for a gallery, so package
+// policy can decide what they may do with it.
+type access struct {
+	gs  models.GalleryService
+	sls models.ShareLinkService
+	ms  models.MembershipService
+}
+
+// visitor returns the policy.Visitor of the request, and sets the role
+// and permissions of the gallery for the templates.
+func (a access) visitor(r *http.Request, gallery *models.Gallery, via via) (policy.Visitor, error) {
+	user := context.User(r.Context())
+	role, err := a.ms.RoleOf(gallery, user)
+	if err != nil {
+		return policy.Visitor{}, err
+	}
+	v := policy.Visitor{
+		User:     user,
+		Role:     role,
+		ByLink:   via != viaID,
+		Unlocked: galleryUnlocked(a.gs, r, gallery),
+		Shared:   via == viaShareLink || via == viaImage && galleryShared(a.sls, r, gallery),
+	}
+	setPermissions(gallery, v)
+	return v, nil
+}
+
+func setPermissions(gallery *models.Gallery, v policy.Visitor) {
+	gallery.Role = v.Role
+	gallery.Can = policy.Permissions(gallery, v)
+}
+
+// allowed answers the request unless the decision allows it: with 404
+// when the visitor can't see the gallery, so they don't learn that it
+// exists, and with 403 when they can but may not do this with it.
+func allowed(w http.ResponseWriter, d policy.Decision) bool {
+	switch d {
+	case policy.Allow:
+		return true
+	case policy.Forbidden:
+		http.Error(w, "You are not allowed to do this", http.StatusForbidden)
+	default:
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"photo-gallery/models"
+	"photo-gallery/views"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// NewAdmin is used to create the controller of the admin pages.
+// This function will panic if the templates are not parsed
+// correctly, and should only be used during initial setup.
+func NewAdmin(us models.UserService, is models.ImageService) *Admin {
+	return &Admin{
+		UsersView: views.NewView("bootstrap", "admin/users"),
+		us:        us,
+		is:        is,
+	}
+}
+
+type Admin struct {
+	UsersView *views.View
+	us        models.UserService
+	is        models.ImageService
+}
+
+// UserUsage is a row of the users page.
+type UserUsage struct {
+	models.User
+	Usage *models.Usage
+}
+
+// QuotaMB returns the quota override of the user in megabytes.
+func (u UserUsage) QuotaMB() int64 {
+	if u.QuotaBytes == nil {
+		return 0
+	}
+	return *u.QuotaBytes >> 20
+}
+
+// Users lists every user along with their storage usage.
+//
+// GET /admin/users
+func (a *Admin) Users(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	rows, err := a.userUsages()
+	if err != nil {
+		vd.SetAlert(err)
+		a.UsersView.Render(w, r, vd)
+		return
+	}
+	vd.Yield = rows
+	a.UsersView.Render(w, r, vd)
+}
+
+type QuotaForm struct {
+	QuotaMB string `schema:"quota_mb"`
+}
+
+// UpdateQuota sets the storage quota of a user in megabytes.
+// An empty quota resets the user to the default one.
+//
+// POST /admin/users/:id/quota
+func (a *Admin) UpdateQuota(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	user, err := a.us.ByID(uint(id))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "User not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var form QuotaForm
+	if err := parseForm(r, &form); err != nil {
+		a.renderUsers(w, r, err)
+		return
+	}
+	user.QuotaBytes = nil
+	if quota := strings.TrimSpace(form.QuotaMB); quota != "" {
+		mb, err := strconv.ParseInt(quota, 10, 64)
+		if err != nil || mb < 0 {
+			a.renderUsers(w, r, models.ErrInvalidQuota)
+			return
+		}
+		bytes := mb << 20
+		user.QuotaBytes = &bytes
+	}
+	if err := a.us.Update(user); err != nil {
+		a.renderUsers(w, r, err)
+		return
+	}
+
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Quota of " + user.Email + " updated",
+	}
+	views.RedirectAlert(w, r, "/admin/users", http.StatusFound, alert)
+}
+
+func (a *Admin) renderUsers(w http.ResponseWriter, r *http.Request, err error) {
+	var vd views.Data
+	vd.SetAlert(err)
+	vd.Yield, _ = a.userUsages()
+	a.UsersView.Render(w, r, vd)
+}
+
+func (a *Admin) userUsages() ([]UserUsage, error) {
+	users, err := a.us.All()
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]UserUsage, len(users))
+	for i, user := range users {
+		usage, err := a.is.Usage(&users[i])
+		if err != nil {
+			return nil, err
+		}
+		rows[i] = UserUsage{User: user, Usage: usage}
+	}
+	return rows, nil
+}
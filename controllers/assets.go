@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"photo-gallery/views"
+	"strings"
+	"time"
+)
+
+func NewAssets() *Assets {
+	return &Assets{}
+}
+
+// Assets serves the static files of the assets directory, under their
+// plain names as well as the fingerprinted ones of views.AssetPath.
+type Assets struct{}
+
+// GET /assets/*name
+func (a *Assets) Serve(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, views.AssetsURLPrefix)
+	asset, ok := views.LookupAsset(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(filepath.Join(views.ASSETSDIR, filepath.FromSlash(asset.Name)))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("ETag", asset.ETag)
+	if name == asset.Fingerprinted {
+		w.Header().Set("Cache-Control", cacheForever)
+	} else {
+		w.Header().Set("Cache-Control", cacheRevalidate)
+	}
+	// The content type is told by the plain name, the
+	// fingerprinted one has the same extension.
+	http.ServeContent(w, r, asset.Name, time.Time{}, f)
+}
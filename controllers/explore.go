@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"photo-gallery/models"
+	"photo-gallery/views"
+)
+
+// NewExplore is used to create the controller of the explore page.
+// This function will panic if the templates are not parsed
+// correctly, and should only be used during initial setup.
+func NewExplore(gs models.GalleryService, is models.ImageService) *Explore {
+	return &Explore{
+		IndexView: views.NewView("bootstrap", "explore/index"),
+		gs:        gs,
+		is:        is,
+	}
+}
+
+type Explore struct {
+	IndexView *views.View
+	gs        models.GalleryService
+	is        models.ImageService
+}
+
+// Index lists the public galleries, the ones updated last first or
+// with sort=views the ones seen most often first. Pages go on after
+// the gallery of the cursor in after.
+//
+// GET /explore
+func (e *Explore) Index(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sort := models.GallerySort(query.Get("sort"))
+	if sort == "" {
+		sort = models.SortRecent
+	}
+	page, err := e.gs.Explore(sort, query.Get("after"), models.DefaultExploreLimit)
+	if err != nil {
+		switch err {
+		case models.ErrInvalidCursor, models.ErrInvalidSort:
+			http.Error(w, "Page not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := setCovers(e.is, page.Galleries); err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Yield = page
+	e.IndexView.Render(w, r, vd)
+}
+
+// setCovers sets the covers and numbers of images which
+// the "galleryCards" template shows with the galleries.
+func setCovers(is models.ImageService, galleries []models.Gallery) error {
+	ids := make([]uint, len(galleries))
+	for n := range galleries {
+		ids[n] = galleries[n].ID
+	}
+	counts, err := is.CountByGalleryIDs(ids)
+	if err != nil {
+		return err
+	}
+	for n := range galleries {
+		gallery := &galleries[n]
+		gallery.ImageCount = counts[gallery.ID]
+		if gallery.Cover, err = is.Cover(gallery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,13 +1,21 @@
 package controllers
 
 import (
+	"archive/zip"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
+	"os"
+	"path"
 	"photo-gallery/context"
 	"photo-gallery/models"
+	"photo-gallery/policy"
 	"photo-gallery/views"
 	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/gorilla/mux"
 )
@@ -15,34 +23,70 @@ import (
 const (
 	ShowGallery = "show_gallery"
 	EditGallery = "edit_gallery"
-
-	maxMultiparMem = 15 << 20 // 15 Megabytes
 )
 
-func NewGalleries(gs models.GalleryService, is models.ImageService, r *mux.Router) *Galleries {
+func NewGalleries(gs models.GalleryService, is models.ImageService, sls models.ShareLinkService, ms models.MembershipService, ts models.TagService, r *mux.Router, maxRequestBytes, maxArchiveBytes int64) *Galleries {
+	if maxRequestBytes <= 0 {
+		maxRequestBytes = models.DefaultMaxRequestBytes
+	}
+	if maxArchiveBytes <= 0 {
+		maxArchiveBytes = models.DefaultMaxArchiveBytes
+	}
 	return &Galleries{
-		New:       views.NewView("bootstrap", "galleries/new"),
-		ShowView:  views.NewView("bootstrap", "galleries/show"),
-		EditView:  views.NewView("bootstrap", "galleries/edit"),
-		IndexView: views.NewView("bootstrap", "galleries/index"),
-		gs:        gs,
-		is:        is,
-		r:         r,
+		New:        views.NewView("bootstrap", "galleries/new"),
+		ShowView:   views.NewView("bootstrap", "galleries/show"),
+		EditView:   views.NewView("bootstrap", "galleries/edit"),
+		IndexView:  views.NewView("bootstrap", "galleries/index"),
+		UnlockView: views.NewView("bootstrap", "galleries/unlock"),
+		access:     access{gs, sls, ms},
+		is:         is,
+		ts:         ts,
+		r:          r,
+
+		maxRequestBytes: maxRequestBytes,
+		maxArchiveBytes: maxArchiveBytes,
 	}
 }
 
 type Galleries struct {
-	New       *views.View
-	ShowView  *views.View
-	EditView  *views.View
-	IndexView *views.View
-	gs        models.GalleryService
-	is        models.ImageService
-	r         *mux.Router
+	New        *views.View
+	ShowView   *views.View
+	EditView   *views.View
+	IndexView  *views.View
+	UnlockView *views.View
+	access
+	is models.ImageService
+	ts models.TagService
+	r  *mux.Router
+
+	// maxRequestBytes limits the size of an upload request.
+	maxRequestBytes int64
+	// maxArchiveBytes limits the size of an archive in it,
+	// no more of it is spooled to disk.
+	maxArchiveBytes int64
 }
 
 type GalleryForm struct {
-	Title string `schema:"title"`
+	Title          string `schema:"title"`
+	Slug           string `schema:"slug"`
+	Description    string `schema:"description"`
+	StripGPS       bool   `schema:"strip_gps"`
+	AllowDownloads bool   `schema:"allow_downloads"`
+	Visibility     string `schema:"visibility"`
+	Password       string `schema:"password"`
+	RemovePassword bool   `schema:"remove_password"`
+	Tags           string `schema:"tags"`
+}
+
+type UnlockForm struct {
+	Password string `schema:"password"`
+}
+
+type ImageForm struct {
+	Title   string `schema:"title"`
+	Caption string `schema:"caption"`
+	AltText string `schema:"alt_text"`
+	Tags    string `schema:"tags"`
 }
 
 // POST /galleries
@@ -56,8 +100,14 @@ func (g *Galleries) Create(w http.ResponseWriter, r *http.Request) {
 	}
 	user := context.User(r.Context())
 	gallery := models.Gallery{
-		Title:  form.Title,
-		UserID: user.ID,
+		Title:          form.Title,
+		Slug:           form.Slug,
+		Description:    form.Description,
+		UserID:         user.ID,
+		StripGPS:       form.StripGPS,
+		AllowDownloads: form.AllowDownloads,
+		Visibility:     form.Visibility,
+		Password:       form.Password,
 	}
 	if err := g.gs.Create(&gallery); err != nil {
 		vd.SetAlert(err)
@@ -74,73 +124,185 @@ func (g *Galleries) Create(w http.ResponseWriter, r *http.Request) {
 }
 
 // POST /galleries/:id/images
+//
+// The files are read one at a time and streamed to storage as they
+// arrive, so an upload is never held in memory as a whole.
 func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r)
+	gallery, v, err := g.galleryByID(w, r)
 	if err != nil {
 		return
 	}
-
-	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
-		http.Error(w, "Gallery not found", http.StatusNotFound)
+	if !allowed(w, policy.CanUpload(gallery, v)) {
 		return
 	}
 
+	user := context.User(r.Context())
+
 	var vd views.Data
 	vd.Yield = gallery
-	err = r.ParseMultipartForm(maxMultiparMem)
+	if r.ContentLength > g.maxRequestBytes {
+		vd.Status = http.StatusRequestEntityTooLarge
+		vd.SetAlert(models.ErrUploadTooLarge)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, g.maxRequestBytes)
+	mr, err := r.MultipartReader()
 	if err != nil {
 		vd.SetAlert(err)
 		g.EditView.Render(w, r, vd)
 		return
 	}
 
-	files := r.MultipartForm.File["images"]
-	for _, f := range files {
-		file, err := f.Open()
+	// A file which fails doesn't stop the rest of the upload, every
+	// file is listed with its outcome to the user at the end.
+	var results []models.ImportResult
+	var readErr error
+	archives := false
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			vd.SetAlert(err)
-			g.EditView.Render(w, r, vd)
-			return
+			readErr = err
+			break
+		}
+		if part.FormName() != "images" || part.FileName() == "" {
+			continue
+		}
+		image := models.Image{
+			GalleryID:        gallery.ID,
+			UserID:           user.ID,
+			OriginalFilename: part.FileName(),
+		}
+		if isArchive(part.FileName()) {
+			archives = true
+			results = append(results, g.importArchive(image, part)...)
+			continue
 		}
-		defer file.Close()
-		err = g.is.Create(gallery.ID, file, f.Filename)
+		err = g.is.Create(&image, part)
+		results = append(results, models.ImportResult{
+			Name:  part.FileName(),
+			Image: &image,
+			Err:   err,
+		})
+	}
+
+	var rejected models.UploadError
+	for _, res := range results {
+		if !res.Imported() {
+			if _, ok := res.Err.(views.PublicError); !ok {
+				log.Println(res.Err)
+			}
+			rejected = append(rejected, models.FileError{Filename: res.Name, Err: res.Err})
+		}
+	}
+	if readErr == nil && len(rejected) == 0 && !archives {
+		galleryUrl, err := g.r.Get(EditGallery).URL("id", fmt.Sprintf("%v", gallery.ID))
 		if err != nil {
-			vd.SetAlert(err)
-			g.EditView.Render(w, r, vd)
+			log.Println(err)
+			http.Redirect(w, r, "/galleries", http.StatusFound)
 			return
 		}
+		http.Redirect(w, r, galleryUrl.Path, http.StatusFound)
+		return
 	}
-	galleryUrl, err := g.r.Get(EditGallery).URL("id", fmt.Sprintf("%v", gallery.ID))
-	if err != nil {
+
+	gallery.Images, _ = g.is.ByGalleryID(gallery.ID)
+	if err := g.setImageTags(gallery.Images); err != nil {
 		log.Println(err)
-		http.Redirect(w, r, "/galleries", http.StatusFound)
-		return
 	}
-	http.Redirect(w, r, galleryUrl.Path, http.StatusFound)
+	gallery.Imports = results
+	switch {
+	case readErr != nil:
+		log.Println(readErr)
+		vd.SetAlert(models.ErrUploadInterrupted)
+	case len(rejected) > 0:
+		vd.SetAlert(rejected)
+	default:
+		vd.Alert = &views.Alert{
+			Level:   views.AlertLvlSuccess,
+			Message: uploadSummary(results),
+		}
+	}
+	g.EditView.Render(w, r, vd)
+}
+
+// importArchive spools an uploaded archive to a temporary file, as
+// reading a ZIP archive takes random access, and imports its images.
+// The entries are named after the archive so they can be told apart
+// from the other files of the upload.
+func (g *Galleries) importArchive(image models.Image, part io.Reader) []models.ImportResult {
+	archive := image.OriginalFilename
+	failed := func(err error) []models.ImportResult {
+		return []models.ImportResult{{Name: archive, Err: err}}
+	}
+
+	f, err := os.CreateTemp("", "upload-*.zip")
+	if err != nil {
+		return failed(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	size, err := io.Copy(f, io.LimitReader(part, g.maxArchiveBytes+1))
+	if err != nil {
+		return failed(err)
+	}
+	if size > g.maxArchiveBytes {
+		return failed(models.ErrArchiveTooLarge)
+	}
+
+	results, err := g.is.Import(image, f, size)
+	for i := range results {
+		results[i].Name = archive + "/" + results[i].Name
+	}
+	if err != nil {
+		results = append(results, failed(err)...)
+	}
+	return results
+}
+
+// isArchive reports whether an uploaded file is
+// a ZIP archive of images rather than an image.
+func isArchive(filename string) bool {
+	return strings.EqualFold(path.Ext(filename), ".zip")
+}
+
+func uploadSummary(results []models.ImportResult) string {
+	uploaded := 0
+	for _, res := range results {
+		if res.Imported() {
+			uploaded++
+		}
+	}
+	return fmt.Sprintf("Uploaded %d of %d files", uploaded, len(results))
 }
 
 // POST /galleries/:id/images/:filename/delete
 func (g *Galleries) ImageDelete(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r)
+	gallery, v, err := g.galleryByID(w, r)
 	if err != nil {
 		return
 	}
 
-	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
-		http.Error(w, "Gallery not found", http.StatusNotFound)
+	imageFilename := mux.Vars(r)["filename"]
+	i, err := g.is.ByFilename(gallery.ID, imageFilename)
+	if err != nil {
+		switch err {
+		case models.ErrNotFound, models.ErrInvalidFilename:
+			http.Error(w, "Image not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		}
 		return
 	}
-
-	imageFilename := mux.Vars(r)["filename"]
-
-	i := models.Image{
-		Filename:  imageFilename,
-		GalleryID: gallery.ID,
+	if !allowed(w, policy.CanDeleteImage(gallery, i, v)) {
+		return
 	}
 
-	err = g.is.Delete(&i)
+	err = g.is.Delete(i)
 	if err != nil {
 		var vd views.Data
 		vd.Yield = gallery
@@ -157,16 +319,134 @@ func (g *Galleries) ImageDelete(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, url.Path, http.StatusFound)
 }
 
+// POST /galleries/:id/images/:filename
+func (g *Galleries) ImageUpdate(w http.ResponseWriter, r *http.Request) {
+	gallery, v, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	imageFilename := mux.Vars(r)["filename"]
+	i, err := g.is.ByFilename(gallery.ID, imageFilename)
+	if err != nil {
+		switch err {
+		case models.ErrNotFound, models.ErrInvalidFilename:
+			http.Error(w, "Image not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		}
+		return
+	}
+	if !allowed(w, policy.CanEditImage(gallery, i, v)) {
+		return
+	}
+
+	var vd views.Data
+	vd.Yield = gallery
+	var form ImageForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	i.Title = form.Title
+	i.Caption = form.Caption
+	i.AltText = form.AltText
+	if err := g.is.Update(i); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	if err := g.ts.SetImageTags(i.ID, models.ParseTags(form.Tags)); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	g.redirectToEdit(w, r, gallery)
+}
+
+// POST /galleries/:id/images/order
+func (g *Galleries) ImageOrder(w http.ResponseWriter, r *http.Request) {
+	gallery, v, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	if !allowed(w, policy.CanEdit(gallery, v)) {
+		return
+	}
+
+	var vd views.Data
+	vd.Yield = gallery
+	if err := r.ParseForm(); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	// The form has a position_<image ID> field for every image.
+	positions := make(map[uint]int)
+	for _, image := range gallery.Images {
+		value := r.PostForm.Get(fmt.Sprintf("position_%v", image.ID))
+		if value == "" {
+			continue
+		}
+		pos, err := strconv.Atoi(value)
+		if err != nil {
+			vd.AlertError("Positions must be numbers")
+			g.EditView.Render(w, r, vd)
+			return
+		}
+		positions[image.ID] = pos
+	}
+	if err := g.is.Reorder(gallery.ID, positions); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	g.redirectToEdit(w, r, gallery)
+}
+
+// POST /galleries/:id/images/:filename/cover
+func (g *Galleries) ImageCover(w http.ResponseWriter, r *http.Request) {
+	gallery, v, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	if !allowed(w, policy.CanEdit(gallery, v)) {
+		return
+	}
+
+	imageFilename := mux.Vars(r)["filename"]
+	i, err := g.is.ByFilename(gallery.ID, imageFilename)
+	if err != nil {
+		switch err {
+		case models.ErrNotFound, models.ErrInvalidFilename:
+			http.Error(w, "Image not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	gallery.CoverImageID = &i.ID
+	if err := g.gs.Update(gallery); err != nil {
+		var vd views.Data
+		vd.Yield = gallery
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	g.redirectToEdit(w, r, gallery)
+}
+
 // POST /galleries/:id/delete
 func (g *Galleries) Delete(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r)
+	gallery, v, err := g.galleryByID(w, r)
 	if err != nil {
 		return
 	}
-
-	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
-		http.Error(w, "Gallery not found", http.StatusNotFound)
+	if !allowed(w, policy.CanDelete(gallery, v)) {
 		return
 	}
 
@@ -178,22 +458,306 @@ func (g *Galleries) Delete(w http.ResponseWriter, r *http.Request) {
 		g.EditView.Render(w, r, vd)
 		return
 	}
-	http.Redirect(w, r, "/galleries", http.StatusFound)
+	if err := g.is.TrashGallery(gallery.ID); err != nil {
+		log.Println(err)
+	}
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Gallery moved to the trash",
+	}
+	views.RedirectAlert(w, r, "/galleries", http.StatusFound, alert)
 }
 
+// Show redirects to where the gallery is shown now, a
+// gallery is only shown under its number when it has no slug.
+//
 // GET /galleries/:id
 func (g *Galleries) Show(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r)
+	gallery, v, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	if g.moved(w, r, gallery, v, "") {
+		return
+	}
+	g.show(w, r, gallery, v)
+}
+
+// ShowByPath shows a gallery under the username of its owner and its
+// slug, which can be guessed like its number.
+//
+// GET /u/:username/:slug
+func (g *Galleries) ShowByPath(w http.ResponseWriter, r *http.Request) {
+	gallery, v, err := g.galleryByPath(w, r, "")
+	if err != nil {
+		return
+	}
+	g.show(w, r, gallery, v)
+}
+
+// ShowShared shows an unlisted gallery to whoever has its link.
+//
+// GET /s/:slug
+func (g *Galleries) ShowShared(w http.ResponseWriter, r *http.Request) {
+	gallery, v, err := g.galleryBySlug(w, r)
+	if err != nil {
+		return
+	}
+	g.show(w, r, gallery, v)
+}
+
+// ShowLink shows the gallery of a share link and counts the view. The
+// link opens the gallery whatever its visibility and password, and a
+// cookie lets the visitor load its images afterwards.
+//
+// GET /l/:token
+func (g *Galleries) ShowLink(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	link, err := g.sls.Visit(token)
+	if err != nil {
+		g.linkError(w, err)
+		return
+	}
+	gallery, v, err := g.galleryByLink(w, r, link)
+	if err != nil {
+		return
+	}
+	gallery.ShareToken = token
+	setShareLinkCookie(w, link, token)
+	g.show(w, r, gallery, v)
+}
+
+func (g *Galleries) linkError(w http.ResponseWriter, err error) {
+	switch err {
+	case models.ErrNotFound:
+		http.Error(w, "This link has expired or was revoked", http.StatusNotFound)
+	default:
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+	}
+}
+
+// show asks for the password of the gallery first, unless the visitor
+// gave it already. Views of others than the owner are counted.
+func (g *Galleries) show(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, v policy.Visitor) {
+	var vd views.Data
+	switch d := policy.CanView(gallery, v); d {
+	case policy.Allow:
+		if v.Role != models.RoleOwner {
+			if err := g.gs.AddView(gallery.ID); err != nil {
+				log.Println(err)
+			}
+		}
+		vd.Yield = gallery
+		g.ShowView.Render(w, r, vd)
+	case policy.Locked:
+		gallery.Images = nil
+		vd.Yield = gallery
+		g.UnlockView.Render(w, r, vd)
+	default:
+		allowed(w, d)
+	}
+}
+
+// Unlock lets the visitor see a gallery with a password
+// once they give it, until the cookie expires.
+//
+// POST /galleries/:id/unlock
+func (g *Galleries) Unlock(w http.ResponseWriter, r *http.Request) {
+	gallery, v, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	g.unlock(w, r, gallery, v)
+}
+
+// POST /u/:username/:slug/unlock
+func (g *Galleries) UnlockByPath(w http.ResponseWriter, r *http.Request) {
+	gallery, v, err := g.galleryByPath(w, r, "/unlock")
 	if err != nil {
 		return
 	}
+	g.unlock(w, r, gallery, v)
+}
+
+// POST /s/:slug/unlock
+func (g *Galleries) UnlockShared(w http.ResponseWriter, r *http.Request) {
+	gallery, v, err := g.galleryBySlug(w, r)
+	if err != nil {
+		return
+	}
+	g.unlock(w, r, gallery, v)
+}
 
+// unlock takes the password of a gallery the visitor could see
+// if it wasn't for the password, or can see already.
+func (g *Galleries) unlock(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, v policy.Visitor) {
+	if d := policy.CanView(gallery, v); d != policy.Allow && d != policy.Locked {
+		allowed(w, d)
+		return
+	}
+	gallery.Images = nil
 	var vd views.Data
 	vd.Yield = gallery
-	g.ShowView.Render(w, r, vd)
+	var form UnlockForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		g.UnlockView.Render(w, r, vd)
+		return
+	}
+	token, err := g.gs.Unlock(gallery, form.Password)
+	if err != nil {
+		vd.SetAlert(err)
+		g.UnlockView.Render(w, r, vd)
+		return
+	}
+	setUnlockCookie(w, gallery, token)
+	http.Redirect(w, r, gallery.Path(), http.StatusFound)
+}
+
+// Download streams a ZIP of all originals of the gallery. Originals are
+// stored as they are, since images are compressed already, and the
+// archive is written straight to the response.
+//
+// GET /galleries/:id/download
+func (g *Galleries) Download(w http.ResponseWriter, r *http.Request) {
+	gallery, v, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	if g.moved(w, r, gallery, v, "/download") {
+		return
+	}
+	g.download(w, r, gallery, v)
+}
 
+// GET /u/:username/:slug/download
+func (g *Galleries) DownloadByPath(w http.ResponseWriter, r *http.Request) {
+	gallery, v, err := g.galleryByPath(w, r, "/download")
+	if err != nil {
+		return
+	}
+	g.download(w, r, gallery, v)
+}
+
+// GET /s/:slug/download
+func (g *Galleries) DownloadShared(w http.ResponseWriter, r *http.Request) {
+	gallery, v, err := g.galleryBySlug(w, r)
+	if err != nil {
+		return
+	}
+	g.download(w, r, gallery, v)
 }
 
+// A share link opens the gallery whatever its visibility and password,
+// downloading through it doesn't count as a view. A link which ran out
+// of views only downloads it while its images load, see galleryShared.
+//
+// GET /l/:token/download
+func (g *Galleries) DownloadLink(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	link, err := g.sls.ByToken(token)
+	if err == nil && !link.Visiting() {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		g.linkError(w, err)
+		return
+	}
+	gallery, v, err := g.galleryByLink(w, r, link)
+	if err != nil {
+		return
+	}
+	gallery.ShareToken = token
+	g.download(w, r, gallery, v)
+}
+
+// download sends visitors who have yet to give the
+// password of the gallery to where they can give it.
+func (g *Galleries) download(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, v policy.Visitor) {
+	switch d := policy.CanDownload(gallery, v); d {
+	case policy.Allow:
+	case policy.Locked:
+		http.Redirect(w, r, gallery.Path(), http.StatusFound)
+		return
+	default:
+		allowed(w, d)
+		return
+	}
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{
+		"filename": archiveName(gallery.Title) + ".zip",
+	})
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", disposition)
+
+	zw := zip.NewWriter(w)
+	names := make(map[string]bool)
+	for n := range gallery.Images {
+		image := &gallery.Images[n]
+		if err := g.writeZipEntry(zw, image, uniqueName(names, image)); err != nil {
+			// The response has been started, so all that is left
+			// to do is to cut the archive short.
+			log.Println(err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Println(err)
+	}
+}
+
+func (g *Galleries) writeZipEntry(zw *zip.Writer, image *models.Image, name string) error {
+	blob, err := g.is.Open(image)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: image.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, blob)
+	return err
+}
+
+// archiveName turns the title of a gallery into a filename.
+func archiveName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(title))
+	if name == "" {
+		return "gallery"
+	}
+	return name
+}
+
+// uniqueName returns the original filename of the image, numbered
+// like "photo (2).jpg" when the archive has a file of that name already.
+func uniqueName(names map[string]bool, image *models.Image) string {
+	name := image.OriginalFilename
+	if name == "" {
+		name = image.Filename
+	}
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for n := 2; names[strings.ToLower(name)]; n++ {
+		name = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+	names[strings.ToLower(name)] = true
+	return name
+}
+
+// Index lists the galleries of the user, and the
+// ones of others the user is a member of.
+//
 // GET /galleries
 func (g *Galleries) Index(w http.ResponseWriter, r *http.Request) {
 
@@ -204,21 +768,84 @@ func (g *Galleries) Index(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
+	for n := range galleries {
+		setPermissions(&galleries[n], policy.Visitor{User: user, Role: models.RoleOwner})
+	}
+	shared, err := g.memberGalleries(user)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	usage, err := g.is.Usage(user)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	var all []*models.Gallery
+	for _, list := range [][]models.Gallery{galleries, shared} {
+		for n := range list {
+			all = append(all, &list[n])
+		}
+	}
+	if err := g.gs.SetOwners(all...); err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	for _, list := range [][]models.Gallery{galleries, shared} {
+		for n := range list {
+			list[n].Cover, err = g.is.Cover(&list[n])
+			if err != nil {
+				log.Println(err)
+				http.Error(w, "Something went wrong", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
 	var vd views.Data
-	vd.Yield = galleries
+	vd.Yield = struct {
+		Galleries []models.Gallery
+		Shared    []models.Gallery
+		Usage     *models.Usage
+	}{galleries, shared, usage}
 	g.IndexView.Render(w, r, vd)
 }
 
+// memberGalleries returns the galleries the user accepted to be a
+// member of, leaving out those in the trash of their owners.
+func (g *Galleries) memberGalleries(user *models.User) ([]models.Gallery, error) {
+	memberships, err := g.ms.ByUserID(user.ID, true)
+	if err != nil {
+		return nil, err
+	}
+	var galleries []models.Gallery
+	for _, m := range memberships {
+		gallery, err := g.gs.ByID(m.GalleryID)
+		if err == models.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		setPermissions(gallery, policy.Visitor{User: user, Role: m.Role})
+		galleries = append(galleries, *gallery)
+	}
+	return galleries, nil
+}
+
+// Edit shows the page where images are uploaded and changed, which
+// everyone who can add images to the gallery sees. Its settings are
+// only shown to those who can change them.
+//
 // GET /galleries/:id/edit
 func (g *Galleries) Edit(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r)
+	gallery, v, err := g.galleryByID(w, r)
 	if err != nil {
 		return
 	}
-
-	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
-		http.Error(w, "Gallery not found", http.StatusNotFound)
+	if !allowed(w, policy.CanUpload(gallery, v)) {
 		return
 	}
 	var vd views.Data
@@ -228,14 +855,11 @@ func (g *Galleries) Edit(w http.ResponseWriter, r *http.Request) {
 
 // POST /galleries/:id/update
 func (g *Galleries) Update(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r)
+	gallery, v, err := g.galleryByID(w, r)
 	if err != nil {
 		return
 	}
-
-	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
-		http.Error(w, "Gallery not found", http.StatusNotFound)
+	if !allowed(w, policy.CanEdit(gallery, v)) {
 		return
 	}
 
@@ -248,13 +872,38 @@ func (g *Galleries) Update(w http.ResponseWriter, r *http.Request) {
 		g.EditView.Render(w, r, vd)
 		return
 	}
+	scrub := form.StripGPS && !gallery.StripGPS
 	gallery.Title = form.Title
+	gallery.Slug = form.Slug
+	gallery.Description = form.Description
+	gallery.StripGPS = form.StripGPS
+	gallery.AllowDownloads = form.AllowDownloads
+	gallery.Visibility = form.Visibility
+	gallery.Password = form.Password
+	if form.RemovePassword {
+		gallery.PasswordHash = ""
+	}
 	err = g.gs.Update(gallery)
+	if err == nil {
+		err = g.ts.SetGalleryTags(gallery.ID, models.ParseTags(form.Tags))
+	}
+	if err == nil {
+		gallery.Tags, err = g.ts.ByGalleryID(gallery.ID)
+	}
 	if err != nil {
 		vd.SetAlert(err)
 		g.EditView.Render(w, r, vd)
 		return
 	}
+	if scrub {
+		// Images uploaded from now on are scrubbed on upload,
+		// the ones already in the gallery have to catch up.
+		if err := g.is.ScrubGallery(gallery.ID); err != nil {
+			vd.SetAlert(err)
+			g.EditView.Render(w, r, vd)
+			return
+		}
+	}
 	vd.Alert = &views.Alert{
 		Level:   views.AlertLvlSuccess,
 		Message: "Gallery successfully updated",
@@ -262,14 +911,26 @@ func (g *Galleries) Update(w http.ResponseWriter, r *http.Request) {
 	g.EditView.Render(w, r, vd)
 }
 
-func (g *Galleries) galleryByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
+func (g *Galleries) redirectToEdit(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) {
+	url, err := g.r.Get(EditGallery).URL("id", fmt.Sprintf("%v", gallery.ID))
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/galleries", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, url.Path, http.StatusFound)
+}
+
+// galleryByID looks up the gallery of the URL, what the
+// visitor may do with it is up to the caller to check.
+func (g *Galleries) galleryByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, policy.Visitor, error) {
 	vars := mux.Vars(r)
 	idStr := vars["id"]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Invalid gallery ID", http.StatusNotFound)
-		return nil, err
+		return nil, policy.Visitor{}, err
 	}
 	gallery, err := g.gs.ByID(uint(id))
 	if err != nil {
@@ -280,10 +941,122 @@ func (g *Galleries) galleryByID(w http.ResponseWriter, r *http.Request) (*models
 			log.Println(err)
 			http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		}
-		return nil, err
+		return nil, policy.Visitor{}, err
+	}
+	return g.withVisitor(w, r, gallery, viaID)
+}
+
+// galleryByPath looks up the gallery of a /u/:username/:slug URL.
+// Visitors who may know the gallery exists are redirected from a slug
+// it had before to where it is now, suffix is the page of the gallery
+// they asked for.
+func (g *Galleries) galleryByPath(w http.ResponseWriter, r *http.Request, suffix string) (*models.Gallery, policy.Visitor, error) {
+	vars := mux.Vars(r)
+	gallery, err := g.gs.BySlug(vars["username"], vars["slug"])
+	previous := false
+	if err == models.ErrNotFound {
+		gallery, err = g.gs.ByPreviousSlug(vars["username"], vars["slug"])
+		previous = true
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		}
+		return nil, policy.Visitor{}, err
+	}
+	gallery, v, err := g.withVisitor(w, r, gallery, viaID)
+	if err != nil || !previous {
+		return gallery, v, err
+	}
+	if !g.moved(w, r, gallery, v, suffix) {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+	}
+	return nil, policy.Visitor{}, models.ErrNotFound
+}
+
+// moved redirects visitors who may know the gallery exists to its Path
+// when they asked for it elsewhere, and reports whether it did.
+func (g *Galleries) moved(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, v policy.Visitor, suffix string) bool {
+	if policy.CanView(gallery, v) == policy.NotFound {
+		return false
+	}
+	path := gallery.Path() + suffix
+	if path == r.URL.EscapedPath() {
+		return false
+	}
+	http.Redirect(w, r, path, http.StatusMovedPermanently)
+	return true
+}
+
+// galleryByLink looks up the gallery of a share link.
+func (g *Galleries) galleryByLink(w http.ResponseWriter, r *http.Request, link *models.ShareLink) (*models.Gallery, policy.Visitor, error) {
+	gallery, err := g.gs.ByID(link.GalleryID)
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		}
+		return nil, policy.Visitor{}, err
 	}
+	return g.withVisitor(w, r, gallery, viaShareLink)
+}
 
-	images, _ := g.is.ByGalleryID(gallery.ID)
-	gallery.Images = images
-	return gallery, nil
+// galleryBySlug looks up a gallery by its share slug.
+func (g *Galleries) galleryBySlug(w http.ResponseWriter, r *http.Request) (*models.Gallery, policy.Visitor, error) {
+	gallery, err := g.gs.ByShareSlug(mux.Vars(r)["slug"])
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		}
+		return nil, policy.Visitor{}, err
+	}
+	return g.withVisitor(w, r, gallery, viaLink)
+}
+
+// withVisitor works out who is asking for the gallery, and
+// loads its images, owner and tags.
+func (g *Galleries) withVisitor(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, via via) (*models.Gallery, policy.Visitor, error) {
+	v, err := g.visitor(r, gallery, via)
+	if err == nil {
+		err = g.gs.SetOwners(gallery)
+	}
+	if err == nil {
+		gallery.Tags, err = g.ts.ByGalleryID(gallery.ID)
+	}
+	if err == nil {
+		gallery.Images, _ = g.is.ByGalleryID(gallery.ID)
+		err = g.setImageTags(gallery.Images)
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return nil, policy.Visitor{}, err
+	}
+	return gallery, v, nil
+}
+
+func (g *Galleries) setImageTags(images []models.Image) error {
+	ids := make([]uint, len(images))
+	for n := range images {
+		ids[n] = images[n].ID
+	}
+	tags, err := g.ts.ByImageIDs(ids)
+	if err != nil {
+		return err
+	}
+	for n := range images {
+		images[n].Tags = tags[images[n].ID]
+	}
+	return nil
 }
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"photo-gallery/context"
+	"photo-gallery/models"
+	"photo-gallery/views"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// An archive over the limit is rejected before it is imported,
+// fakeImages would panic on Import.
+func TestImportArchiveTooLarge(t *testing.T) {
+	g := &Galleries{is: fakeImages{}, maxArchiveBytes: 10}
+	image := models.Image{GalleryID: 1, UserID: 1, OriginalFilename: "photos.zip"}
+	results := g.importArchive(image, strings.NewReader(strings.Repeat("x", 11)))
+	if len(results) != 1 || results[0].Name != "photos.zip" || results[0].Err != models.ErrArchiveTooLarge {
+		t.Errorf("importArchive = %+v, want the archive rejected as too large", results)
+	}
+}
+
+// editGalleries loads a gallery without images or tags,
+// as withVisitor does for the edit page.
+type editGalleries struct {
+	fakeGalleries
+}
+
+func (editGalleries) SetOwners(galleries ...*models.Gallery) error {
+	return nil
+}
+
+type editImages struct {
+	models.ImageService
+}
+
+func (editImages) ByGalleryID(galleryID uint) ([]models.Image, error) {
+	return nil, nil
+}
+
+type editTags struct {
+	models.TagService
+}
+
+func (editTags) ByGalleryID(galleryID uint) (models.Tags, error) {
+	return nil, nil
+}
+
+func (editTags) ByImageIDs(imageIDs []uint) (map[uint]models.Tags, error) {
+	return nil, nil
+}
+
+// The page of an upload over the limit is sent with its status and
+// headers, rather than after a bare WriteHeader.
+func TestImageUploadTooLarge(t *testing.T) {
+	owner := &models.User{Username: "owner"}
+	owner.ID = 1
+	gallery := &models.Gallery{UserID: owner.ID}
+	gallery.ID = 7
+	g := &Galleries{
+		EditView: &views.View{
+			Template: template.Must(template.New("").Parse(`{{define "bootstrap"}}{{.Alert.Message}}{{end}}`)),
+			Layout:   "bootstrap",
+		},
+		access:          access{gs: editGalleries{fakeGalleries{gallery: gallery}}, ms: fakeMemberships{}},
+		is:              editImages{},
+		ts:              editTags{},
+		maxRequestBytes: 10,
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/galleries/7/images", strings.NewReader(strings.Repeat("x", 11)))
+	r = mux.SetURLVars(r, map[string]string{"id": "7"})
+	r = r.WithContext(context.WithUser(r.Context(), owner))
+	w := httptest.NewRecorder()
+	g.ImageUpload(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	if ct := w.Result().Header.Get("Content-Type"); ct != "text/html" {
+		t.Errorf("Content-Type = %q, want text/html", ct)
+	}
+	if !strings.Contains(w.Body.String(), models.ErrUploadTooLarge.Public()) {
+		t.Errorf("body = %q, want the upload limit", w.Body.String())
+	}
+}
@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"photo-gallery/models"
+	"photo-gallery/policy"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// cacheForever is used for responses whose URL
+	// changes when their content does.
+	cacheForever = "public, max-age=31536000, immutable"
+	// cacheRevalidate lets the browser keep a response
+	// but ask whether it changed every time it is used.
+	cacheRevalidate = "no-cache"
+
+	// The same for responses which shared caches
+	// must not keep, as not everyone may see them.
+	cachePrivateForever    = "private, max-age=31536000, immutable"
+	cachePrivateRevalidate = "private, no-cache"
+)
+
+func NewImages(gs models.GalleryService, is models.ImageService, sls models.ShareLinkService, ms models.MembershipService, store models.BlobStore) *Images {
+	return &Images{
+		access: access{gs, sls, ms},
+		is:     is,
+		store:  store,
+	}
+}
+
+// Images serves the bytes of images and their
+// renditions from the BlobStore.
+type Images struct {
+	access
+	is    models.ImageService
+	store models.BlobStore
+}
+
+// GET /images/*key
+//
+// Responses carry the checksum of the image as their ETag. The URLs
+// of images have the version of their content in them, requests for
+// the current version can be cached for good.
+func (i *Images) Serve(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, models.ImagesURLPrefix)
+	if !strings.HasPrefix(key, "galleries/") {
+		http.NotFound(w, r)
+		return
+	}
+	image, rendition, err := i.is.ByKey(key)
+	if err != nil {
+		switch err {
+		case models.ErrNotFound, models.ErrInvalidKey, models.ErrInvalidFilename:
+			http.NotFound(w, r)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// The files of a private gallery are not found by anyone but its
+	// owner and members, even though their names can't be guessed,
+	// nor are the ones of a locked gallery. A share link opens either.
+	gallery, err := i.gs.ByID(image.GalleryID)
+	var v policy.Visitor
+	if err == nil {
+		v, err = i.visitor(r, gallery, viaImage)
+	}
+	if err == nil && !policy.CanView(gallery, v).Allowed() {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.NotFound(w, r)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	etag := image.ETag(rendition)
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	w.Header().Set("Cache-Control", cacheControl(gallery, image, r.URL.Query().Get("v")))
+	// Answered before the blob is fetched, which
+	// may well be a round trip to S3.
+	if etag != "" && etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if rg, ok := i.store.(models.RangeGetter); ok {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if rng := rangeOf(r, etag); rng != "" {
+			i.serveRange(w, r, rg, key, rng)
+			return
+		}
+	}
+
+	blob, err := i.store.Get(key)
+	if err != nil {
+		blobError(w, r, err)
+		return
+	}
+	defer blob.Close()
+	setContentType(w, key)
+
+	// Range requests need to seek in the blob, which blobs on disk
+	// can do. Stores which can't seek fetch the range instead.
+	if rs, ok := blob.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", time.Time{}, rs)
+		return
+	}
+	io.Copy(w, blob)
+}
+
+// serveRange answers a Range request with the part of the blob the
+// store returns, which is the whole blob for ranges it doesn't support.
+func (i *Images) serveRange(w http.ResponseWriter, r *http.Request, rg models.RangeGetter, key, rng string) {
+	part, err := rg.GetRange(key, rng)
+	if err == models.ErrRangeNotSatisfiable {
+		http.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if err != nil {
+		blobError(w, r, err)
+		return
+	}
+	defer part.Close()
+	setContentType(w, key)
+	if part.Length >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(part.Length, 10))
+	}
+	if part.ContentRange != "" {
+		w.Header().Set("Content-Range", part.ContentRange)
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	io.Copy(w, part)
+}
+
+// rangeOf returns the Range header of the request, unless an If-Range
+// header asks for the whole image when it changed, as http.ServeContent
+// does. Dates are not compared, the image has no modification time.
+func rangeOf(r *http.Request, etag string) string {
+	rng := r.Header.Get("Range")
+	if rng == "" || r.Method != http.MethodGet {
+		return ""
+	}
+	if ir := r.Header.Get("If-Range"); ir != "" && (etag == "" || ir != etag) {
+		return ""
+	}
+	return rng
+}
+
+func blobError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case models.ErrNotFound, models.ErrInvalidKey:
+		http.NotFound(w, r)
+	default:
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+	}
+}
+
+// setContentType only lets the browser render images, anything else
+// which made it to the store is downloaded instead.
+func setContentType(w http.ResponseWriter, key string) {
+	ct := mime.TypeByExtension(path.Ext(key))
+	if !strings.HasPrefix(ct, "image/") || strings.HasPrefix(ct, "image/svg") {
+		ct = "application/octet-stream"
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
+
+// cacheControl tells how long the response for the version of the
+// image may be kept, and who may keep it.
+func cacheControl(gallery *models.Gallery, image *models.Image, version string) string {
+	current := version != "" && version == image.Version()
+	if gallery.Visibility == models.VisibilityPublic && !gallery.HasPassword() {
+		if current {
+			return cacheForever
+		}
+		return cacheRevalidate
+	}
+	if current {
+		return cachePrivateForever
+	}
+	return cachePrivateRevalidate
+}
+
+// etagMatch reports whether an If-None-Match header lists the
+// entity tag. It compares weakly, as RFC 9110 asks for.
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
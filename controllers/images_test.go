@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"photo-gallery/middleware"
+	"photo-gallery/models"
+	"strings"
+	"testing"
+)
+
+// The fakes implement only what serving an image uses, anything
+// else panics on the nil interface they embed.
+
+type fakeUsers struct {
+	models.UserService
+	token string
+	user  *models.User
+}
+
+func (fu fakeUsers) ByRememberedToken(token string) (*models.User, error) {
+	if token != fu.token {
+		return nil, models.ErrNotFound
+	}
+	return fu.user, nil
+}
+
+type fakeGalleries struct {
+	models.GalleryService
+	gallery *models.Gallery
+}
+
+func (fg fakeGalleries) ByID(id uint) (*models.Gallery, error) {
+	if id != fg.gallery.ID {
+		return nil, models.ErrNotFound
+	}
+	g := *fg.gallery
+	return &g, nil
+}
+
+type fakeImages struct {
+	models.ImageService
+	image *models.Image
+}
+
+func (fi fakeImages) TrashedByID(id uint) (*models.Image, error) {
+	if id != fi.image.ID {
+		return nil, models.ErrNotFound
+	}
+	return fi.image, nil
+}
+
+func (fi fakeImages) ByKey(key string) (*models.Image, string, error) {
+	if key != fi.image.Key() {
+		return nil, "", models.ErrNotFound
+	}
+	return fi.image, "", nil
+}
+
+// fakeMemberships gives the users in roles their role in
+// every gallery, besides the owners.
+type fakeMemberships struct {
+	models.MembershipService
+	roles map[uint]models.Role
+}
+
+func (fm fakeMemberships) RoleOf(gallery *models.Gallery, user *models.User) (models.Role, error) {
+	if user == nil {
+		return models.RoleNone, nil
+	}
+	if user.ID == gallery.UserID {
+		return models.RoleOwner, nil
+	}
+	return fm.roles[user.ID], nil
+}
+
+func TestServePrivateImage(t *testing.T) {
+	owner := &models.User{Username: "owner"}
+	owner.ID = 1
+	gallery := &models.Gallery{UserID: owner.ID, Visibility: models.VisibilityPrivate}
+	gallery.ID = 7
+	image := &models.Image{GalleryID: gallery.ID, Filename: "photo.jpg"}
+
+	store := models.NewDiskStore(t.TempDir())
+	if err := store.Put(image.Key(), strings.NewReader("jpeg")); err != nil {
+		t.Fatal(err)
+	}
+	imagesC := NewImages(fakeGalleries{gallery: gallery}, fakeImages{image: image}, nil, fakeMemberships{}, store)
+	userMw := middleware.User{UserService: fakeUsers{token: "remembered", user: owner}}
+	handler := userMw.ApplyFn(imagesC.Serve)
+
+	tests := []struct {
+		name   string
+		cookie string
+		status int
+	}{
+		{"owner", "remembered", http.StatusOK},
+		{"signed out", "", http.StatusNotFound},
+		{"someone else", "forgotten", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, image.Path(), nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "remember_token", Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.status == http.StatusOK && w.Body.String() != "jpeg" {
+				t.Errorf("body = %q, want %q", w.Body.String(), "jpeg")
+			}
+		})
+	}
+}
+
+func TestServeRangeFromS3(t *testing.T) {
+	const content = "jpeg"
+	s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Range") {
+		case "":
+			w.Write([]byte(content))
+		case "bytes=1-2":
+			w.Header().Set("Content-Range", "bytes 1-2/4")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(content[1:3]))
+		default:
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		}
+	}))
+	defer s3.Close()
+	store, err := models.NewS3Store(models.S3Config{Endpoint: s3.URL, Bucket: "photos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gallery := &models.Gallery{Visibility: models.VisibilityPublic}
+	gallery.ID = 7
+	image := &models.Image{GalleryID: gallery.ID, Filename: "photo.jpg"}
+	imagesC := NewImages(fakeGalleries{gallery: gallery}, fakeImages{image: image}, nil, fakeMemberships{}, store)
+
+	tests := []struct {
+		rng          string
+		status       int
+		contentRange string
+		body         string
+	}{
+		{"", http.StatusOK, "", content},
+		{"bytes=1-2", http.StatusPartialContent, "bytes 1-2/4", "pe"},
+		{"bytes=10-", http.StatusRequestedRangeNotSatisfiable, "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, image.Path(), nil)
+		if tt.rng != "" {
+			r.Header.Set("Range", tt.rng)
+		}
+		w := httptest.NewRecorder()
+		imagesC.Serve(w, r)
+		if w.Code != tt.status {
+			t.Errorf("Range %q: status = %d, want %d", tt.rng, w.Code, tt.status)
+			continue
+		}
+		if got := w.Header().Get("Content-Range"); got != tt.contentRange {
+			t.Errorf("Range %q: Content-Range = %q, want %q", tt.rng, got, tt.contentRange)
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("Range %q: body = %q, want %q", tt.rng, w.Body.String(), tt.body)
+		}
+	}
+}
+
+type fakeShareLinks struct {
+	models.ShareLinkService
+	link *models.ShareLink
+}
+
+func (fs fakeShareLinks) ByToken(token string) (*models.ShareLink, error) {
+	if token != fs.link.Token {
+		return nil, models.ErrNotFound
+	}
+	return fs.link, nil
+}
+
+// The cookie of a share link loads the images of the page served
+// through it, but doesn't open any other page of the gallery, which
+// would get around counting the views of the link.
+func TestShareLinkCookie(t *testing.T) {
+	gallery := &models.Gallery{UserID: 1, Visibility: models.VisibilityPrivate}
+	gallery.ID = 7
+	image := &models.Image{GalleryID: gallery.ID, Filename: "photo.jpg"}
+	link := &models.ShareLink{GalleryID: gallery.ID, Token: "token"}
+
+	store := models.NewDiskStore(t.TempDir())
+	if err := store.Put(image.Key(), strings.NewReader("jpeg")); err != nil {
+		t.Fatal(err)
+	}
+	imagesC := NewImages(fakeGalleries{gallery: gallery}, fakeImages{image: image}, fakeShareLinks{link: link}, fakeMemberships{}, store)
+	cookie := &http.Cookie{Name: shareLinkCookieName(gallery.ID), Value: link.Token}
+
+	r := httptest.NewRequest(http.MethodGet, image.Path(), nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	imagesC.Serve(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("image status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	a := access{sls: fakeShareLinks{link: link}, ms: fakeMemberships{}}
+	for _, via := range []via{viaID, viaLink} {
+		r := httptest.NewRequest(http.MethodGet, gallery.Path(), nil)
+		r.AddCookie(cookie)
+		v, err := a.visitor(r, gallery, via)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Shared {
+			t.Errorf("visitor via %d is shared by the cookie", via)
+		}
+	}
+}
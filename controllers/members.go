@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"photo-gallery/context"
+	"photo-gallery/models"
+	"photo-gallery/policy"
+	"photo-gallery/views"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// NewMembers is used to create the controller of the pages where
+// owners invite others into their galleries, and where invited
+// users accept or decline.
+// This function will panic if the templates are not parsed
+// correctly, and should only be used during initial setup.
+func NewMembers(gs models.GalleryService, sls models.ShareLinkService, ms models.MembershipService) *Members {
+	return &Members{
+		IndexView:       views.NewView("bootstrap", "members/index"),
+		InvitationsView: views.NewView("bootstrap", "members/invitations"),
+		access:          access{gs, sls, ms},
+	}
+}
+
+type Members struct {
+	IndexView       *views.View
+	InvitationsView *views.View
+	access
+}
+
+type InviteForm struct {
+	Email string      `schema:"email"`
+	Role  models.Role `schema:"role"`
+}
+
+// MembersPage is what the members page shows.
+type MembersPage struct {
+	Gallery *models.Gallery
+	Members []models.Membership
+	Roles   []models.Role
+	Form    InviteForm
+}
+
+// Index lists the members of the gallery and the invitations
+// which were not accepted yet.
+//
+// GET /galleries/:id/members
+func (m *Members) Index(w http.ResponseWriter, r *http.Request) {
+	gallery, err := m.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	m.render(w, r, gallery, views.Data{}, InviteForm{Role: models.RoleViewer})
+}
+
+// Invite asks the user with the email address to become a member
+// of the gallery. They have to have signed up, and become a member
+// once they accept.
+//
+// POST /galleries/:id/members
+func (m *Members) Invite(w http.ResponseWriter, r *http.Request) {
+	gallery, err := m.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	var vd views.Data
+	var form InviteForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		m.render(w, r, gallery, vd, form)
+		return
+	}
+	membership := models.Membership{
+		GalleryID: gallery.ID,
+		Email:     form.Email,
+		Role:      form.Role,
+	}
+	if err := m.ms.Invite(&membership); err != nil {
+		vd.SetAlert(err)
+		m.render(w, r, gallery, vd, form)
+		return
+	}
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: fmt.Sprintf("Invited %s as %s", form.Email, form.Role),
+	}
+	views.RedirectAlert(w, r, fmt.Sprintf("/galleries/%v/members", gallery.ID), http.StatusFound, alert)
+}
+
+// Remove takes a member out of the gallery, or
+// withdraws an invitation which was not accepted.
+//
+// POST /galleries/:id/members/:member/remove
+func (m *Members) Remove(w http.ResponseWriter, r *http.Request) {
+	gallery, err := m.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["member"])
+	if err != nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	membership, err := m.ms.ByID(uint(id))
+	if err == nil && membership.GalleryID != gallery.ID {
+		err = models.ErrNotFound
+	}
+	if err == models.ErrNotFound {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		err = m.ms.Delete(membership.ID)
+	}
+	if err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		m.render(w, r, gallery, vd, InviteForm{Role: models.RoleViewer})
+		return
+	}
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Member removed",
+	}
+	views.RedirectAlert(w, r, fmt.Sprintf("/galleries/%v/members", gallery.ID), http.StatusFound, alert)
+}
+
+// Invitations lists the invitations of the user.
+//
+// GET /invitations
+func (m *Members) Invitations(w http.ResponseWriter, r *http.Request) {
+	invitations, err := m.ms.Invitations(context.User(r.Context()).ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Yield = invitations
+	m.InvitationsView.Render(w, r, vd)
+}
+
+// POST /invitations/:id/accept
+func (m *Members) Accept(w http.ResponseWriter, r *http.Request) {
+	invitation, err := m.invitationByID(w, r)
+	if err != nil {
+		return
+	}
+	if err := m.ms.Accept(invitation.ID); err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Invitation accepted, the gallery is listed among yours",
+	}
+	views.RedirectAlert(w, r, "/galleries", http.StatusFound, alert)
+}
+
+// POST /invitations/:id/decline
+func (m *Members) Decline(w http.ResponseWriter, r *http.Request) {
+	invitation, err := m.invitationByID(w, r)
+	if err != nil {
+		return
+	}
+	if err := m.ms.Delete(invitation.ID); err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Invitation declined",
+	}
+	views.RedirectAlert(w, r, "/invitations", http.StatusFound, alert)
+}
+
+func (m *Members) render(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, vd views.Data, form InviteForm) {
+	members, err := m.ms.Members(gallery.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	vd.Yield = MembersPage{
+		Gallery: gallery,
+		Members: members,
+		Roles:   models.MemberRoles,
+		Form:    form,
+	}
+	m.IndexView.Render(w, r, vd)
+}
+
+// galleryByID looks up a gallery whose members the user can manage.
+func (m *Members) galleryByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return nil, err
+	}
+	gallery, err := m.gs.ByID(uint(id))
+	var v policy.Visitor
+	if err == nil {
+		v, err = m.visitor(r, gallery, viaID)
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	if !allowed(w, policy.CanEdit(gallery, v)) {
+		return nil, models.ErrNotFound
+	}
+	return gallery, nil
+}
+
+// invitationByID looks up an invitation of the user
+// which was not accepted yet.
+func (m *Members) invitationByID(w http.ResponseWriter, r *http.Request) (*models.Membership, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return nil, err
+	}
+	invitation, err := m.ms.ByID(uint(id))
+	if err == nil && (invitation.UserID != context.User(r.Context()).ID || invitation.Accepted()) {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Invitation not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return invitation, nil
+}
@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"photo-gallery/models"
+	"photo-gallery/policy"
+	"photo-gallery/views"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// shareLinkCookieDuration is how long a visitor who opened a gallery
+// through a link which never expires can load its images.
+const shareLinkCookieDuration = 30 * 24 * time.Hour
+
+// NewShareLinks is used to create the controller of the pages
+// where owners manage the share links of their galleries.
+// This function will panic if the templates are not parsed
+// correctly, and should only be used during initial setup.
+func NewShareLinks(gs models.GalleryService, sls models.ShareLinkService, ms models.MembershipService) *ShareLinks {
+	return &ShareLinks{
+		IndexView: views.NewView("bootstrap", "share_links/index"),
+		access:    access{gs, sls, ms},
+	}
+}
+
+type ShareLinks struct {
+	IndexView *views.View
+	access
+}
+
+type ShareLinkForm struct {
+	ExpiresInDays int `schema:"expires_in_days"`
+	MaxViews      int `schema:"max_views"`
+}
+
+// ShareLinksPage is what the share links page shows. NewLinkURL
+// is set right after a link was made, as its token can't be
+// shown again later.
+type ShareLinksPage struct {
+	Gallery    *models.Gallery
+	Links      []models.ShareLink
+	NewLinkURL string
+}
+
+// Index lists the share links of the gallery which were not revoked.
+//
+// GET /galleries/:id/links
+func (s *ShareLinks) Index(w http.ResponseWriter, r *http.Request) {
+	gallery, err := s.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	s.render(w, r, gallery, views.Data{}, "")
+}
+
+// POST /galleries/:id/links
+func (s *ShareLinks) Create(w http.ResponseWriter, r *http.Request) {
+	gallery, err := s.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	var vd views.Data
+	var form ShareLinkForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		s.render(w, r, gallery, vd, "")
+		return
+	}
+	link := models.ShareLink{
+		GalleryID: gallery.ID,
+		MaxViews:  form.MaxViews,
+	}
+	if form.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, form.ExpiresInDays)
+		link.ExpiresAt = &expiresAt
+	}
+	if err := s.sls.Create(&link); err != nil {
+		vd.SetAlert(err)
+		s.render(w, r, gallery, vd, "")
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Share link created, copy it now as it won't be shown again",
+	}
+	s.render(w, r, gallery, vd, shareLinkURL(r, link.Token))
+}
+
+// POST /galleries/:id/links/:link/revoke
+func (s *ShareLinks) Revoke(w http.ResponseWriter, r *http.Request) {
+	gallery, err := s.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["link"])
+	if err != nil {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	}
+	link, err := s.sls.ByID(uint(id))
+	if err == nil && link.GalleryID != gallery.ID {
+		err = models.ErrNotFound
+	}
+	if err == models.ErrNotFound {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		err = s.sls.Revoke(link.ID)
+	}
+	if err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		s.render(w, r, gallery, vd, "")
+		return
+	}
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Share link revoked",
+	}
+	views.RedirectAlert(w, r, fmt.Sprintf("/galleries/%v/links", gallery.ID), http.StatusFound, alert)
+}
+
+func (s *ShareLinks) render(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, vd views.Data, newLinkURL string) {
+	links, err := s.sls.ByGalleryID(gallery.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	vd.Yield = ShareLinksPage{
+		Gallery:    gallery,
+		Links:      links,
+		NewLinkURL: newLinkURL,
+	}
+	s.IndexView.Render(w, r, vd)
+}
+
+// galleryByID looks up a gallery whose share links the user can manage.
+func (s *ShareLinks) galleryByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return nil, err
+	}
+	gallery, err := s.gs.ByID(uint(id))
+	var v policy.Visitor
+	if err == nil {
+		v, err = s.visitor(r, gallery, viaID)
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	if !allowed(w, policy.CanEdit(gallery, v)) {
+		return nil, models.ErrNotFound
+	}
+	return gallery, nil
+}
+
+// shareLinkURL returns the full URL of a share link,
+// which is what the owner sends around.
+func shareLinkURL(r *http.Request, token string) string {
+	u := url.URL{
+		Scheme: "http",
+		Host:   r.Host,
+		Path:   "/l/" + token,
+	}
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		u.Scheme = "https"
+	}
+	return u.String()
+}
+
+// shareLinkCookieName is the cookie which keeps the token of the share
+// link a gallery was opened through, so its images can be loaded. It is
+// only sent for images, any page of the gallery goes through the link
+// again, which counts the view.
+func shareLinkCookieName(galleryID uint) string {
+	return fmt.Sprintf("share_%v", galleryID)
+}
+
+func setShareLinkCookie(w http.ResponseWriter, link *models.ShareLink, token string) {
+	expires := time.Now().Add(shareLinkCookieDuration)
+	if link.ExpiresAt != nil {
+		expires = *link.ExpiresAt
+	}
+	cookie := http.Cookie{
+		Name:     shareLinkCookieName(link.GalleryID),
+		Value:    token,
+		Path:     models.ImagesURLPrefix,
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, &cookie)
+}
+
+// galleryShared reports whether the visitor opened the gallery through
+// one of its share links which has not expired or been revoked since.
+// It only counts for images, see viaImage.
+// A link which ran out of views keeps working only until the visit
+// which took its last view is over, see ShareLink.Visiting.
+func galleryShared(sls models.ShareLinkService, r *http.Request, gallery *models.Gallery) bool {
+	cookie, err := r.Cookie(shareLinkCookieName(gallery.ID))
+	if err != nil {
+		return false
+	}
+	link, err := sls.ByToken(cookie.Value)
+	if err != nil {
+		if err != models.ErrNotFound {
+			log.Println(err)
+		}
+		return false
+	}
+	return link.GalleryID == gallery.ID && link.Visiting()
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"photo-gallery/context"
+	"photo-gallery/models"
+	"photo-gallery/views"
+
+	"github.com/gorilla/mux"
+)
+
+// suggestedTags is how many tags the autocomplete offers at once.
+const suggestedTags = 10
+
+// NewTags is used to create the controller of the tag pages.
+// This function will panic if the templates are not parsed
+// correctly, and should only be used during initial setup.
+func NewTags(gs models.GalleryService, is models.ImageService, ts models.TagService) *Tags {
+	return &Tags{
+		ShowView: views.NewView("bootstrap", "tags/show"),
+		gs:       gs,
+		is:       is,
+		ts:       ts,
+	}
+}
+
+type Tags struct {
+	ShowView *views.View
+	gs       models.GalleryService
+	is       models.ImageService
+	ts       models.TagService
+}
+
+// TagPage is what the page of a tag shows.
+type TagPage struct {
+	Tag       *models.Tag
+	Galleries []models.Gallery
+	Images    []models.Image
+}
+
+// Show lists the public galleries and images with the tag.
+//
+// GET /tags/:tag
+func (t *Tags) Show(w http.ResponseWriter, r *http.Request) {
+	tag, err := t.ts.ByName(mux.Vars(r)["tag"])
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Tag not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		}
+		return
+	}
+	page := TagPage{Tag: tag}
+	page.Galleries, err = t.ts.Galleries(tag.ID)
+	if err == nil {
+		page.Images, err = t.ts.Images(tag.ID)
+	}
+	if err == nil {
+		owned := make([]*models.Gallery, len(page.Galleries))
+		for n := range page.Galleries {
+			owned[n] = &page.Galleries[n]
+		}
+		err = t.gs.SetOwners(owned...)
+	}
+	if err == nil {
+		err = setCovers(t.is, page.Galleries)
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Yield = page
+	t.ShowView.Render(w, r, vd)
+}
+
+// Suggest returns the names of the tags of the user which start with
+// the text in q as a JSON array, for the autocomplete of the tag
+// fields of the edit page.
+//
+// GET /tags?q=
+func (t *Tags) Suggest(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	tags, err := t.ts.Suggest(user.ID, r.URL.Query().Get("q"), suggestedTags)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	names := make([]string, len(tags))
+	for n, tag := range tags {
+		names[n] = tag.Name
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if err := json.NewEncoder(w).Encode(names); err != nil {
+		log.Println(err)
+	}
+}
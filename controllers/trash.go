@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"photo-gallery/context"
+	"photo-gallery/models"
+	"photo-gallery/policy"
+	"photo-gallery/views"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// NewTrash is used to create the controller of the trash page.
+// This function will panic if the templates are not parsed
+// correctly, and should only be used during initial setup.
+func NewTrash(gs models.GalleryService, is models.ImageService, ms models.MembershipService) *Trash {
+	return &Trash{
+		IndexView: views.NewView("bootstrap", "trash/index"),
+		gs:        gs,
+		is:        is,
+		ms:        ms,
+	}
+}
+
+type Trash struct {
+	IndexView *views.View
+	gs        models.GalleryService
+	is        models.ImageService
+	ms        models.MembershipService
+}
+
+// Index lists the deleted galleries and images of the user.
+//
+// GET /trash
+func (t *Trash) Index(w http.ResponseWriter, r *http.Request) {
+	t.render(w, r, nil)
+}
+
+// POST /trash/galleries/:id/restore
+func (t *Trash) RestoreGallery(w http.ResponseWriter, r *http.Request) {
+	gallery, err := t.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	if err := t.is.RestoreGallery(gallery); err != nil {
+		t.render(w, r, err)
+		return
+	}
+	t.redirect(w, r, "Gallery \""+gallery.Title+"\" restored")
+}
+
+// POST /trash/galleries/:id/purge
+func (t *Trash) PurgeGallery(w http.ResponseWriter, r *http.Request) {
+	gallery, err := t.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	if err := t.is.PurgeGallery(gallery.ID); err != nil {
+		t.render(w, r, err)
+		return
+	}
+	t.redirect(w, r, "Gallery \""+gallery.Title+"\" deleted forever")
+}
+
+// POST /trash/images/:id/restore
+func (t *Trash) RestoreImage(w http.ResponseWriter, r *http.Request) {
+	image, err := t.imageByID(w, r)
+	if err != nil {
+		return
+	}
+	if err := t.is.RestoreImage(image); err != nil {
+		t.render(w, r, err)
+		return
+	}
+	t.redirect(w, r, "Image \""+image.Alt()+"\" restored")
+}
+
+// POST /trash/images/:id/purge
+func (t *Trash) PurgeImage(w http.ResponseWriter, r *http.Request) {
+	image, err := t.imageByID(w, r)
+	if err != nil {
+		return
+	}
+	if err := t.is.PurgeImage(image); err != nil {
+		t.render(w, r, err)
+		return
+	}
+	t.redirect(w, r, "Image \""+image.Alt()+"\" deleted forever")
+}
+
+func (t *Trash) render(w http.ResponseWriter, r *http.Request, err error) {
+	var vd views.Data
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	user := context.User(r.Context())
+	trash, err := t.is.Trash(user.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	vd.Yield = trash
+	t.IndexView.Render(w, r, vd)
+}
+
+func (t *Trash) redirect(w http.ResponseWriter, r *http.Request, msg string) {
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: msg,
+	}
+	views.RedirectAlert(w, r, "/trash", http.StatusFound, alert)
+}
+
+// galleryByID looks up a gallery in the trash which the user may
+// restore and purge, the ones they could delete. Anything else in
+// the trash is not found, as deleted galleries can't be seen.
+func (t *Trash) galleryByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return nil, err
+	}
+	gallery, err := t.gs.TrashedByID(uint(id))
+	var v policy.Visitor
+	if err == nil {
+		v, err = t.visitor(r, gallery)
+	}
+	if err == nil && !policy.CanDelete(gallery, v).Allowed() {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return gallery, nil
+}
+
+// imageByID looks up an image in the trash which the user may restore
+// and purge, the ones they could delete from its gallery now: owners
+// any image, editors their own. Images of deleted galleries go with
+// their gallery, so they are not found on their own.
+func (t *Trash) imageByID(w http.ResponseWriter, r *http.Request) (*models.Image, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return nil, err
+	}
+	image, err := t.is.TrashedByID(uint(id))
+	var gallery *models.Gallery
+	if err == nil {
+		gallery, err = t.gs.ByID(image.GalleryID)
+	}
+	var v policy.Visitor
+	if err == nil {
+		v, err = t.visitor(r, gallery)
+	}
+	if err == nil && !policy.CanDeleteImage(gallery, image, v).Allowed() {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Image not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return image, nil
+}
+
+// visitor is the user with their role in the gallery. Share links
+// and passwords play no part in the trash.
+func (t *Trash) visitor(r *http.Request, gallery *models.Gallery) (policy.Visitor, error) {
+	user := context.User(r.Context())
+	role, err := t.ms.RoleOf(gallery, user)
+	if err != nil {
+		return policy.Visitor{}, err
+	}
+	return policy.Visitor{User: user, Role: role}, nil
+}
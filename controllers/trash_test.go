@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"photo-gallery/context"
+	"photo-gallery/models"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTrashImageByID(t *testing.T) {
+	user := func(id uint) *models.User {
+		u := &models.User{}
+		u.ID = id
+		return u
+	}
+	const owner, editor, contributor, removed = 1, 2, 3, 4
+	gallery := &models.Gallery{UserID: owner, Visibility: models.VisibilityPublic}
+	gallery.ID = 7
+	ms := fakeMemberships{roles: map[uint]models.Role{
+		editor:      models.RoleEditor,
+		contributor: models.RoleContributor,
+	}}
+
+	tests := []struct {
+		name     string
+		user     uint
+		uploader uint
+		status   int
+	}{
+		{"owner restores an image of a contributor", owner, contributor, http.StatusOK},
+		{"owner restores an image of a removed member", owner, removed, http.StatusOK},
+		{"editor restores their own image", editor, editor, http.StatusOK},
+		{"editor restores an image of someone else", editor, contributor, http.StatusNotFound},
+		{"contributor restores their own image", contributor, contributor, http.StatusNotFound},
+		{"removed member restores their own image", removed, removed, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image := &models.Image{GalleryID: gallery.ID, UserID: tt.uploader, Filename: "photo.jpg"}
+			image.ID = 11
+			trash := &Trash{gs: fakeGalleries{gallery: gallery}, is: fakeImages{image: image}, ms: ms}
+
+			r := httptest.NewRequest(http.MethodPost, "/trash/images/11/restore", nil)
+			r = mux.SetURLVars(r, map[string]string{"id": "11"})
+			r = r.WithContext(context.WithUser(r.Context(), user(tt.user)))
+			w := httptest.NewRecorder()
+			_, err := trash.imageByID(w, r)
+			if tt.status == http.StatusOK {
+				if err != nil {
+					t.Fatalf("imageByID() error = %v", err)
+				}
+				return
+			}
+			if err == nil || w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"photo-gallery/models"
+	"time"
+)
+
+// unlockCookieName is the cookie which keeps the token
+// returned by GalleryService.Unlock for the gallery.
+func unlockCookieName(galleryID uint) string {
+	return fmt.Sprintf("gallery_%v", galleryID)
+}
+
+func setUnlockCookie(w http.ResponseWriter, gallery *models.Gallery, token string) {
+	cookie := http.Cookie{
+		Name:     unlockCookieName(gallery.ID),
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(models.GalleryUnlockDuration),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, &cookie)
+}
+
+// galleryUnlocked reports whether the visitor gave the password of the
+// gallery, which the cookie set by Unlock proves. The gallery pages and
+// the image files check the same cookie.
+func galleryUnlocked(gs models.GalleryService, r *http.Request, gallery *models.Gallery) bool {
+	if !gallery.HasPassword() {
+		return true
+	}
+	cookie, err := r.Cookie(unlockCookieName(gallery.ID))
+	if err != nil {
+		return false
+	}
+	return gs.Unlocked(gallery, cookie.Value)
+}
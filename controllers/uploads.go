@@ -0,0 +1,339 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"photo-gallery/context"
+	"photo-gallery/models"
+	"photo-gallery/policy"
+	"photo-gallery/views"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	TusResumable = "Tus-Resumable"
+	tusVersion   = "1.0.0"
+	tusExtension = "creation,expiration,termination"
+
+	tusContentType = "application/offset+octet-stream"
+)
+
+func NewUploads(gs models.GalleryService, is models.ImageService, sls models.ShareLinkService, ms models.MembershipService, store *models.UploadStore, maxBytes int64) *Uploads {
+	if maxBytes <= 0 {
+		maxBytes = models.DefaultMaxBytes
+	}
+	return &Uploads{
+		access:   access{gs, sls, ms},
+		is:       is,
+		store:    store,
+		maxBytes: maxBytes,
+	}
+}
+
+// Uploads is a tus 1.0 server (https://tus.io/protocols/resumable-upload)
+// for uploading images into a gallery in pieces, so an upload which was
+// cut off can be resumed where it stopped. It implements the creation,
+// expiration and termination extensions. Every upload is a single image,
+// the name of the file is taken from the "filename" metadata.
+type Uploads struct {
+	access
+	is       models.ImageService
+	store    *models.UploadStore
+	maxBytes int64
+}
+
+// Options tells tus clients what the server supports.
+//
+// OPTIONS /galleries/:id/uploads
+func (u *Uploads) Options(w http.ResponseWriter, r *http.Request) {
+	u.setHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtension)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(u.maxBytes, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Create starts an upload of Upload-Length bytes.
+//
+// POST /galleries/:id/uploads
+func (u *Uploads) Create(w http.ResponseWriter, r *http.Request) {
+	if !u.checkVersion(w, r) {
+		return
+	}
+	gallery, ok := u.galleryByID(w, r)
+	if !ok {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Upload-Length is required", http.StatusBadRequest)
+		return
+	}
+	if length > u.maxBytes {
+		http.Error(w, models.ErrImageTooLarge.Public(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	user := context.User(r.Context())
+	usage, err := u.is.Usage(user)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	metadata := r.Header.Get("Upload-Metadata")
+	upload := models.Upload{
+		GalleryID: gallery.ID,
+		UserID:    user.ID,
+		Length:    length,
+		Filename:  tusMetadata(metadata, "filename"),
+		Metadata:  metadata,
+	}
+	if upload.Filename == "" {
+		upload.Filename = tusMetadata(metadata, "name")
+	}
+	switch err := u.store.Create(&upload, usage.Remaining()); err {
+	case nil:
+	case models.ErrQuotaExceeded:
+		http.Error(w, models.ErrQuotaExceeded.Public(), http.StatusRequestEntityTooLarge)
+		return
+	case models.ErrTooManyUploads:
+		http.Error(w, models.ErrTooManyUploads.Public(), http.StatusTooManyRequests)
+		return
+	default:
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/galleries/%v/uploads/%v", gallery.ID, upload.ID))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Head tells the client the offset to resume the upload from.
+//
+// HEAD /galleries/:id/uploads/:upload
+func (u *Uploads) Head(w http.ResponseWriter, r *http.Request) {
+	if !u.checkVersion(w, r) {
+		return
+	}
+	upload, ok := u.uploadByID(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if upload.Metadata != "" {
+		w.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Patch appends the body of the request to the upload at Upload-Offset.
+// Once all bytes have arrived, the upload is turned into an image of
+// the gallery. A finished upload which failed for a reason other than
+// the image being rejected can be retried with an empty PATCH. The
+// user may have lost their role in the gallery since they started the
+// upload, so whether they can upload into it is checked every time.
+//
+// PATCH /galleries/:id/uploads/:upload
+func (u *Uploads) Patch(w http.ResponseWriter, r *http.Request) {
+	if !u.checkVersion(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != tusContentType {
+		http.Error(w, "Content-Type must be "+tusContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "Upload-Offset is required", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := u.galleryByID(w, r); !ok {
+		return
+	}
+	upload, unlock, ok := u.lockUpload(w, r)
+	if !ok {
+		return
+	}
+	defer unlock()
+	if offset != upload.Offset {
+		http.Error(w, "Upload-Offset does not match", http.StatusConflict)
+		return
+	}
+	if err := u.store.Append(upload, r.Body); err != nil {
+		// Whatever was written is kept, the client asks
+		// for the offset and carries on from there.
+		log.Println(err)
+		http.Error(w, "Upload was interrupted", http.StatusInternalServerError)
+		return
+	}
+
+	if upload.Done() {
+		if !u.finish(w, upload) {
+			return
+		}
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Delete terminates the upload.
+//
+// DELETE /galleries/:id/uploads/:upload
+func (u *Uploads) Delete(w http.ResponseWriter, r *http.Request) {
+	if !u.checkVersion(w, r) {
+		return
+	}
+	upload, unlock, ok := u.lockUpload(w, r)
+	if !ok {
+		return
+	}
+	defer unlock()
+	if err := u.store.Delete(upload.ID); err != nil && err != models.ErrNotFound {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finish hands the complete upload to the ImageService. The upload is
+// deleted unless it may succeed when retried.
+func (u *Uploads) finish(w http.ResponseWriter, upload *models.Upload) bool {
+	data, err := u.store.Open(upload)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return false
+	}
+	image := models.Image{
+		GalleryID:        upload.GalleryID,
+		UserID:           upload.UserID,
+		OriginalFilename: upload.Filename,
+	}
+	if image.OriginalFilename == "" {
+		image.OriginalFilename = "upload"
+	}
+	err = u.is.Create(&image, data)
+	if pErr, ok := err.(views.PublicError); ok {
+		u.store.Delete(upload.ID)
+		http.Error(w, pErr.Public(), http.StatusUnprocessableEntity)
+		return false
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return false
+	}
+	if err := u.store.Delete(upload.ID); err != nil {
+		log.Println(err)
+	}
+	return true
+}
+
+// checkVersion rejects requests of clients which speak another
+// version of the protocol, every response to the others tells
+// the version of the server.
+func (u *Uploads) checkVersion(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get(TusResumable) == tusVersion {
+		u.setHeaders(w)
+		return true
+	}
+	w.Header().Set("Tus-Version", tusVersion)
+	w.WriteHeader(http.StatusPreconditionFailed)
+	return false
+}
+
+func (u *Uploads) setHeaders(w http.ResponseWriter) {
+	w.Header().Set(TusResumable, tusVersion)
+}
+
+// galleryByID looks up a gallery the user can upload into.
+func (u *Uploads) galleryByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return nil, false
+	}
+	gallery, err := u.gs.ByID(uint(id))
+	var v policy.Visitor
+	if err == nil {
+		v, err = u.visitor(r, gallery, viaID)
+	}
+	switch err {
+	case nil:
+		return gallery, allowed(w, policy.CanUpload(gallery, v))
+	case models.ErrNotFound:
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+	default:
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+	}
+	return nil, false
+}
+
+// uploadByID looks up an upload of the user into the gallery of the URL.
+func (u *Uploads) uploadByID(w http.ResponseWriter, r *http.Request) (*models.Upload, bool) {
+	vars := mux.Vars(r)
+	upload, err := u.store.Get(vars["upload"])
+	if err == nil && (upload.UserID != context.User(r.Context()).ID ||
+		strconv.FormatUint(uint64(upload.GalleryID), 10) != vars["id"]) {
+		err = models.ErrNotFound
+	}
+	switch err {
+	case nil:
+		return upload, true
+	case models.ErrNotFound:
+		http.Error(w, "Upload not found", http.StatusNotFound)
+	default:
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+	}
+	return nil, false
+}
+
+// lockUpload looks up the upload like uploadByID and locks it, so it
+// does not change until the returned func is called. It is looked up
+// again once locked, as another request may have written to it.
+func (u *Uploads) lockUpload(w http.ResponseWriter, r *http.Request) (*models.Upload, func(), bool) {
+	if _, ok := u.uploadByID(w, r); !ok {
+		return nil, nil, false
+	}
+	unlock := u.store.Lock(mux.Vars(r)["upload"])
+	upload, ok := u.uploadByID(w, r)
+	if !ok {
+		unlock()
+		return nil, nil, false
+	}
+	return upload, unlock, true
+}
+
+// tusMetadata returns the value of a key of the Upload-Metadata
+// header, which is a list of keys and base64 encoded values.
+func tusMetadata(header, key string) string {
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) != 2 || fields[0] != key {
+			continue
+		}
+		v, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return ""
+		}
+		return string(v)
+	}
+	return ""
+}
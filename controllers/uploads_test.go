@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"photo-gallery/context"
+	"photo-gallery/models"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// A member who was demoted to viewer, or removed, after starting
+// an upload can't go on with it.
+func TestPatchChecksRole(t *testing.T) {
+	gallery := &models.Gallery{UserID: 1, Visibility: models.VisibilityPublic}
+	gallery.ID = 7
+	member := &models.User{Username: "member"}
+	member.ID = 2
+
+	tests := []struct {
+		role   models.Role
+		status int
+	}{
+		{models.RoleContributor, http.StatusNoContent},
+		{models.RoleViewer, http.StatusForbidden},
+		{models.RoleNone, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.role), func(t *testing.T) {
+			store, err := models.NewUploadStore(models.UploadConfig{Path: t.TempDir()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			upload := models.Upload{GalleryID: gallery.ID, UserID: member.ID, Length: 10}
+			if err := store.Create(&upload, 100); err != nil {
+				t.Fatal(err)
+			}
+			u := &Uploads{
+				access: access{
+					gs: fakeGalleries{gallery: gallery},
+					ms: fakeMemberships{roles: map[uint]models.Role{member.ID: tt.role}},
+				},
+				store: store,
+			}
+
+			r := httptest.NewRequest(http.MethodPatch, "/galleries/7/uploads/"+upload.ID, strings.NewReader("piece"))
+			r.Header.Set(TusResumable, tusVersion)
+			r.Header.Set("Content-Type", tusContentType)
+			r.Header.Set("Upload-Offset", "0")
+			r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(int(gallery.ID)), "upload": upload.ID})
+			r = r.WithContext(context.WithUser(r.Context(), member))
+			w := httptest.NewRecorder()
+			u.Patch(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"photo-gallery/middleware"
 	"photo-gallery/models"
 	"photo-gallery/rand"
+	"photo-gallery/views"
+	"time"
 
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
@@ -21,31 +23,67 @@ func notFoundHandler(w http.ResponseWriter, r *http.Request) {
 
 func main() {
 	boolPtr := flag.Bool("prod", false, "Provide this flag in production. This flag ensures that a .config file is provided before the application start.")
+	gcPtr := flag.Bool("gc", false, "Delete the images and files of galleries which do not exist anymore, then exit.")
+	dryRunPtr := flag.Bool("dry-run", false, "With -gc, only report what would be deleted.")
 	flag.Parse()
 
 	cfg := LoadConfig(*boolPtr)
 	dbCfg := cfg.Database
+	store, err := cfg.Storage.BlobStore()
+	must(err)
 	services, err := models.NewServices(
 		models.WithGorm(dbCfg.Dialect(), dbCfg.ConnectionString()),
 		models.WithLogMode(!cfg.IsProd()),
 		models.WithUser(cfg.Pepper, cfg.HMACkey),
-		models.WithGallery(),
-		models.WithImage(),
+		models.WithGallery(cfg.Pepper, cfg.HMACkey),
+		models.WithShareLink(cfg.HMACkey),
+		models.WithMembership(),
+		models.WithTag(),
+		models.WithJobs(),
+		models.WithImage(store, cfg.Images),
 	)
 	must(err)
 	// services.DestructiveReset()
 	defer services.CloseConnection()
 	services.AutoMigrate()
+	if *gcPtr {
+		report, err := services.Image.CollectGarbage(*dryRunPtr)
+		printGarbageReport(report)
+		must(err)
+		return
+	}
+	uploads, err := models.NewUploadStore(cfg.Uploads)
+	must(err)
+	uploads.ExpireEvery(time.Hour)
+	services.Jobs.Start(cfg.Workers)
+	defer services.Jobs.Stop()
 
-	r := mux.NewRouter()
+	root := mux.NewRouter()
+	// tus clients can't send a CSRF token, so the resumable upload
+	// routes get their own router, see middleware.SkipCSRFForTus.
+	tus := root.PathPrefix("/galleries/{id:[0-9]+}/uploads").Subrouter()
+	r := root.NewRoute().Subrouter()
 
 	staticC := controllers.NewStatic()
+	assetsC := controllers.NewAssets()
 	usersC := controllers.NewUsers(services.User)
-	galleriesC := controllers.NewGalleries(services.Gallery, services.Image, r)
+	galleriesC := controllers.NewGalleries(services.Gallery, services.Image, services.ShareLink, services.Membership, services.Tag, r, cfg.Images.MaxRequestBytes, cfg.Images.MaxArchiveBytes)
+	imagesC := controllers.NewImages(services.Gallery, services.Image, services.ShareLink, services.Membership, store)
+	adminC := controllers.NewAdmin(services.User, services.Image)
+	trashC := controllers.NewTrash(services.Gallery, services.Image, services.Membership)
+	exploreC := controllers.NewExplore(services.Gallery, services.Image)
+	tagsC := controllers.NewTags(services.Gallery, services.Image, services.Tag)
+	shareLinksC := controllers.NewShareLinks(services.Gallery, services.ShareLink, services.Membership)
+	membersC := controllers.NewMembers(services.Gallery, services.ShareLink, services.Membership)
+	uploadsC := controllers.NewUploads(services.Gallery, services.Image, services.ShareLink, services.Membership, uploads, cfg.Images.MaxBytes)
 
 	b, err := rand.GenBytes(32)
 	must(err)
 	csrfMw := csrf.Protect(b, csrf.Secure(cfg.IsProd()))
+	r.Use(csrfMw)
+	tus.Use(func(next http.Handler) http.Handler {
+		return middleware.SkipCSRFForTus(csrfMw(next))
+	})
 	userMw := middleware.User{
 		UserService: services.User,
 	}
@@ -53,6 +91,9 @@ func main() {
 	requireUserMw := middleware.RequireUser{
 		User: userMw,
 	}
+	requireAdminMw := middleware.RequireAdmin{
+		User: userMw,
+	}
 
 	// Main routes
 	r.Handle("/", staticC.Home).Methods("GET")
@@ -71,22 +112,85 @@ func main() {
 	r.HandleFunc("/galleries/{id:[0-9]+}/update", requireUserMw.ApplyFn(galleriesC.Update)).Methods("POST")
 	r.HandleFunc("/galleries/{id:[0-9]+}/delete", requireUserMw.ApplyFn(galleriesC.Delete)).Methods("POST")
 	r.HandleFunc("/galleries/{id:[0-9]+}", galleriesC.Show).Methods("GET").Name(controllers.ShowGallery)
+	r.HandleFunc("/galleries/{id:[0-9]+}/download", galleriesC.Download).Methods("GET")
+	r.HandleFunc("/s/{slug:[0-9a-f]+}", galleriesC.ShowShared).Methods("GET")
+	r.HandleFunc("/s/{slug:[0-9a-f]+}/download", galleriesC.DownloadShared).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/unlock", galleriesC.Unlock).Methods("POST")
+	r.HandleFunc("/s/{slug:[0-9a-f]+}/unlock", galleriesC.UnlockShared).Methods("POST")
+	r.HandleFunc("/l/{token}", galleriesC.ShowLink).Methods("GET")
+	r.HandleFunc("/l/{token}/download", galleriesC.DownloadLink).Methods("GET")
+	r.HandleFunc("/u/{username}/{slug}", galleriesC.ShowByPath).Methods("GET")
+	r.HandleFunc("/u/{username}/{slug}/download", galleriesC.DownloadByPath).Methods("GET")
+	r.HandleFunc("/u/{username}/{slug}/unlock", galleriesC.UnlockByPath).Methods("POST")
 	r.HandleFunc("/galleries/{id:[0-9]+}/edit", galleriesC.Show).Methods("GET").Name(controllers.EditGallery)
 
 	r.HandleFunc("/galleries/{id:[0-9]+}/images", requireUserMw.ApplyFn(galleriesC.ImageUpload)).Methods("POST")
 	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/delete", requireUserMw.ApplyFn(galleriesC.ImageDelete)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/order", requireUserMw.ApplyFn(galleriesC.ImageOrder)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}", requireUserMw.ApplyFn(galleriesC.ImageUpdate)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/cover", requireUserMw.ApplyFn(galleriesC.ImageCover)).Methods("POST")
+
+	// Resumable upload routes
+	tus.HandleFunc("", uploadsC.Options).Methods("OPTIONS")
+	tus.HandleFunc("", requireUserMw.ApplyFn(uploadsC.Create)).Methods("POST")
+	tus.HandleFunc("/{upload}", requireUserMw.ApplyFn(uploadsC.Head)).Methods("HEAD")
+	tus.HandleFunc("/{upload}", requireUserMw.ApplyFn(uploadsC.Patch)).Methods("PATCH")
+	tus.HandleFunc("/{upload}", requireUserMw.ApplyFn(uploadsC.Delete)).Methods("DELETE")
+
+	// Share link routes
+	r.HandleFunc("/galleries/{id:[0-9]+}/links", requireUserMw.ApplyFn(shareLinksC.Index)).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/links", requireUserMw.ApplyFn(shareLinksC.Create)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/links/{link:[0-9]+}/revoke", requireUserMw.ApplyFn(shareLinksC.Revoke)).Methods("POST")
+
+	// Member routes
+	r.HandleFunc("/galleries/{id:[0-9]+}/members", requireUserMw.ApplyFn(membersC.Index)).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/members", requireUserMw.ApplyFn(membersC.Invite)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/members/{member:[0-9]+}/remove", requireUserMw.ApplyFn(membersC.Remove)).Methods("POST")
+	r.HandleFunc("/invitations", requireUserMw.ApplyFn(membersC.Invitations)).Methods("GET")
+	r.HandleFunc("/invitations/{id:[0-9]+}/accept", requireUserMw.ApplyFn(membersC.Accept)).Methods("POST")
+	r.HandleFunc("/invitations/{id:[0-9]+}/decline", requireUserMw.ApplyFn(membersC.Decline)).Methods("POST")
+
+	// Admin routes
+	r.HandleFunc("/admin/users", requireAdminMw.ApplyFn(adminC.Users)).Methods("GET")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/quota", requireAdminMw.ApplyFn(adminC.UpdateQuota)).Methods("POST")
+
+	// Explore routes
+	r.HandleFunc("/explore", exploreC.Index).Methods("GET")
+
+	// Tag routes
+	r.HandleFunc("/tags", requireUserMw.ApplyFn(tagsC.Suggest)).Methods("GET")
+	r.HandleFunc("/tags/{tag}", tagsC.Show).Methods("GET")
+
+	// Trash routes
+	r.HandleFunc("/trash", requireUserMw.ApplyFn(trashC.Index)).Methods("GET")
+	r.HandleFunc("/trash/galleries/{id:[0-9]+}/restore", requireUserMw.ApplyFn(trashC.RestoreGallery)).Methods("POST")
+	r.HandleFunc("/trash/galleries/{id:[0-9]+}/purge", requireUserMw.ApplyFn(trashC.PurgeGallery)).Methods("POST")
+	r.HandleFunc("/trash/images/{id:[0-9]+}/restore", requireUserMw.ApplyFn(trashC.RestoreImage)).Methods("POST")
+	r.HandleFunc("/trash/images/{id:[0-9]+}/purge", requireUserMw.ApplyFn(trashC.PurgeImage)).Methods("POST")
 
 	// Image routes
-	imageHandler := http.FileServer(http.Dir("./images/"))
-	r.PathPrefix("/images/").Handler(http.StripPrefix("/images/", imageHandler))
+	r.PathPrefix(models.ImagesURLPrefix).HandlerFunc(imagesC.Serve).Methods("GET")
 
 	// Assets
-	assetHandler := http.FileServer(http.Dir("./assets/"))
-	assetHandler = http.StripPrefix("/assets/", assetHandler)
-	r.PathPrefix("/assets/").Handler(assetHandler)
+	r.PathPrefix(views.AssetsURLPrefix).HandlerFunc(assetsC.Serve).Methods("GET")
 
 	fmt.Printf("Starting the server on :%d...\n", cfg.Port)
-	http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), csrfMw(userMw.Apply(r)))
+	http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), middleware.CSRFFromMultipart(userMw.Apply(root)))
+}
+
+func printGarbageReport(report *models.GarbageReport) {
+	if report == nil {
+		return
+	}
+	verb := "Deleted"
+	if report.DryRun {
+		verb = "Would delete"
+	}
+	for _, g := range report.Galleries {
+		fmt.Printf("gallery %v: %v images, %v files\n", g.GalleryID, g.Images, g.Blobs)
+	}
+	fmt.Printf("%v %v images and %v files of %v deleted galleries\n",
+		verb, report.Images(), report.Blobs(), len(report.Galleries))
 }
 
 func must(err error) {
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+)
+
+const (
+	csrfFieldName  = "gorilla.csrf.Token"
+	csrfHeaderName = "X-CSRF-Token"
+
+	// maxMultipartPeek limits how much of a body is read
+	// while looking for the CSRF token.
+	maxMultipartPeek = 64 << 10 // 64 Kilobytes
+)
+
+// CSRFFromMultipart moves the CSRF token of a multipart form into the
+// X-CSRF-Token header. The CSRF middleware otherwise looks for it by
+// parsing the whole form, which keeps every file of an upload in memory
+// or in temporary files before the handler gets to stream them.
+//
+// Only the first part of the body is read, so the token has to be the
+// first field of the form. What was read is put back in front of the
+// rest of the body, the handler sees the request as it was sent. The
+// token is still checked by the CSRF middleware, so it has to run
+// before it.
+func CSRFFromMultipart(next http.Handler) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.Header.Get(csrfHeaderName) == "" {
+			if token := peekMultipartToken(r); token != "" {
+				r.Header.Set(csrfHeaderName, token)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// peekMultipartToken returns the value of the first part of a multipart
+// body if that is the CSRF field, and restores the body either way.
+func peekMultipartToken(r *http.Request) string {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return ""
+	}
+	var peeked bytes.Buffer
+	body := r.Body
+	defer func() {
+		r.Body = readCloser{io.MultiReader(&peeked, body), body}
+	}()
+
+	mr := multipart.NewReader(io.TeeReader(io.LimitReader(body, maxMultipartPeek), &peeked), params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil || part.FormName() != csrfFieldName || part.FileName() != "" {
+		return ""
+	}
+	token, err := io.ReadAll(part)
+	if err != nil {
+		return ""
+	}
+	return string(token)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -17,9 +17,9 @@ func (mw *User) Apply(next http.Handler) http.HandlerFunc {
 
 func (mw *User) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		path := r.URL.Path
-		if strings.HasPrefix(path, "/assets/") ||
-			strings.HasPrefix(path, "/images/") {
+		// Images are not skipped, the ones of private galleries
+		// are only served to their owners and members.
+		if strings.HasPrefix(r.URL.Path, "/assets/") {
 			next(w, r)
 			return
 		}
@@ -69,3 +69,25 @@ func (mw *RequireUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 
 	})
 }
+
+// RequireAdmin assumes that User middleware has already been run
+// otherwise it will not work correctly. Requests of anybody but
+// admins get a 404, so admin pages are not advertised.
+type RequireAdmin struct {
+	User
+}
+
+func (mw *RequireAdmin) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *RequireAdmin) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if user == nil || !user.Admin {
+			http.NotFound(w, r)
+			return
+		}
+		next(w, r)
+	})
+}
@@ -0,0 +1,202 @@
+package models
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// BlobStore keeps the bytes of images under slash separated
+// keys, such as "galleries/17/photo.jpg".
+//
+// Get, Delete and Move return ErrNotFound when there is nothing
+// stored under the key. List returns the keys starting with
+// the prefix, which is empty or a key ending with a slash.
+type BlobStore interface {
+	Put(key string, r io.Reader) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+	// Move stores the blob under the key to and deletes it
+	// from the key from, replacing whatever was under to.
+	Move(from, to string) error
+	List(prefix string) ([]string, error)
+}
+
+// RangeGetter is implemented by BlobStores which can't seek in the
+// blobs Get returns, but can fetch a part of a blob instead.
+type RangeGetter interface {
+	// GetRange returns the part of the blob which the value of an HTTP
+	// Range header asks for, or ErrRangeNotSatisfiable when it is
+	// outside of the blob.
+	GetRange(key, rng string) (*BlobRange, error)
+}
+
+// BlobRange is a part of a blob. ContentRange is its Content-Range
+// header, which is empty when the whole blob was returned, as stores
+// do for ranges they don't support. Length is -1 when it is unknown.
+type BlobRange struct {
+	io.ReadCloser
+	ContentRange string
+	Length       int64
+}
+
+// validKey reports whether the key is a clean relative path,
+// so it can't point outside of the store.
+func validKey(key string) bool {
+	return key != "" &&
+		!strings.HasPrefix(key, "/") &&
+		!strings.Contains(key, "\\") &&
+		path.Clean(key) == key &&
+		key != ".." && !strings.HasPrefix(key, "../")
+}
+
+// validPrefix reports whether the prefix is empty or
+// a valid key followed by a slash.
+func validPrefix(prefix string) bool {
+	return prefix == "" ||
+		strings.HasSuffix(prefix, "/") && validKey(strings.TrimSuffix(prefix, "/"))
+}
+
+var _ BlobStore = &DiskStore{}
+
+// DiskStore is a BlobStore which keeps blobs as files under its root directory.
+type DiskStore struct {
+	root string
+}
+
+func NewDiskStore(root string) *DiskStore {
+	return &DiskStore{root: root}
+}
+
+func (ds *DiskStore) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(ds.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes the blob to a temporary file first, so a failed
+// upload never leaves a truncated blob behind.
+func (ds *DiskStore) Put(key string, r io.Reader) error {
+	p, err := ds.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(p)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if os.IsNotExist(err) {
+		// The directory was removed by a Delete of its last blob
+		// in the meantime.
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		tmp, err = os.CreateTemp(dir, ".upload-*")
+	}
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (ds *DiskStore) Get(key string) (io.ReadCloser, error) {
+	p, err := ds.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (ds *DiskStore) Delete(key string) error {
+	p, err := ds.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	ds.removeEmptyDirs(filepath.Dir(p))
+	return nil
+}
+
+func (ds *DiskStore) Move(from, to string) error {
+	src, err := ds.path(from)
+	if err != nil {
+		return err
+	}
+	dst, err := ds.path(to)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	ds.removeEmptyDirs(filepath.Dir(src))
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents up to the
+// root of the store, stopping at the first which is not empty.
+func (ds *DiskStore) removeEmptyDirs(dir string) {
+	root := filepath.Clean(ds.root)
+	for dir != root && strings.HasPrefix(dir, root) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func (ds *DiskStore) List(prefix string) ([]string, error) {
+	if !validPrefix(prefix) {
+		return nil, ErrInvalidKey
+	}
+	root := filepath.Clean(ds.root)
+	var keys []string
+	err := filepath.WalkDir(filepath.Join(root, filepath.FromSlash(prefix)), func(p string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+	return keys, err
+}
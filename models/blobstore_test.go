@@ -0,0 +1,132 @@
+package models
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"galleries/17/photo.jpg", true},
+		{"trash/galleries/17/photo.jpg", true},
+		{"photo.jpg", true},
+		{"", false},
+		{"/etc/passwd", false},
+		{"..", false},
+		{"../secret", false},
+		{"galleries/../../secret", false},
+		{"galleries/17/../photo.jpg", false},
+		{"galleries//photo.jpg", false},
+		{"galleries/17/", false},
+		{"./photo.jpg", false},
+		{`galleries\..\secret`, false},
+	}
+	for _, tt := range tests {
+		if got := validKey(tt.key); got != tt.want {
+			t.Errorf("validKey(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestDiskStore(t *testing.T) {
+	ds := NewDiskStore(t.TempDir())
+	const key = "galleries/17/photo.jpg"
+
+	if err := ds.Put(key, strings.NewReader("jpeg")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readBlob(t, ds, key); got != "jpeg" {
+		t.Errorf("Get(%q) = %q, want %q", key, got, "jpeg")
+	}
+	if err := ds.Put(key, strings.NewReader("jpeg v2")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readBlob(t, ds, key); got != "jpeg v2" {
+		t.Errorf("Get(%q) after overwriting = %q, want %q", key, got, "jpeg v2")
+	}
+
+	const moved = "trash/galleries/17/photo.jpg"
+	if err := ds.Move(key, moved); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Get(key); err != ErrNotFound {
+		t.Errorf("Get(%q) after Move error = %v, want ErrNotFound", key, err)
+	}
+	keys, err := ds.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{moved}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("List() = %q, want %q", keys, want)
+	}
+
+	if err := ds.Delete(moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Delete(moved); err != ErrNotFound {
+		t.Errorf("second Delete error = %v, want ErrNotFound", err)
+	}
+	if err := ds.Move(moved, key); err != ErrNotFound {
+		t.Errorf("Move of a deleted blob error = %v, want ErrNotFound", err)
+	}
+	// The directories emptied by Delete and Move are removed.
+	entries, err := os.ReadDir(ds.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("root has %d entries left, want none", len(entries))
+	}
+}
+
+func TestDiskStoreTraversal(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "store")
+	secret := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ds := NewDiskStore(root)
+
+	for _, key := range []string{"../secret", "galleries/../../secret", "/" + secret} {
+		if err := ds.Put(key, strings.NewReader("overwritten")); err != ErrInvalidKey {
+			t.Errorf("Put(%q) error = %v, want ErrInvalidKey", key, err)
+		}
+		if _, err := ds.Get(key); err != ErrInvalidKey {
+			t.Errorf("Get(%q) error = %v, want ErrInvalidKey", key, err)
+		}
+		if err := ds.Delete(key); err != ErrInvalidKey {
+			t.Errorf("Delete(%q) error = %v, want ErrInvalidKey", key, err)
+		}
+		if err := ds.Move(key, "galleries/1/stolen"); err != ErrInvalidKey {
+			t.Errorf("Move(%q) error = %v, want ErrInvalidKey", key, err)
+		}
+	}
+	if _, err := ds.List("../"); err != ErrInvalidKey {
+		t.Errorf("List(%q) error = %v, want ErrInvalidKey", "../", err)
+	}
+	if b, err := os.ReadFile(secret); err != nil || string(b) != "secret" {
+		t.Errorf("file outside of the store changed: %q, %v", b, err)
+	}
+}
+
+func readBlob(t *testing.T, bs BlobStore, key string) string {
+	t.Helper()
+	blob, err := bs.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%q) error = %v", key, err)
+	}
+	defer blob.Close()
+	b, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
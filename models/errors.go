@@ -11,10 +11,49 @@ const (
 	ErrRequirePassword      modelError   = "models: password is required."
 	ErrEmailTaken           modelError   = "models: Email address is already taken."
 	ErrTitleRequired        modelError   = "models: title is required"
+	ErrImageType            modelError   = "models: only JPEG, PNG, GIF and WebP images can be uploaded"
+	ErrImageCorrupt         modelError   = "models: image is damaged or can't be read"
+	ErrImageTooLarge        modelError   = "models: file is larger than the upload limit"
+	ErrInvalidQuota         modelError   = "models: quota must be a whole number of megabytes"
+	ErrQuotaExceeded        modelError   = "models: your storage quota is used up"
+	ErrImageDimensions      modelError   = "models: image dimensions are over the upload limit"
+	ErrImageMetadata        modelError   = "models: the location can't be removed from this image, so it can't be added to the gallery"
+	ErrArchiveCorrupt       modelError   = "models: archive is damaged or is not a ZIP file"
+	ErrArchiveTooManyFiles  modelError   = "models: archive has too many files"
+	ErrArchiveTooLarge      modelError   = "models: archive is larger than the upload limit"
+	ErrInvalidVisibility    modelError   = "models: visibility must be public, unlisted or private"
+	ErrShortGalleryPassword modelError   = "models: gallery password must be at least 8 characters long"
+	ErrInvalidMaxViews      modelError   = "models: number of views must not be negative"
+	ErrInvalidExpiry        modelError   = "models: link must expire in the future"
+	ErrInviteeNotFound      modelError   = "models: no user has signed up with that email address"
+	ErrAlreadyMember        modelError   = "models: that user is already a member of the gallery"
+	ErrInvalidRole          modelError   = "models: role must be editor, contributor or viewer"
+	ErrUploadTooLarge       modelError   = "models: upload is larger than the request limit"
+	ErrUploadInterrupted    modelError   = "models: upload was cut off before all files arrived"
+	ErrTooManyUploads       modelError   = "models: you have too many unfinished uploads, finish or cancel some first"
+	ErrImageTitleTooLong    modelError   = "models: image title must be at most 255 characters long"
+	ErrImageAltTextTooLong  modelError   = "models: alt text must be at most 500 characters long"
+	ErrImageCaptionTooLong  modelError   = "models: caption must be at most 2000 characters long"
+	ErrDescriptionTooLong   modelError   = "models: description must be at most 10000 characters long"
+	ErrSlugTaken            modelError   = "models: you have another gallery at that address"
+	ErrRequireUsername      modelError   = "models: username is required"
+	ErrInvalidUsername      modelError   = "models: username must be 2 to 30 letters, digits, dashes or underscores"
+	ErrUsernameTaken        modelError   = "models: username is already taken"
+	ErrInvalidTag           modelError   = "models: tags must be at most 50 characters long and can't have commas or slashes"
+	ErrTooManyTags          modelError   = "models: at most 20 tags can be given"
 	ErrUserIDRequired       privateError = "models: User ID is required"
+	ErrGalleryIDRequired    privateError = "models: Gallery ID is required"
+	ErrFilenameRequired     privateError = "models: image filename is required"
+	ErrInvalidFilename      privateError = "models: image filename must not contain a path"
 	ErrTokenBytesLenToShort privateError = "models: remember token must be at least 32 bytes long"
 	ErrRequireTokenHash     privateError = "models: token hash is required."
 	ErrInvalidId            privateError = "models: Provided invalid object ID."
+	ErrInvalidRendition     privateError = "models: renditions must have unique lowercase names and a positive width"
+	ErrInvalidKey           privateError = "models: blob key must be a clean relative path"
+	ErrInvalidS3Config      privateError = "models: s3 storage requires an endpoint and a bucket"
+	ErrInvalidCursor        privateError = "models: cursor is not one of a page of galleries"
+	ErrInvalidSort          privateError = "models: galleries can be sorted by recent or views"
+	ErrRangeNotSatisfiable  privateError = "models: range is outside of the blob"
 )
 
 type modelError string
@@ -35,3 +74,39 @@ type privateError string
 func (e privateError) Error() string {
 	return string(e)
 }
+
+// FileError is the reason why a single file of an upload failed.
+type FileError struct {
+	Filename string
+	Err      error
+}
+
+func (e FileError) Error() string {
+	return e.Filename + ": " + e.Err.Error()
+}
+
+func (e FileError) Public() string {
+	if pErr, ok := e.Err.(modelError); ok {
+		return e.Filename + ": " + pErr.Public()
+	}
+	return e.Filename + ": something went wrong"
+}
+
+// UploadError lists every file of an upload which was rejected.
+type UploadError []FileError
+
+func (e UploadError) Error() string {
+	msgs := make([]string, len(e))
+	for i, fErr := range e {
+		msgs[i] = fErr.Error()
+	}
+	return "models: rejected files: " + strings.Join(msgs, "; ")
+}
+
+func (e UploadError) Public() string {
+	msgs := make([]string, len(e))
+	for i, fErr := range e {
+		msgs[i] = fErr.Public()
+	}
+	return "These files were rejected: " + strings.Join(msgs, "; ")
+}
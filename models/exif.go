@@ -0,0 +1,429 @@
+package models
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Exif is the subset of the EXIF metadata of a photo
+// which is saved together with the image.
+type Exif struct {
+	CameraMake   string
+	CameraModel  string
+	LensModel    string
+	ExposureTime string
+	FNumber      float64
+	FocalLength  float64
+	ISO          int
+	TakenAt      *time.Time
+	Latitude     *float64
+	Longitude    *float64
+}
+
+func (e Exif) HasExif() bool {
+	return e.Camera() != "" || e.Settings() != "" || e.TakenAt != nil || e.HasLocation()
+}
+
+// Camera returns the camera make and model. Most vendors already
+// start the model with the make, so it is not repeated then.
+func (e Exif) Camera() string {
+	if strings.HasPrefix(strings.ToLower(e.CameraModel), strings.ToLower(e.CameraMake)) {
+		return e.CameraModel
+	}
+	return strings.TrimSpace(e.CameraMake + " " + e.CameraModel)
+}
+
+// Settings returns the exposure settings in the
+// usual "1/250s f/2.8 ISO 100 35mm" form.
+func (e Exif) Settings() string {
+	var s []string
+	if e.ExposureTime != "" {
+		s = append(s, e.ExposureTime+"s")
+	}
+	if e.FNumber > 0 {
+		s = append(s, fmt.Sprintf("f/%g", e.FNumber))
+	}
+	if e.ISO > 0 {
+		s = append(s, fmt.Sprintf("ISO %d", e.ISO))
+	}
+	if e.FocalLength > 0 {
+		s = append(s, fmt.Sprintf("%gmm", e.FocalLength))
+	}
+	return strings.Join(s, " ")
+}
+
+func (e Exif) HasLocation() bool {
+	return e.Latitude != nil && e.Longitude != nil
+}
+
+// Location returns the GPS coordinates in decimal degrees.
+func (e Exif) Location() string {
+	if !e.HasLocation() {
+		return ""
+	}
+	return fmt.Sprintf("%.5f, %.5f", *e.Latitude, *e.Longitude)
+}
+
+// EXIF tags which are read or scrubbed.
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagCameraSerial     = 0xC62F
+	tagExposureTime     = 0x829A
+	tagFNumber          = 0x829D
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagFocalLength      = 0x920A
+	tagBodySerial       = 0xA431
+	tagLensModel        = 0xA434
+	tagLensSerial       = 0xA435
+	tagGPSLatitudeRef   = 0x0001
+	tagGPSLatitude      = 0x0002
+	tagGPSLongitudeRef  = 0x0003
+	tagGPSLongitude     = 0x0004
+)
+
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// exifTypeSizes maps TIFF field types to the size of a single value.
+var exifTypeSizes = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+// tiff is the TIFF structure the EXIF of an image is stored in.
+// It is a window into the original bytes, so scrubbing it changes them.
+type tiff struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	// offset of the value within the TIFF, inline values included.
+	offset uint32
+}
+
+// size is computed in 64 bits, a crafted count
+// would overflow 32 bits and wrap around.
+func (e ifdEntry) size() uint64 {
+	return uint64(exifTypeSizes[e.typ]) * uint64(e.count)
+}
+
+// findTIFF looks for the EXIF APP1 segment in the head of a JPEG
+// and returns the TIFF structure in it. It returns false when the
+// data is not a JPEG or carries no EXIF.
+func findTIFF(b []byte) (*tiff, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1] != 0xD8 {
+		return nil, false
+	}
+	for p := 2; p+4 <= len(b); {
+		if b[p] != 0xFF {
+			return nil, false
+		}
+		marker := b[p+1]
+		// Start of scan, the compressed image data follows.
+		if marker == 0xDA {
+			return nil, false
+		}
+		length := int(binary.BigEndian.Uint16(b[p+2:]))
+		if length < 2 || p+2+length > len(b) {
+			return nil, false
+		}
+		seg := b[p+4 : p+2+length]
+		if marker == 0xE1 && len(seg) > 14 && string(seg[:6]) == exifHeader {
+			return newTIFF(seg[6:])
+		}
+		p += 2 + length
+	}
+	return nil, false
+}
+
+// newTIFF returns the TIFF structure in b, which is
+// the EXIF of a JPEG segment, a PNG or a WebP chunk.
+func newTIFF(b []byte) (*tiff, bool) {
+	if len(b) < 8 {
+		return nil, false
+	}
+	t := &tiff{b: b}
+	switch string(b[:4]) {
+	case "II*\x00":
+		t.order = binary.LittleEndian
+	case "MM\x00*":
+		t.order = binary.BigEndian
+	default:
+		return nil, false
+	}
+	return t, true
+}
+
+// ifd reads the entries of the IFD at the given offset.
+func (t *tiff) ifd(offset uint32) ([]ifdEntry, bool) {
+	if offset < 8 || uint64(offset)+2 > uint64(len(t.b)) {
+		return nil, false
+	}
+	n := uint32(t.order.Uint16(t.b[offset:]))
+	if uint64(offset)+2+uint64(n)*12 > uint64(len(t.b)) {
+		return nil, false
+	}
+	entries := make([]ifdEntry, 0, n)
+	for i := uint32(0); i < n; i++ {
+		p := offset + 2 + i*12
+		e := ifdEntry{
+			tag:   t.order.Uint16(t.b[p:]),
+			typ:   t.order.Uint16(t.b[p+2:]),
+			count: t.order.Uint32(t.b[p+4:]),
+		}
+		if e.size() <= 4 {
+			e.offset = p + 8
+		} else {
+			e.offset = t.order.Uint32(t.b[p+8:])
+		}
+		if uint64(e.offset)+e.size() > uint64(len(t.b)) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, true
+}
+
+// value returns the bytes of the entry, ifd
+// only returns entries whose values fit.
+func (t *tiff) value(e ifdEntry) []byte {
+	return t.b[e.offset : uint64(e.offset)+e.size()]
+}
+
+func (t *tiff) string(e ifdEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(t.value(e)), "\x00"))
+}
+
+// uint returns the first value of a SHORT or LONG entry,
+// or 0 when the entry has none.
+func (t *tiff) uint(e ifdEntry) uint32 {
+	if e.count < 1 {
+		return 0
+	}
+	v := t.value(e)
+	switch {
+	case e.typ == 3 && len(v) >= 2:
+		return uint32(t.order.Uint16(v))
+	case e.typ == 4 && len(v) >= 4:
+		return t.order.Uint32(v)
+	}
+	return 0
+}
+
+// rationals returns the numerators and denominators of a RATIONAL entry.
+func (t *tiff) rationals(e ifdEntry) ([][2]uint32, bool) {
+	if e.typ != 5 && e.typ != 10 {
+		return nil, false
+	}
+	if e.count < 1 {
+		return nil, false
+	}
+	v := t.value(e)
+	n := uint64(e.count)
+	if max := uint64(len(v) / 8); n > max {
+		n = max
+	}
+	r := make([][2]uint32, n)
+	for i := range r {
+		r[i] = [2]uint32{t.order.Uint32(v[i*8:]), t.order.Uint32(v[i*8+4:])}
+	}
+	return r, len(r) > 0
+}
+
+func (t *tiff) float(e ifdEntry) float64 {
+	r, ok := t.rationals(e)
+	if !ok || r[0][1] == 0 {
+		return 0
+	}
+	return math.Round(float64(r[0][0])/float64(r[0][1])*100) / 100
+}
+
+// coordinate converts degrees, minutes and seconds to decimal degrees.
+func (t *tiff) coordinate(e ifdEntry, ref string) (*float64, bool) {
+	r, ok := t.rationals(e)
+	if !ok || len(r) < 3 {
+		return nil, false
+	}
+	var deg float64
+	for i, div := range []float64{1, 60, 3600} {
+		if r[i][1] == 0 {
+			return nil, false
+		}
+		deg += float64(r[i][0]) / float64(r[i][1]) / div
+	}
+	if ref == "S" || ref == "W" {
+		deg = -deg
+	}
+	return &deg, true
+}
+
+// root returns the entries of IFD0 together with the entries of the
+// IFDs IFD0 points to, keyed by the pointer tag.
+func (t *tiff) root() ([]ifdEntry, map[uint16][]ifdEntry, bool) {
+	ifd0, ok := t.ifd(t.order.Uint32(t.b[4:]))
+	if !ok {
+		return nil, nil, false
+	}
+	subs := make(map[uint16][]ifdEntry)
+	for _, e := range ifd0 {
+		if e.tag == tagExifIFD || e.tag == tagGPSIFD {
+			if entries, ok := t.ifd(t.uint(e)); ok {
+				subs[e.tag] = entries
+			}
+		}
+	}
+	return ifd0, subs, true
+}
+
+// parseExif reads the EXIF metadata from the head of a JPEG file.
+// The head must hold the whole APP1 segment, which is never longer
+// than 64KB.
+func parseExif(head []byte) (Exif, bool) {
+	var exif Exif
+	t, ok := findTIFF(head)
+	if !ok {
+		return exif, false
+	}
+	ifd0, subs, ok := t.root()
+	if !ok {
+		return exif, false
+	}
+
+	for _, e := range ifd0 {
+		switch e.tag {
+		case tagMake:
+			exif.CameraMake = t.string(e)
+		case tagModel:
+			exif.CameraModel = t.string(e)
+		}
+	}
+	for _, e := range subs[tagExifIFD] {
+		switch e.tag {
+		case tagExposureTime:
+			if r, ok := t.rationals(e); ok && r[0][1] != 0 {
+				exif.ExposureTime = formatExposure(r[0][0], r[0][1])
+			}
+		case tagFNumber:
+			exif.FNumber = t.float(e)
+		case tagFocalLength:
+			exif.FocalLength = t.float(e)
+		case tagISO:
+			exif.ISO = int(t.uint(e))
+		case tagLensModel:
+			exif.LensModel = t.string(e)
+		case tagDateTimeOriginal:
+			// EXIF dates carry no time zone, they are in the local time of the camera.
+			if taken, err := time.Parse(exifDateLayout, t.string(e)); err == nil {
+				exif.TakenAt = &taken
+			}
+		}
+	}
+
+	var latRef, lngRef string
+	var lat, lng ifdEntry
+	for _, e := range subs[tagGPSIFD] {
+		switch e.tag {
+		case tagGPSLatitudeRef:
+			latRef = t.string(e)
+		case tagGPSLongitudeRef:
+			lngRef = t.string(e)
+		case tagGPSLatitude:
+			lat = e
+		case tagGPSLongitude:
+			lng = e
+		}
+	}
+	if la, ok := t.coordinate(lat, latRef); ok {
+		if lo, ok := t.coordinate(lng, lngRef); ok {
+			exif.Latitude, exif.Longitude = la, lo
+		}
+	}
+	return exif, true
+}
+
+// exifOrientation returns the Orientation of a JPEG file from its
+// EXIF, 1 when it is upright or has none.
+func exifOrientation(head []byte) int {
+	t, ok := findTIFF(head)
+	if !ok {
+		return 1
+	}
+	ifd0, _, ok := t.root()
+	if !ok {
+		return 1
+	}
+	for _, e := range ifd0 {
+		if o := t.uint(e); e.tag == tagOrientation && o >= 1 && o <= 8 {
+			return int(o)
+		}
+	}
+	return 1
+}
+
+func formatExposure(num, den uint32) string {
+	if num == 0 {
+		return ""
+	}
+	if num >= den {
+		return fmt.Sprintf("%g", math.Round(float64(num)/float64(den)*10)/10)
+	}
+	return fmt.Sprintf("1/%d", int(math.Round(float64(den)/float64(num))))
+}
+
+// scrub removes the GPS position and the serial numbers from the
+// EXIF. The TIFF is changed in place, so its layout and size stay
+// the same: the GPS IFD is emptied and the serial numbers are
+// overwritten with zeroes. It returns whether anything was scrubbed,
+// and false for ok when the IFDs can't be read, so the EXIF has to
+// be dropped instead.
+func (t *tiff) scrub() (scrubbed, ok bool) {
+	ifd0, subs, ok := t.root()
+	if !ok {
+		return false, false
+	}
+
+	zero := func(e ifdEntry) {
+		for i := range t.value(e) {
+			t.value(e)[i] = 0
+		}
+		scrubbed = true
+	}
+	for _, e := range append(ifd0, subs[tagExifIFD]...) {
+		switch e.tag {
+		case tagCameraSerial, tagBodySerial, tagLensSerial:
+			zero(e)
+		}
+	}
+	if gps := subs[tagGPSIFD]; len(gps) > 0 {
+		for _, e := range gps {
+			zero(e)
+		}
+		for _, e := range ifd0 {
+			if e.tag == tagGPSIFD {
+				// Empty the GPS IFD along with its entries.
+				offset := t.uint(e)
+				if _, ok := t.ifd(offset); !ok {
+					continue
+				}
+				end := offset + 2 + uint32(t.order.Uint16(t.b[offset:]))*12
+				for i := offset; i < end; i++ {
+					t.b[i] = 0
+				}
+			}
+		}
+	}
+	return scrubbed, true
+}
@@ -0,0 +1,162 @@
+package models
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// exifEntry is an IFD entry of a test file. Values of up to four
+// bytes are stored inline, longer ones after the IFD.
+type exifEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// tiffBuilder lays out a little endian TIFF structure, IFDs are
+// appended so the ones IFD0 points to have to be added first.
+type tiffBuilder struct {
+	b []byte
+}
+
+func newTIFFBuilder() *tiffBuilder {
+	return &tiffBuilder{b: []byte("II*\x00\x00\x00\x00\x00")}
+}
+
+func (tb *tiffBuilder) ifd(entries ...exifEntry) uint32 {
+	le := binary.LittleEndian
+	start := len(tb.b)
+	tb.b = append(tb.b, make([]byte, 2+12*len(entries)+4)...)
+	le.PutUint16(tb.b[start:], uint16(len(entries)))
+	for i, e := range entries {
+		p := start + 2 + 12*i
+		le.PutUint16(tb.b[p:], e.tag)
+		le.PutUint16(tb.b[p+2:], e.typ)
+		le.PutUint32(tb.b[p+4:], e.count)
+		if len(e.value) <= 4 {
+			copy(tb.b[p+8:], e.value)
+			continue
+		}
+		le.PutUint32(tb.b[p+8:], uint32(len(tb.b)))
+		tb.b = append(tb.b, e.value...)
+	}
+	return uint32(start)
+}
+
+// jpeg returns a JPEG whose IFD0 is at the offset,
+// with an empty scan in place of the image data.
+func (tb *tiffBuilder) jpeg(ifd0 uint32) []byte {
+	binary.LittleEndian.PutUint32(tb.b[4:], ifd0)
+	head := []byte{0xFF, 0xD8}
+	head = append(head, jpegSegment(0xE1, append([]byte("Exif\x00\x00"), tb.b...))...)
+	return append(head, 0xFF, 0xDA, 0, 2, 0xFF, 0xD9)
+}
+
+func jpegSegment(marker byte, data []byte) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(2+len(data)))
+	return append(seg, data...)
+}
+
+func exifASCII(tag uint16, s string) exifEntry {
+	return exifEntry{tag, 2, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func exifLong(tag uint16, v uint32) exifEntry {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return exifEntry{tag, 4, 1, b}
+}
+
+func exifRationals(tag uint16, r ...uint32) exifEntry {
+	b := make([]byte, 4*len(r))
+	for i, v := range r {
+		binary.LittleEndian.PutUint32(b[4*i:], v)
+	}
+	return exifEntry{tag, 5, uint32(len(r) / 2), b}
+}
+
+func TestParseExif(t *testing.T) {
+	tb := newTIFFBuilder()
+	exifIFD := tb.ifd(exifRationals(tagFNumber, 28, 10))
+	gpsIFD := tb.ifd(
+		exifASCII(tagGPSLatitudeRef, "S"),
+		exifRationals(tagGPSLatitude, 33, 1, 30, 1, 0, 1),
+		exifASCII(tagGPSLongitudeRef, "E"),
+		exifRationals(tagGPSLongitude, 151, 1, 15, 1, 0, 1),
+	)
+	head := tb.jpeg(tb.ifd(
+		exifASCII(tagMake, "Canon"),
+		exifLong(tagExifIFD, exifIFD),
+		exifLong(tagGPSIFD, gpsIFD),
+	))
+
+	exif, ok := parseExif(head)
+	if !ok {
+		t.Fatal("parseExif found no EXIF")
+	}
+	if exif.CameraMake != "Canon" || exif.FNumber != 2.8 {
+		t.Errorf("got make %q and f-number %v, want Canon and 2.8", exif.CameraMake, exif.FNumber)
+	}
+	if got := exif.Location(); got != "-33.50000, 151.25000" {
+		t.Errorf("Location() = %q, want %q", got, "-33.50000, 151.25000")
+	}
+
+	head, changed, err := scrubMetadata("image/jpeg", head)
+	if err != nil || !changed {
+		t.Fatalf("scrubMetadata = %v, %v, want it to scrub", changed, err)
+	}
+	if exif, _ := parseExif(head); exif.HasLocation() {
+		t.Errorf("location %q left after scrubbing", exif.Location())
+	}
+}
+
+// Crafted EXIF must be ignored, never panic or make
+// parseExif allocate what the counts claim.
+func TestMalformedExif(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(tb *tiffBuilder) uint32
+	}{
+		{"exif pointer without value", func(tb *tiffBuilder) uint32 {
+			return tb.ifd(exifEntry{tagExifIFD, 4, 0, nil})
+		}},
+		{"gps pointer without value", func(tb *tiffBuilder) uint32 {
+			return tb.ifd(exifEntry{tagGPSIFD, 4, 0, nil})
+		}},
+		{"gps pointer past the end", func(tb *tiffBuilder) uint32 {
+			return tb.ifd(exifLong(tagGPSIFD, 0xFFFFFFF0))
+		}},
+		{"second gps pointer past the end", func(tb *tiffBuilder) uint32 {
+			gps := tb.ifd(exifRationals(tagGPSLatitude, 1, 1, 2, 1, 3, 1))
+			return tb.ifd(exifLong(tagGPSIFD, gps), exifLong(tagGPSIFD, 0xFFFFFFF0))
+		}},
+		{"rational count overflowing the size", func(tb *tiffBuilder) uint32 {
+			// 8 bytes times the count wraps around to 8 in 32 bits.
+			fnumber := exifRationals(tagFNumber, 28, 10)
+			fnumber.count = 0x20000001
+			return tb.ifd(exifLong(tagExifIFD, tb.ifd(fnumber)))
+		}},
+		{"rationals without values", func(tb *tiffBuilder) uint32 {
+			gps := tb.ifd(
+				exifEntry{tagGPSLatitude, 5, 0, nil},
+				exifEntry{tagGPSLongitude, 5, 0, nil},
+			)
+			return tb.ifd(exifLong(tagGPSIFD, gps), exifEntry{tagExifIFD, 3, 0, nil})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := newTIFFBuilder()
+			head := tb.jpeg(tt.build(tb))
+			exif, _ := parseExif(head)
+			if exif.FNumber != 0 || exif.HasLocation() {
+				t.Errorf("parseExif read values from malformed EXIF: %+v", exif)
+			}
+			if _, _, err := scrubMetadata("image/jpeg", head); err != nil {
+				t.Errorf("scrubMetadata: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// GallerySort is the order in which the explore
+// feed lists the public galleries.
+type GallerySort string
+
+const (
+	// SortRecent lists the galleries updated last first.
+	SortRecent GallerySort = "recent"
+	// SortViews lists the galleries seen most often first.
+	SortViews GallerySort = "views"
+)
+
+const (
+	// DefaultExploreLimit is how many galleries
+	// a page of the explore feed has.
+	DefaultExploreLimit = 24
+	maxExploreLimit     = 100
+)
+
+// GalleryCursor is the last gallery of a page of the explore feed, the
+// next page starts after it. Galleries are ordered by UpdatedAt or
+// Views and then by ID, so pages don't shift when galleries are added
+// while someone is going through them.
+type GalleryCursor struct {
+	UpdatedAt time.Time
+	Views     int64
+	ID        uint
+}
+
+func cursorOf(g *Gallery) GalleryCursor {
+	return GalleryCursor{UpdatedAt: g.UpdatedAt, Views: g.Views, ID: g.ID}
+}
+
+// String encodes the cursor for a URL.
+func (c GalleryCursor) String() string {
+	s := fmt.Sprintf("%d.%d.%d", c.UpdatedAt.UnixNano(), c.Views, c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// ParseGalleryCursor decodes a cursor made by GalleryCursor.String,
+// it returns ErrInvalidCursor when s is not one. Scanning is lenient
+// about what follows the numbers, so the cursor has to encode back
+// to s.
+func ParseGalleryCursor(s string) (*GalleryCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var nanos int64
+	var c GalleryCursor
+	if _, err := fmt.Sscanf(string(b), "%d.%d.%d", &nanos, &c.Views, &c.ID); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c.UpdatedAt = time.Unix(0, nanos)
+	if c.Views < 0 || c.ID == 0 || c.String() != s {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// ExplorePage is a page of the explore feed. Next is the
+// cursor of the page after it, empty when it is the last one.
+type ExplorePage struct {
+	Galleries []Gallery
+	Sort      GallerySort
+	Next      string
+}
+
+// Explore returns the page of the explore feed which starts after the
+// cursor, or the first page when after is empty. The galleries have
+// their owners set.
+func (gs *galleryService) Explore(sort GallerySort, after string, limit int) (*ExplorePage, error) {
+	var cursor *GalleryCursor
+	if after != "" {
+		var err error
+		if cursor, err = ParseGalleryCursor(after); err != nil {
+			return nil, err
+		}
+	}
+	if limit <= 0 || limit > maxExploreLimit {
+		limit = DefaultExploreLimit
+	}
+	// One more gallery than asked for tells whether there is a next page.
+	var galleries []Gallery
+	var err error
+	switch sort {
+	case SortRecent:
+		galleries, err = gs.RecentlyUpdated(cursor, limit+1)
+	case SortViews:
+		galleries, err = gs.MostViewed(cursor, limit+1)
+	default:
+		return nil, ErrInvalidSort
+	}
+	if err != nil {
+		return nil, err
+	}
+	page := ExplorePage{Sort: sort}
+	if len(galleries) > limit {
+		galleries = galleries[:limit]
+		page.Next = cursorOf(&galleries[limit-1]).String()
+	}
+	owned := make([]*Gallery, len(galleries))
+	for n := range galleries {
+		owned[n] = &galleries[n]
+	}
+	if err := gs.SetOwners(owned...); err != nil {
+		return nil, err
+	}
+	page.Galleries = galleries
+	return &page, nil
+}
+
+// explorable narrows a query down to the galleries anyone
+// can see without a password, of users who are not deleted.
+func explorable(db *gorm.DB) *gorm.DB {
+	return db.
+		Joins("JOIN users ON users.id = galleries.user_id AND users.deleted_at IS NULL").
+		Where("galleries.visibility = ?", VisibilityPublic).
+		Where("COALESCE(galleries.password_hash, '') = ''")
+}
+
+func (gg *galleryGorm) RecentlyUpdated(after *GalleryCursor, limit int) ([]Gallery, error) {
+	db := explorable(gg.db)
+	if after != nil {
+		db = db.Where("(galleries.updated_at, galleries.id) < (?, ?)", after.UpdatedAt, after.ID)
+	}
+	var galleries []Gallery
+	err := db.Order("galleries.updated_at DESC, galleries.id DESC").
+		Limit(limit).
+		Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
+func (gg *galleryGorm) MostViewed(after *GalleryCursor, limit int) ([]Gallery, error) {
+	db := explorable(gg.db)
+	if after != nil {
+		db = db.Where("(galleries.views, galleries.id) < (?, ?)", after.Views, after.ID)
+	}
+	var galleries []Gallery
+	err := db.Order("galleries.views DESC, galleries.id DESC").
+		Limit(limit).
+		Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
+// AddView leaves UpdatedAt as it is, a gallery
+// being seen is not a change to it.
+func (gg *galleryGorm) AddView(id uint) error {
+	return gg.db.Model(&Gallery{}).
+		Where("id = ?", id).
+		UpdateColumn("views", gorm.Expr("views + 1")).Error
+}
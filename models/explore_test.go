@@ -0,0 +1,140 @@
+package models
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestGalleryCursor(t *testing.T) {
+	cursors := []GalleryCursor{
+		{UpdatedAt: time.Date(2024, 2, 29, 23, 59, 59, 999999999, time.UTC), Views: 42, ID: 7},
+		{UpdatedAt: time.Unix(0, 0), Views: 0, ID: 1},
+		{UpdatedAt: time.Date(1969, 7, 20, 20, 17, 0, 0, time.FixedZone("EDT", -4*3600)), Views: 1 << 62, ID: 1<<32 - 1},
+	}
+	for _, c := range cursors {
+		got, err := ParseGalleryCursor(c.String())
+		if err != nil {
+			t.Errorf("ParseGalleryCursor(%v) = %v", c, err)
+			continue
+		}
+		if !got.UpdatedAt.Equal(c.UpdatedAt) || got.Views != c.Views || got.ID != c.ID {
+			t.Errorf("ParseGalleryCursor(%v) = %v", c, got)
+		}
+	}
+
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+	malformed := []string{
+		"",
+		"!!!",
+		"MS4yLjM=", // padded
+		base64.StdEncoding.EncodeToString([]byte("1.2.3>>>?")),
+		encode("1.2"),
+		encode("1.2.3.4"),
+		encode("1.2.3junk"),
+		encode(" 1. 2. 3"),
+		encode("+1.2.3"),
+		encode("01.2.3"),
+		encode("1.-2.3"),
+		encode("1.2.-3"),
+		encode("1.2.0"),
+		encode("a.b.c"),
+		encode("1.2.99999999999999999999"),
+		encode("99999999999999999999.2.3"),
+	}
+	for _, s := range malformed {
+		if c, err := ParseGalleryCursor(s); err != ErrInvalidCursor {
+			t.Errorf("ParseGalleryCursor(%q) = %v, %v, want %v", s, c, err, ErrInvalidCursor)
+		}
+	}
+}
+
+// exploreGalleries lists the galleries, which are sorted by
+// ID from the last, after the cursor.
+type exploreGalleries struct {
+	GalleryDB
+	galleries []Gallery
+	limits    []int
+}
+
+func (eg *exploreGalleries) RecentlyUpdated(after *GalleryCursor, limit int) ([]Gallery, error) {
+	eg.limits = append(eg.limits, limit)
+	var galleries []Gallery
+	for _, g := range eg.galleries {
+		if (after == nil || g.ID < after.ID) && len(galleries) < limit {
+			galleries = append(galleries, g)
+		}
+	}
+	return galleries, nil
+}
+
+func TestExplore(t *testing.T) {
+	galleries := make([]Gallery, 5)
+	for n := range galleries {
+		galleries[n] = Gallery{Model: gorm.Model{ID: uint(5 - n)}, UserID: 1}
+	}
+	eg := &exploreGalleries{galleries: galleries}
+	gs := &galleryService{GalleryDB: eg, user: uploadUsers{}}
+
+	// Going through the pages, the last one has no next cursor.
+	var ids []uint
+	var next string
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatalf("more than 3 pages of 5 galleries by 2")
+		}
+		page, err := gs.Explore(SortRecent, next, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Galleries) > 2 {
+			t.Errorf("page has %d galleries, want no more than 2", len(page.Galleries))
+		}
+		for _, g := range page.Galleries {
+			if g.Owner == nil {
+				t.Errorf("gallery %d has no owner", g.ID)
+			}
+			ids = append(ids, g.ID)
+		}
+		if next = page.Next; next == "" {
+			break
+		}
+		if c, _ := ParseGalleryCursor(next); c.ID != ids[len(ids)-1] {
+			t.Errorf("next page after %d, want after the last gallery %d", c.ID, ids[len(ids)-1])
+		}
+	}
+	if len(ids) != 5 || ids[0] != 5 || ids[4] != 1 {
+		t.Errorf("pages had galleries %v, want 5 to 1", ids)
+	}
+	for _, limit := range eg.limits {
+		if limit != 3 {
+			t.Errorf("asked for %d galleries, want one more than the page", limit)
+		}
+	}
+
+	// A page which is just full is the last one.
+	eg = &exploreGalleries{galleries: galleries[3:]}
+	gs.GalleryDB = eg
+	if page, err := gs.Explore(SortRecent, "", 2); err != nil || len(page.Galleries) != 2 || page.Next != "" {
+		t.Errorf("Explore of a full last page = %+v, %v, want 2 galleries and no next page", page, err)
+	}
+
+	for _, limit := range []int{0, -1, maxExploreLimit + 1} {
+		eg.limits = nil
+		if _, err := gs.Explore(SortRecent, "", limit); err != nil || eg.limits[0] != DefaultExploreLimit+1 {
+			t.Errorf("Explore by %d asked for %v, %v, want the default", limit, eg.limits, err)
+		}
+	}
+
+	eg.limits = nil
+	if _, err := gs.Explore(SortRecent, "bm90IGEgY3Vyc29y", 2); err != ErrInvalidCursor || eg.limits != nil {
+		t.Errorf("Explore after a malformed cursor = %v, want %v", err, ErrInvalidCursor)
+	}
+	if _, err := gs.Explore("oldest", "", 2); err != ErrInvalidSort {
+		t.Errorf("Explore sorted by oldest = %v, want %v", err, ErrInvalidSort)
+	}
+}
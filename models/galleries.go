@@ -1,12 +1,145 @@
 package models
 
-import "github.com/jinzhu/gorm"
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"photo-gallery/hash"
+	"photo-gallery/rand"
+	"time"
+	"unicode/utf8"
 
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Gallery is a titled set of images owned by a user.
+// When StripGPS is set, the GPS position and the serial
+// numbers are removed from the EXIF of every public copy
+// of its images.
+//
+// CoverImageID is the image chosen to represent the
+// gallery, its first image does when there is none.
+//
+// AllowDownloads lets visitors download all originals
+// of the gallery at once, its owner and those who can
+// add images always can.
+//
+// Visibility tells who can see the gallery besides its
+// owner and members, see package policy. ShareSlug is
+// the unguessable name under which an unlisted gallery
+// is shared.
+//
+// A gallery with a password is only shown to visitors
+// who unlocked it with the password, see Unlock.
+//
+// Views counts how often visitors other than the owner
+// have seen the gallery, the explore feed is sorted by it.
+//
+// Slug names the gallery among the ones of its owner in
+// its URL, it is made from the title unless the owner
+// chooses it. Description is Markdown, which is only
+// made HTML when it is shown.
 type Gallery struct {
 	gorm.Model
-	UserID uint    `gorm:"not_null;index"`
-	Title  string  `gorm:"not_null"`
-	Images []Image `gorm:"-"`
+	UserID         uint   `gorm:"not_null;index;unique_index:idx_galleries_user_slug"`
+	Title          string `gorm:"not_null"`
+	Slug           string `gorm:"unique_index:idx_galleries_user_slug"`
+	Description    string `gorm:"type:text;not null;default:''"`
+	Views          int64  `gorm:"not null;default:0;index"`
+	StripGPS       bool   `gorm:"not null;default:false"`
+	AllowDownloads bool   `gorm:"not null;default:false"`
+	Visibility     string `gorm:"not null;default:'public'"`
+	ShareSlug      string `gorm:"unique_index"`
+	Password       string `gorm:"-"`
+	PasswordHash   string
+	CoverImageID   *uint
+	Images         []Image `gorm:"-"`
+	Cover          *Image  `gorm:"-"`
+	// Tags are only loaded where they are shown.
+	Tags Tags `gorm:"-"`
+	// ImageCount is set for the galleries in the explore feed,
+	// whose images are not loaded.
+	ImageCount int `gorm:"-"`
+	// Imports is the outcome of every file of the upload made
+	// last, which is shown on the edit page.
+	Imports []ImportResult `gorm:"-"`
+	// Owner is the user the gallery belongs to, it is
+	// only set by GalleryService.SetOwners.
+	Owner *User `gorm:"-"`
+	// ShareToken is the token of the share link the
+	// gallery is being seen through, if it is.
+	ShareToken string `gorm:"-"`
+	// Role and Can are the role of the user the gallery
+	// is shown to, and what they may do with it.
+	Role Role        `gorm:"-"`
+	Can  Permissions `gorm:"-"`
+}
+
+// Permissions tell the templates what the user a gallery is shown to
+// may do with it, they are worked out by package policy. EditImages
+// is set when the user can change any image, EditOwn when they can
+// change the ones they uploaded.
+type Permissions struct {
+	Download   bool
+	Upload     bool
+	Edit       bool
+	Delete     bool
+	EditImages bool
+	EditOwn    bool
+	UserID     uint
+}
+
+// EditImage reports whether the user can change or delete the image.
+func (p Permissions) EditImage(image Image) bool {
+	return p.EditImages || (p.EditOwn && image.UserID == p.UserID)
+}
+
+const (
+	// VisibilityPublic galleries can be seen by anyone.
+	VisibilityPublic = "public"
+	// VisibilityUnlisted galleries can be seen by anyone
+	// who has the link with their share slug.
+	VisibilityUnlisted = "unlisted"
+	// VisibilityPrivate galleries can only be seen
+	// by their owner and members.
+	VisibilityPrivate = "private"
+)
+
+const (
+	// shareSlugBytes is how much randomness goes into a share slug.
+	shareSlugBytes = 16
+
+	minGalleryPasswordLen = 8
+
+	maxDescriptionLen = 10000
+)
+
+// HasPassword reports whether visitors have to unlock the gallery.
+func (g *Gallery) HasPassword() bool {
+	return g.PasswordHash != ""
+}
+
+// Path returns the path at which the gallery is shown, an unlisted
+// gallery is only shown to visitors under its share slug. One seen
+// through a share link stays under the link. Others are shown under
+// the username of their owner and their slug, or under their number
+// when their owner is not known or has no username fit for a URL.
+func (g *Gallery) Path() string {
+	if g.ShareToken != "" {
+		return "/l/" + url.PathEscape(g.ShareToken)
+	}
+	if g.Visibility == VisibilityUnlisted && g.ShareSlug != "" {
+		return "/s/" + g.ShareSlug
+	}
+	if g.Owner != nil && validUsername(g.Owner.Username) && g.Slug != "" {
+		return "/u/" + g.Owner.Username + "/" + url.PathEscape(g.Slug)
+	}
+	return fmt.Sprintf("/galleries/%v", g.ID)
+}
+
+func (g *Gallery) IsCover(image Image) bool {
+	return g.CoverImageID != nil && *g.CoverImageID == image.ID
 }
 
 func (g *Gallery) ImagesSplitN(n int) [][]Image {
@@ -23,34 +156,110 @@ func (g *Gallery) ImagesSplitN(n int) [][]Image {
 
 type GalleryService interface {
 	GalleryDB
+	// Unlock checks the password of the gallery and returns a token
+	// which proves the visitor knew it, to be kept in a cookie. It
+	// returns ErrInvalidPassword when the password is wrong.
+	Unlock(gallery *Gallery, password string) (string, error)
+	// Unlocked reports whether the token was returned by Unlock for
+	// the gallery and has not expired. Tokens stop working when the
+	// password of the gallery is changed or removed.
+	Unlocked(gallery *Gallery, token string) bool
+	// SetOwners sets the Owner of every gallery, which Path needs.
+	SetOwners(galleries ...*Gallery) error
+	// Explore returns a page of the public galleries, see ExplorePage.
+	Explore(sort GallerySort, after string, limit int) (*ExplorePage, error)
 }
 
 type GalleryDB interface {
 	ByID(id uint) (*Gallery, error)
+	ByShareSlug(slug string) (*Gallery, error)
+	// BySlug looks a gallery up by the username of
+	// its owner and the slug it has now.
+	BySlug(username, slug string) (*Gallery, error)
+	// ByPreviousSlug looks a gallery up by a
+	// slug it had before, see GallerySlug.
+	ByPreviousSlug(username, slug string) (*Gallery, error)
+	// SlugTaken reports whether a gallery of the user other than
+	// the one with galleryID has the slug now or had it before.
+	SlugTaken(userID uint, slug string, galleryID uint) (bool, error)
 	ByUserID(userID uint) ([]Gallery, error)
+	// RecentlyUpdated and MostViewed return up to limit of the galleries
+	// in the explore feed, which are public and have no password. They
+	// start after the cursor unless it is nil.
+	RecentlyUpdated(after *GalleryCursor, limit int) ([]Gallery, error)
+	MostViewed(after *GalleryCursor, limit int) ([]Gallery, error)
+	// AddView counts a view of the gallery.
+	AddView(id uint) error
 	Create(gallery *Gallery) error
 	Update(gallery *Gallery) error
+	// Delete moves the gallery to the trash, from where
+	// it can be restored until it is purged.
 	Delete(id uint) error
+
+	// TrashedByID looks the gallery up only among the deleted ones.
+	TrashedByID(id uint) (*Gallery, error)
+	TrashedByUserID(userID uint) ([]Gallery, error)
+	// TrashedBefore returns the galleries deleted before the time.
+	TrashedBefore(t time.Time) ([]Gallery, error)
+	Restore(id uint) error
+	// Purge deletes the gallery record for good.
+	Purge(id uint) error
 }
 
 type galleryService struct {
 	GalleryDB
+	user   UserDB
+	pepper string
+	hmac   hash.HMAC
 }
 
-func NewGalleryService(db *gorm.DB) GalleryService {
+func NewGalleryService(db *gorm.DB, pepper, hmacKey string) GalleryService {
 	return &galleryService{
-		GalleryDB: &galleryValidator{&galleryGorm{db}},
+		GalleryDB: &galleryValidator{
+			GalleryDB: &galleryGorm{db},
+			pepper:    pepper,
+		},
+		user:   &userGorm{db},
+		pepper: pepper,
+		hmac:   hash.NewHMAC(hmacKey),
 	}
 }
 
+// SetOwners looks every owner up once, however
+// many of the galleries they own.
+func (gs *galleryService) SetOwners(galleries ...*Gallery) error {
+	owners := make(map[uint]*User)
+	for _, g := range galleries {
+		owner, ok := owners[g.UserID]
+		if !ok {
+			var err error
+			owner, err = gs.user.ByID(g.UserID)
+			if err != nil {
+				return err
+			}
+			owners[g.UserID] = owner
+		}
+		g.Owner = owner
+	}
+	return nil
+}
+
 type galleryValidator struct {
 	GalleryDB
+	pepper string
 }
 
 func (gv *galleryValidator) Create(gallery *Gallery) error {
 	err := runGalleryValidations(gallery,
 		gv.titleRequired,
-		gv.userIDRequired)
+		gv.userIDRequired,
+		gv.descriptionMaxLength,
+		gv.slugAvailable,
+		gv.normalizeVisibility,
+		gv.visibilityValid,
+		gv.shareSlugRequired,
+		gv.passwordMinLength,
+		gv.bcryptPassword)
 	if err != nil {
 		return err
 	}
@@ -61,7 +270,14 @@ func (gv *galleryValidator) Create(gallery *Gallery) error {
 func (gv *galleryValidator) Update(gallery *Gallery) error {
 	err := runGalleryValidations(gallery,
 		gv.titleRequired,
-		gv.userIDRequired)
+		gv.userIDRequired,
+		gv.descriptionMaxLength,
+		gv.slugAvailable,
+		gv.normalizeVisibility,
+		gv.visibilityValid,
+		gv.shareSlugRequired,
+		gv.passwordMinLength,
+		gv.bcryptPassword)
 	if err != nil {
 		return err
 	}
@@ -79,6 +295,26 @@ func (gv *galleryValidator) Delete(id uint) error {
 	return gv.GalleryDB.Delete(id)
 }
 
+func (gv *galleryValidator) Restore(id uint) error {
+	var gallery Gallery
+	gallery.ID = id
+	err := runGalleryValidations(&gallery, gv.idGreaterThan(0))
+	if err != nil {
+		return err
+	}
+	return gv.GalleryDB.Restore(id)
+}
+
+func (gv *galleryValidator) Purge(id uint) error {
+	var gallery Gallery
+	gallery.ID = id
+	err := runGalleryValidations(&gallery, gv.idGreaterThan(0))
+	if err != nil {
+		return err
+	}
+	return gv.GalleryDB.Purge(id)
+}
+
 func (gv *galleryValidator) userIDRequired(g *Gallery) error {
 	if g.UserID <= 0 {
 		return ErrUserIDRequired
@@ -93,6 +329,132 @@ func (gv *galleryValidator) titleRequired(g *Gallery) error {
 	return nil
 }
 
+func (gv *galleryValidator) descriptionMaxLength(g *Gallery) error {
+	if utf8.RuneCountInString(g.Description) > maxDescriptionLen {
+		return ErrDescriptionTooLong
+	}
+	return nil
+}
+
+// slugAvailable makes the slug the owner chose fit for a URL, and
+// returns ErrSlugTaken when another of their galleries has or had it.
+// A gallery without a slug gets one made from its title, numbered
+// like "holidays-2" when the user has a gallery of that name already.
+func (gv *galleryValidator) slugAvailable(g *Gallery) error {
+	if g.Slug = slugify(g.Slug); g.Slug != "" {
+		taken, err := gv.SlugTaken(g.UserID, g.Slug, g.ID)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrSlugTaken
+		}
+		return nil
+	}
+	base := slugify(g.Title)
+	if base == "" {
+		base = defaultSlug
+	}
+	for n := 1; ; n++ {
+		slug := base
+		if n > 1 {
+			slug = fmt.Sprintf("%s-%d", base, n)
+		}
+		taken, err := gv.SlugTaken(g.UserID, slug, g.ID)
+		if err != nil {
+			return err
+		}
+		if !taken {
+			g.Slug = slug
+			return nil
+		}
+	}
+}
+
+func (gv *galleryValidator) ByShareSlug(slug string) (*Gallery, error) {
+	if !validShareSlug(slug) {
+		return nil, ErrNotFound
+	}
+	return gv.GalleryDB.ByShareSlug(slug)
+}
+
+func (gv *galleryValidator) BySlug(username, slug string) (*Gallery, error) {
+	if !validUsername(username) || !validSlug(slug) {
+		return nil, ErrNotFound
+	}
+	return gv.GalleryDB.BySlug(username, slug)
+}
+
+func (gv *galleryValidator) ByPreviousSlug(username, slug string) (*Gallery, error) {
+	if !validUsername(username) || !validSlug(slug) {
+		return nil, ErrNotFound
+	}
+	return gv.GalleryDB.ByPreviousSlug(username, slug)
+}
+
+func (gv *galleryValidator) normalizeVisibility(g *Gallery) error {
+	if g.Visibility == "" {
+		g.Visibility = VisibilityPublic
+	}
+	return nil
+}
+
+func (gv *galleryValidator) visibilityValid(g *Gallery) error {
+	switch g.Visibility {
+	case VisibilityPublic, VisibilityUnlisted, VisibilityPrivate:
+		return nil
+	}
+	return ErrInvalidVisibility
+}
+
+// shareSlugRequired gives every gallery a share slug, so it can
+// be made unlisted at any time. Galleries created before there
+// were slugs get theirs when they are next updated.
+func (gv *galleryValidator) shareSlugRequired(g *Gallery) error {
+	if g.ShareSlug != "" {
+		return nil
+	}
+	b, err := rand.GenBytes(shareSlugBytes)
+	if err != nil {
+		return err
+	}
+	g.ShareSlug = hex.EncodeToString(b)
+	return nil
+}
+
+func (gv *galleryValidator) passwordMinLength(g *Gallery) error {
+	if g.Password != "" && utf8.RuneCountInString(g.Password) < minGalleryPasswordLen {
+		return ErrShortGalleryPassword
+	}
+	return nil
+}
+
+// bcryptPassword hashes the password like the one of a user,
+// an empty password leaves the one of the gallery as it is.
+func (gv *galleryValidator) bcryptPassword(g *Gallery) error {
+	if g.Password == "" {
+		return nil
+	}
+	pwBytes := []byte(g.Password + gv.pepper)
+	hashedBytes, err := bcrypt.GenerateFromPassword(pwBytes, bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	g.PasswordHash = string(hashedBytes)
+	g.Password = ""
+	return nil
+}
+
+// validShareSlug makes sure a slug from a URL is one
+// shareSlugRequired could have made.
+func validShareSlug(slug string) bool {
+	if len(slug) != 2*shareSlugBytes {
+		return false
+	}
+	_, err := hex.DecodeString(slug)
+	return err == nil
+}
+
 var _ GalleryDB = &galleryGorm{}
 
 type galleryGorm struct {
@@ -107,6 +469,50 @@ func (gg *galleryGorm) ByID(id uint) (*Gallery, error) {
 	return &gallery, err
 }
 
+func (gg *galleryGorm) ByShareSlug(slug string) (*Gallery, error) {
+	var gallery Gallery
+	db := gg.db.Where("share_slug = ?", slug)
+	err := first(db, &gallery)
+	return &gallery, err
+}
+
+// BySlug leaves out galleries of deleted users,
+// whose usernames may be taken again.
+func (gg *galleryGorm) BySlug(username, slug string) (*Gallery, error) {
+	var gallery Gallery
+	db := gg.db.
+		Joins("JOIN users ON users.id = galleries.user_id AND users.deleted_at IS NULL").
+		Where("users.username = ? AND galleries.slug = ?", username, slug)
+	err := first(db, &gallery)
+	return &gallery, err
+}
+
+func (gg *galleryGorm) ByPreviousSlug(username, slug string) (*Gallery, error) {
+	var gallery Gallery
+	db := gg.db.
+		Joins("JOIN gallery_slugs ON gallery_slugs.gallery_id = galleries.id").
+		Joins("JOIN users ON users.id = galleries.user_id AND users.deleted_at IS NULL").
+		Where("users.username = ? AND gallery_slugs.slug = ?", username, slug)
+	err := first(db, &gallery)
+	return &gallery, err
+}
+
+// SlugTaken counts the galleries in the trash too,
+// as they get their slugs back when restored.
+func (gg *galleryGorm) SlugTaken(userID uint, slug string, galleryID uint) (bool, error) {
+	var count int
+	err := gg.db.Unscoped().Model(&Gallery{}).
+		Where("user_id = ? AND slug = ? AND id <> ?", userID, slug, galleryID).
+		Count(&count).Error
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+	err = gg.db.Model(&GallerySlug{}).
+		Where("user_id = ? AND slug = ? AND gallery_id <> ?", userID, slug, galleryID).
+		Count(&count).Error
+	return count > 0, err
+}
+
 func (gg *galleryGorm) ByUserID(userID uint) ([]Gallery, error) {
 	var galleries []Gallery
 	err := gg.db.Where("user_id = ?", userID).Find(&galleries).Error
@@ -121,8 +527,34 @@ func (gg *galleryGorm) Create(gallery *Gallery) error {
 	return gg.db.Create(gallery).Error
 }
 
+// Update keeps the slug the gallery had in its history when it is given
+// another one. A slug it had before is taken out of the history when
+// the gallery is given it back.
 func (gg *galleryGorm) Update(gallery *Gallery) error {
-	return gg.db.Save(gallery).Error
+	return gg.db.Transaction(func(tx *gorm.DB) error {
+		var old Gallery
+		if err := first(tx.Unscoped().Where("id = ?", gallery.ID), &old); err != nil {
+			return err
+		}
+		if old.Slug != gallery.Slug {
+			err := tx.Where("gallery_id = ? AND slug = ?", gallery.ID, gallery.Slug).
+				Delete(&GallerySlug{}).Error
+			if err != nil {
+				return err
+			}
+			if old.Slug != "" {
+				previous := GallerySlug{
+					GalleryID: gallery.ID,
+					UserID:    gallery.UserID,
+					Slug:      old.Slug,
+				}
+				if err := tx.Create(&previous).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Save(gallery).Error
+	})
 }
 
 func (gg *galleryGorm) Delete(id uint) error {
@@ -130,6 +562,63 @@ func (gg *galleryGorm) Delete(id uint) error {
 	return gg.db.Delete(&gallery).Error
 }
 
+func (gg *galleryGorm) TrashedByID(id uint) (*Gallery, error) {
+	var gallery Gallery
+	db := gg.db.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id)
+	err := first(db, &gallery)
+	return &gallery, err
+}
+
+func (gg *galleryGorm) TrashedByUserID(userID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	err := gg.db.Unscoped().
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at DESC").
+		Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
+func (gg *galleryGorm) TrashedBefore(t time.Time) ([]Gallery, error) {
+	var galleries []Gallery
+	err := gg.db.Unscoped().Where("deleted_at < ?", t).Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
+func (gg *galleryGorm) Restore(id uint) error {
+	return gg.db.Unscoped().Model(&Gallery{}).
+		Where("id = ?", id).
+		Update("deleted_at", gorm.Expr("NULL")).Error
+}
+
+// Purge deletes the share links, memberships, previous
+// slugs and tags of the gallery along with it.
+func (gg *galleryGorm) Purge(id uint) error {
+	err := gg.db.Unscoped().Where("gallery_id = ?", id).Delete(&ShareLink{}).Error
+	if err != nil {
+		return err
+	}
+	err = gg.db.Unscoped().Where("gallery_id = ?", id).Delete(&Membership{}).Error
+	if err != nil {
+		return err
+	}
+	err = gg.db.Where("gallery_id = ?", id).Delete(&GallerySlug{}).Error
+	if err != nil {
+		return err
+	}
+	err = gg.db.Where("gallery_id = ?", id).Delete(&GalleryTag{}).Error
+	if err != nil {
+		return err
+	}
+	gallery := Gallery{Model: gorm.Model{ID: id}}
+	return gg.db.Unscoped().Delete(&gallery).Error
+}
+
 type galleryValidationFunc func(*Gallery) error
 
 func runGalleryValidations(gallery *Gallery, fns ...galleryValidationFunc) error {
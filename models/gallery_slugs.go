@@ -0,0 +1,71 @@
+package models
+
+import (
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// GallerySlug is a slug a gallery had before it was given another one.
+// Links with it are redirected to the gallery, so renaming a gallery
+// doesn't break the links which were shared. Slugs in the history are
+// taken, like the ones galleries have now, until the gallery is purged.
+type GallerySlug struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	GalleryID uint   `gorm:"not null;index"`
+	UserID    uint   `gorm:"not null;unique_index:idx_gallery_slugs_user_slug"`
+	Slug      string `gorm:"not null;unique_index:idx_gallery_slugs_user_slug"`
+}
+
+const (
+	// maxSlugLen is how many characters of the title go into a slug,
+	// a number may be added to tell it apart from the slugs taken.
+	maxSlugLen = 80
+	// defaultSlug is the slug of galleries whose
+	// titles have no letters or digits.
+	defaultSlug = "gallery"
+)
+
+// slugify turns a title into a slug: letters and digits in lower case,
+// with a dash for every run of anything else in between.
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	n := 0
+	for _, r := range s {
+		if n == maxSlugLen {
+			break
+		}
+		if !unicode.In(r, unicode.Letter, unicode.Digit, unicode.Mark) {
+			dash = b.Len() > 0
+			continue
+		}
+		if dash {
+			b.WriteByte('-')
+			n++
+			dash = false
+		}
+		b.WriteRune(unicode.ToLower(r))
+		n++
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// validSlug makes sure a slug from a URL is one slugify could have
+// made, a number may have been added to it.
+func validSlug(slug string) bool {
+	if slug == "" || utf8.RuneCountInString(slug) > maxSlugLen+10 {
+		return false
+	}
+	if strings.HasPrefix(slug, "-") || strings.HasSuffix(slug, "-") || strings.Contains(slug, "--") {
+		return false
+	}
+	for _, r := range slug {
+		if r != '-' && !unicode.In(r, unicode.Letter, unicode.Digit, unicode.Mark) || unicode.ToLower(r) != r {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// GalleryUnlockDuration is how long a visitor who gave the
+// password of a gallery can see it without giving it again.
+const GalleryUnlockDuration = 30 * 24 * time.Hour
+
+func (gs *galleryService) Unlock(gallery *Gallery, password string) (string, error) {
+	if !gallery.HasPassword() {
+		return "", ErrInvalidPassword
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(gallery.PasswordHash), []byte(password+gs.pepper))
+	if err != nil {
+		switch err {
+		case bcrypt.ErrMismatchedHashAndPassword:
+			return "", ErrInvalidPassword
+		default:
+			return "", err
+		}
+	}
+	expires := strconv.FormatInt(time.Now().Add(GalleryUnlockDuration).Unix(), 10)
+	return expires + "." + gs.unlockSignature(gallery, expires), nil
+}
+
+// Tokens are the time they expire at and a signature of that time
+// together with the gallery and its password hash, so they can't be
+// moved to another gallery and die with the password.
+func (gs *galleryService) Unlocked(gallery *Gallery, token string) bool {
+	if !gallery.HasPassword() {
+		return true
+	}
+	expires, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	want := gs.unlockSignature(gallery, expires)
+	return subtle.ConstantTimeCompare([]byte(sig), []byte(want)) == 1
+}
+
+func (gs *galleryService) unlockSignature(gallery *Gallery, expires string) string {
+	return gs.hmac.HashFun(fmt.Sprintf("gallery:%v:%v:%v", gallery.ID, expires, gallery.PasswordHash))
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"photo-gallery/hash"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestUnlock(t *testing.T) {
+	gs := &galleryService{pepper: "pepper", hmac: hash.NewHMAC("key")}
+	gv := &galleryValidator{pepper: "pepper"}
+	withPassword := func(id uint, password string) *Gallery {
+		g := &Gallery{Model: gorm.Model{ID: id}, Password: password}
+		if err := gv.bcryptPassword(g); err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+	gallery := withPassword(1, "open sesame")
+
+	if _, err := gs.Unlock(gallery, "open sesame!"); err != ErrInvalidPassword {
+		t.Errorf("Unlock with a wrong password = %v, want %v", err, ErrInvalidPassword)
+	}
+	if _, err := gs.Unlock(&Gallery{Model: gorm.Model{ID: 2}}, ""); err != ErrInvalidPassword {
+		t.Errorf("Unlock of a gallery without a password = %v, want %v", err, ErrInvalidPassword)
+	}
+	token, err := gs.Unlock(gallery, "open sesame")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	later := strconv.FormatInt(time.Now().Add(2*GalleryUnlockDuration).Unix(), 10)
+	expires, sig, _ := strings.Cut(token, ".")
+	other := *gallery
+	other.ID = 2
+	tests := []struct {
+		name    string
+		gallery *Gallery
+		token   string
+		want    bool
+	}{
+		{"token", gallery, token, true},
+		{"no password", &Gallery{Model: gorm.Model{ID: 2}}, "", true},
+		{"expired", gallery, expired + "." + gs.unlockSignature(gallery, expired), false},
+		{"expiry pushed back", gallery, later + "." + sig, false},
+		{"another gallery with the same password hash", &other, token, false},
+		{"another gallery", withPassword(2, "open sesame"), token, false},
+		{"password changed", withPassword(1, "open sesame"), token, false},
+		{"signature only", gallery, sig, false},
+		{"expiry only", gallery, expires, false},
+		{"empty", gallery, "", false},
+		{"not a time", gallery, "soon." + sig, false},
+		{"truncated signature", gallery, token[:len(token)-1], false},
+	}
+	for _, tt := range tests {
+		if got := gs.Unlocked(tt.gallery, tt.token); got != tt.want {
+			t.Errorf("%s: Unlocked = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+)
+
+// GarbageReport lists what CollectGarbage found, or would
+// have removed when it was a dry run.
+type GarbageReport struct {
+	DryRun    bool
+	Galleries []OrphanGallery
+}
+
+// OrphanGallery is the data left behind by a gallery
+// which does not exist anymore.
+type OrphanGallery struct {
+	GalleryID uint
+	Images    int
+	Blobs     int
+}
+
+func (r *GarbageReport) Images() int {
+	n := 0
+	for _, g := range r.Galleries {
+		n += g.Images
+	}
+	return n
+}
+
+func (r *GarbageReport) Blobs() int {
+	n := 0
+	for _, g := range r.Galleries {
+		n += g.Blobs
+	}
+	return n
+}
+
+func (is *imageService) CollectGarbage(dryRun bool) (*GarbageReport, error) {
+	orphans := make(map[uint]*OrphanGallery)
+	var ids []uint
+	orphan := func(galleryID uint) (*OrphanGallery, error) {
+		if o, ok := orphans[galleryID]; ok {
+			return o, nil
+		}
+		_, err := is.gallery.ByID(galleryID)
+		if err == ErrNotFound {
+			// Galleries in the trash are purged once their time is up.
+			_, err = is.gallery.TrashedByID(galleryID)
+		}
+		switch err {
+		case nil:
+			orphans[galleryID] = nil
+			return nil, nil
+		case ErrNotFound:
+			o := &OrphanGallery{GalleryID: galleryID}
+			orphans[galleryID] = o
+			ids = append(ids, galleryID)
+			return o, nil
+		}
+		return nil, err
+	}
+
+	galleryIDs, err := is.GalleryIDs()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range galleryIDs {
+		o, err := orphan(id)
+		if err != nil {
+			return nil, err
+		}
+		if o != nil {
+			images, err := is.AllByGalleryID(id)
+			if err != nil {
+				return nil, err
+			}
+			o.Images = len(images)
+		}
+	}
+
+	keys, err := is.store.List(galleriesKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	trashed, err := is.store.List(trashKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range trashed {
+		keys = append(keys, strings.TrimPrefix(key, trashKeyPrefix))
+	}
+	for _, key := range keys {
+		id, ok := keyGalleryID(key)
+		if !ok {
+			continue
+		}
+		o, err := orphan(id)
+		if err != nil {
+			return nil, err
+		}
+		if o != nil {
+			o.Blobs++
+		}
+	}
+
+	report := &GarbageReport{DryRun: dryRun}
+	for _, id := range ids {
+		report.Galleries = append(report.Galleries, *orphans[id])
+		if dryRun {
+			continue
+		}
+		if err := is.PurgeGallery(id); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// keyGalleryID returns the ID of the gallery a key
+// of the form "galleries/<id>/..." belongs to.
+func keyGalleryID(key string) (uint, bool) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) < 3 || parts[0]+"/" != galleriesKeyPrefix {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"bytes"
+	"log"
+)
+
+// Kinds of the background jobs run by the ImageService.
+const (
+	JobProcessImage = "image:process"
+	JobScrubGallery = "gallery:scrub"
+)
+
+type imageJob struct {
+	ImageID uint `json:"image_id"`
+}
+
+type galleryJob struct {
+	GalleryID uint `json:"gallery_id"`
+}
+
+// processImage generates the renditions of a freshly uploaded image.
+// Once it failed for good, the image is marked by processImageFailed.
+func (is *imageService) processImage(job *Job) error {
+	var p imageJob
+	if err := job.Decode(&p); err != nil {
+		return err
+	}
+	i, err := is.ByID(p.ImageID)
+	if err == ErrNotFound {
+		// The image was deleted before it got processed.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	i.Renditions, err = is.generateRenditions(i)
+	if err != nil {
+		return err
+	}
+	i.Status = ImageReady
+	return is.ImageDB.Update(i)
+}
+
+// processImageFailed marks the image of a processing job which failed
+// for good as failed, whatever made the job fail, so the image doesn't
+// stay processing forever. The last attempt may have made some of the
+// renditions before it failed, any there are get deleted.
+func (is *imageService) processImageFailed(job *Job) error {
+	var p imageJob
+	if err := job.Decode(&p); err != nil {
+		return err
+	}
+	i, err := is.ByID(p.ImageID)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// The renditions of the last attempt are in the current format.
+	i.RenditionExt, _, _ = renditionFormat(i.ContentType)
+	i.Renditions = nil
+	for _, r := range is.cfg.Renditions {
+		i.Renditions = append(i.Renditions, r.Name)
+	}
+	if err := is.deleteRenditions(i); err != nil {
+		log.Println(err)
+	}
+	i.Renditions = nil
+	i.Status = ImageFailed
+	return is.ImageDB.Update(i)
+}
+
+// scrubGallery removes the GPS position and the serial numbers from
+// the metadata of every image in the gallery, and the location saved
+// with them. Renditions are re-encoded without any metadata, so only
+// originals are scrubbed. An original whose metadata can't be found
+// is moved to the trash rather than left as it is.
+func (is *imageService) scrubGallery(job *Job) error {
+	var p galleryJob
+	if err := job.Decode(&p); err != nil {
+		return err
+	}
+	images, err := is.ByGalleryID(p.GalleryID)
+	if err != nil {
+		return err
+	}
+	for _, i := range images {
+		b, err := is.readOriginal(&i)
+		if err != nil {
+			return err
+		}
+		b, changed, err := scrubMetadata(i.ContentType, b)
+		if err == ErrImageMetadata {
+			log.Printf("models: moving image %d to the trash, its metadata can't be scrubbed", i.ID)
+			if err := is.Delete(&i); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !changed && !i.HasLocation() {
+			continue
+		}
+		if changed {
+			if err := is.writeOriginal(&i, bytes.NewReader(b)); err != nil {
+				return err
+			}
+		}
+		i.Latitude, i.Longitude = nil, nil
+		if err := is.ImageDB.Update(&i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
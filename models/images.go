@@ -1,92 +1,1009 @@
 package models
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/url"
-	"os"
-	"path/filepath"
+	"path"
+	"photo-gallery/rand"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
 )
 
+// Image is a single photo stored in a gallery. The record keeps
+// the metadata of the upload, while the bytes themselves live in
+// the BlobStore under Key. CreatedAt is the upload time.
+//
+// Renditions holds the names of the resized copies which were
+// generated for the image, see Rendition. They are generated by
+// a background job, and Status tells whether it has finished.
+//
+// Filename is generated by the server and names the blob of the
+// image, while OriginalFilename is the name the file was uploaded
+// with, which is only kept to show it and to name downloads.
+//
+// Title, Caption and AltText are written by the owner, see Alt for
+// the text used when AltText is empty.
+//
+// Images of a gallery are ordered by Position, new
+// images are put after the ones already there.
+//
+// The checksum is the one of the stored original, which differs from
+// the uploaded one when its EXIF was scrubbed.
+//
+// RenditionExt is the extension of the format the renditions were
+// encoded in, which is saved as the format of a type can change.
 type Image struct {
-	GalleryID uint
-	Filename  string
+	gorm.Model
+	GalleryID        uint   `gorm:"not null;index"`
+	UserID           uint   `gorm:"not null;index"`
+	Filename         string `gorm:"not null"`
+	OriginalFilename string
+	Size             int64
+	ContentType      string
+	Width            int
+	Height           int
+	Checksum         string         `gorm:"size:64"`
+	Renditions       pq.StringArray `gorm:"type:text[]"`
+	RenditionExt     string
+	Status           string `gorm:"not null;default:'ready'"`
+	Position         int    `gorm:"not null;default:0"`
+	Title            string
+	Caption          string `gorm:"type:text"`
+	AltText          string
+	Exif
+	// Tags are only loaded where they are shown.
+	Tags Tags `gorm:"-"`
+}
+
+const (
+	ImageProcessing = "processing"
+	ImageReady      = "ready"
+	ImageFailed     = "failed"
+)
+
+// Alt returns the alternative text of the image, which falls
+// back to its title and then to the name it was uploaded with.
+func (i *Image) Alt() string {
+	switch {
+	case i.AltText != "":
+		return i.AltText
+	case i.Title != "":
+		return i.Title
+	}
+	return i.OriginalFilename
+}
+
+// Processing reports whether the renditions of the
+// image are still being generated in the background.
+func (i *Image) Processing() bool {
+	return i.Status == ImageProcessing
+}
+
+func (i *Image) Failed() bool {
+	return i.Status == ImageFailed
 }
 
+// ImagesURLPrefix is the path under which the blobs of images are served.
+const ImagesURLPrefix = "/images/"
+
+// imageVersionLen is how much of the checksum goes into the
+// URLs of an image.
+const imageVersionLen = 16
+
 func (i *Image) Path() string {
 	encodedParh := url.URL{
-		Path: "/" + i.RelativePath(),
+		Path:     ImagesURLPrefix + i.Key(),
+		RawQuery: i.versionQuery(),
 	}
 	return encodedParh.String()
 }
 
-func (i *Image) RelativePath() string {
-	return fmt.Sprintf("images/galleries/%v/%v", i.GalleryID, i.Filename)
+// Version identifies the content of the image. It is part of the URLs
+// of the image and its renditions, so they change whenever the content
+// does, such as when location data is removed from the original.
+func (i *Image) Version() string {
+	if len(i.Checksum) < imageVersionLen {
+		return ""
+	}
+	return i.Checksum[:imageVersionLen]
+}
+
+func (i *Image) versionQuery() string {
+	if v := i.Version(); v != "" {
+		return "v=" + v
+	}
+	return ""
+}
+
+// ETag returns the strong entity tag of the original, or of the named
+// rendition, which is made from the original. It is empty for images
+// stored before checksums were kept.
+func (i *Image) ETag(rendition string) string {
+	if i.Checksum == "" {
+		return ""
+	}
+	if rendition == "" {
+		return `"` + i.Checksum + `"`
+	}
+	return `"` + i.Checksum + "-" + rendition + `"`
+}
+
+// Key returns the key of the original image in the BlobStore.
+func (i *Image) Key() string {
+	return fmt.Sprintf("%v%v", galleryKeyPrefix(i.GalleryID), i.Filename)
+}
+
+// blobKeys returns the keys of the original and of the renditions.
+func (i *Image) blobKeys() []string {
+	keys := []string{i.Key()}
+	for _, name := range i.Renditions {
+		keys = append(keys, i.renditionKey(name))
+	}
+	return keys
+}
+
+// galleriesKeyPrefix is the prefix of the keys of all image blobs.
+const galleriesKeyPrefix = "galleries/"
+
+// galleryKeyPrefix returns the prefix of the keys of
+// all the blobs which belong to the gallery.
+func galleryKeyPrefix(galleryID uint) string {
+	return fmt.Sprintf("%v%v/", galleriesKeyPrefix, galleryID)
+}
+
+// RenditionPath returns the path of the named rendition of the image,
+// falling back to the original when there is no such rendition.
+func (i *Image) RenditionPath(name string) string {
+	if !i.HasRendition(name) {
+		return i.Path()
+	}
+	encodedPath := url.URL{
+		Path:     ImagesURLPrefix + i.renditionKey(name),
+		RawQuery: i.versionQuery(),
+	}
+	return encodedPath.String()
+}
+
+func (i *Image) HasRendition(name string) bool {
+	for _, r := range i.Renditions {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Image) renditionKey(name string) string {
+	return fmt.Sprintf("%vrenditions/%v/%v", galleryKeyPrefix(i.GalleryID), name, renditionFilename(i.Filename, i.renditionExt()))
 }
 
+// renditionExt returns RenditionExt, images processed before it was
+// saved have JPEG renditions unless they are PNGs.
+func (i *Image) renditionExt() string {
+	if i.RenditionExt != "" {
+		return i.RenditionExt
+	}
+	if i.ContentType == "image/png" {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// ImageService is used to store uploaded images and
+// to keep their records and files in sync.
 type ImageService interface {
-	Create(ggallerID uint, r io.ReadCloser, filename string) error
+	ByID(id uint) (*Image, error)
+	ByFilename(galleryID uint, filename string) (*Image, error)
+	// ByKey returns the image which the blob under the key belongs to
+	// and the name of the rendition it is, which is empty when it is
+	// the original.
+	ByKey(key string) (*Image, string, error)
+	// ByGalleryID returns the images of the gallery in their order.
+	ByGalleryID(galleryID uint) ([]Image, error)
+	// Cover returns the image chosen as the cover of the gallery, or its
+	// first image when there is no such image. It returns nil when the
+	// gallery is empty.
+	Cover(gallery *Gallery) (*Image, error)
+	// Reorder moves the images with the given IDs to the given positions,
+	// the other images keep theirs. The positions of all images of the
+	// gallery are then renumbered starting from 1, so they stay unique.
+	Reorder(galleryID uint, positions map[uint]int) error
+	// Create stores the content of r together with its
+	// renditions and saves the image record. GalleryID, UserID and
+	// OriginalFilename must be set by the caller, the rest of the
+	// metadata is filled in from the content.
+	//
+	// Content which is not an allowed image or which is over the
+	// limits of ImageConfig is rejected with a public error.
+	//
+	// Renditions are generated by a background job, so the image
+	// is Processing when Create returns.
+	Create(image *Image, r io.ReadCloser) error
+	// Import creates an image for every file of a ZIP archive through
+	// Create, with the GalleryID and UserID of image. Hidden files and
+	// the metadata folders added by macOS are skipped, any other file
+	// which is not an allowed image fails like it would when uploaded
+	// on its own.
+	//
+	// The returned error is about the archive as a whole, the outcome
+	// of every entry is in the results. Archives over MaxArchiveBytes
+	// are rejected before any entry is read.
+	Import(image Image, r io.ReaderAt, size int64) ([]ImportResult, error)
+	// Update saves the changes to an image record, it is
+	// used to edit its title, caption and alt text.
+	Update(image *Image) error
+	// Open returns the content of the original image.
+	Open(image *Image) (io.ReadCloser, error)
+	// Delete moves the image and its files to the trash.
+	Delete(image *Image) error
+	// Trash returns what the user has in the trash.
+	Trash(userID uint) (*Trash, error)
+	// TrashedByID looks the image up only among the deleted ones.
+	TrashedByID(id uint) (*Image, error)
+	// TrashGallery moves the files of the images of a gallery, which
+	// was just deleted, to the trash.
+	TrashGallery(galleryID uint) error
+	// RestoreGallery takes a gallery and its images out of the trash.
+	RestoreGallery(gallery *Gallery) error
+	// RestoreImage takes an image out of the trash, its gallery
+	// must not be in the trash.
+	RestoreImage(image *Image) error
+	// PurgeImage deletes an image in the trash for good.
+	PurgeImage(image *Image) error
+	// PurgeGallery deletes the gallery and all its images for good,
+	// together with any other blob stored for it.
+	PurgeGallery(galleryID uint) error
+	// CollectGarbage looks for image records and blobs of galleries
+	// which do not exist anymore, not even in the trash, and deletes
+	// them unless dryRun is set.
+	CollectGarbage(dryRun bool) (*GarbageReport, error)
+	// ScrubGallery enqueues a job which removes the GPS position and
+	// the serial numbers from the images already in the gallery.
+	ScrubGallery(galleryID uint) error
+	Usage(user *User) (*Usage, error)
+	// CountByGalleryIDs returns how many images each of the galleries
+	// has, galleries without images are left out.
+	CountByGalleryIDs(galleryIDs []uint) (map[uint]int, error)
+}
+
+// ImageDB is used to interact with the images table in database.
+type ImageDB interface {
+	ByID(id uint) (*Image, error)
+	ByFilename(galleryID uint, filename string) (*Image, error)
 	ByGalleryID(galleryID uint) ([]Image, error)
-	Delete(i *Image) error
+	// UsageByUserID counts the images in the trash as well,
+	// since they still take up storage.
+	UsageByUserID(userID uint) (*Usage, error)
+	NextPosition(galleryID uint) (int, error)
+	CountByGalleryIDs(galleryIDs []uint) (map[uint]int, error)
+	// GalleryIDs returns the IDs of all galleries which have images,
+	// including the ones in the trash.
+	GalleryIDs() ([]uint, error)
+
+	// TrashedByID looks the image up only among the deleted ones.
+	TrashedByID(id uint) (*Image, error)
+	// TrashedForUserID returns the deleted images the user can
+	// restore, which are in galleries that are not deleted
+	// themselves: all of the ones in the galleries of the user,
+	// and the ones they uploaded to galleries they are an editor of.
+	TrashedForUserID(userID uint) ([]Image, error)
+	// TrashedBefore returns the images deleted before the time.
+	TrashedBefore(t time.Time) ([]Image, error)
+	// AllByGalleryID returns the images of the gallery
+	// including the ones in the trash.
+	AllByGalleryID(galleryID uint) ([]Image, error)
+
+	Create(image *Image) error
+	// CreateWithinQuota creates the image unless it takes its user
+	// over their quota, defaultQuota unless an admin set another.
+	CreateWithinQuota(image *Image, defaultQuota int64) error
+	Update(image *Image) error
+	// Delete moves the image record to the trash.
+	Delete(id uint) error
+	Restore(id uint) error
+	// Purge deletes the image record for good.
+	Purge(id uint) error
 }
 
-func NewImageService() ImageService {
-	return &imageService{}
+// imageHeadSize is enough to hold the EXIF segment of a JPEG,
+// which can't be longer than 64KB, along with the image header,
+// even when an ICC profile or XMP come before it.
+const imageHeadSize = 512 << 10
+
+const (
+	storageFilenameBytes   = 16
+	maxFilenameBytes       = 255
+	maxOriginalFilenameLen = 255
+	maxImageTitleLen       = 255
+	maxImageAltTextLen     = 500
+	maxImageCaptionLen     = 2000
+)
+
+// ImageConfig is used to tune the ImageService. Zero limits
+// are replaced with the default ones.
+type ImageConfig struct {
+	Renditions []Rendition `json:"renditions"`
+	// MaxBytes limits the size of an uploaded file.
+	MaxBytes int64 `json:"max_bytes"`
+	// MaxRequestBytes limits the size of a whole upload
+	// request, which may have many files.
+	MaxRequestBytes int64 `json:"max_request_bytes"`
+	// MaxPixels limits width times height of an uploaded image,
+	// which is what it takes in memory once decoded.
+	MaxPixels int `json:"max_pixels"`
+	// MaxDimension limits both the width and the height of an uploaded image.
+	MaxDimension int `json:"max_dimension"`
+	// QuotaBytes limits the total size of the images of a user,
+	// unless an admin sets another quota for them.
+	QuotaBytes int64 `json:"quota_bytes"`
+	// MaxArchiveEntries limits the number of files
+	// in an archive which is imported at once.
+	MaxArchiveEntries int `json:"max_archive_entries"`
+	// MaxArchiveBytes limits the size of an archive which is imported
+	// at once, which is kept on disk while it is imported.
+	MaxArchiveBytes int64 `json:"max_archive_bytes"`
+	// TrashRetentionDays is how long deleted galleries and
+	// images are kept in the trash before they are purged.
+	TrashRetentionDays int `json:"trash_retention_days"`
 }
 
-type imageService struct{}
+const (
+	DefaultMaxBytes           = 25 << 20  // 25 Megabytes
+	DefaultMaxRequestBytes    = 500 << 20 // 500 Megabytes
+	DefaultMaxPixels          = 50000000  // 50 Megapixels
+	DefaultMaxDimension       = 12000
+	DefaultTrashRetentionDays = 30
+)
+
+var _ ImageService = &imageService{}
 
-func (is *imageService) Create(gallerID uint, r io.ReadCloser, filename string) error {
+type imageService struct {
+	ImageDB
+	gallery GalleryDB
+	user    UserDB
+	store   BlobStore
+	jobs    JobService
+	cfg     ImageConfig
+}
+
+// NewImageService registers the handlers of the image jobs with the
+// JobService, so it must be called before the workers are started.
+func NewImageService(db *gorm.DB, store BlobStore, jobs JobService, cfg ImageConfig) ImageService {
+	if len(cfg.Renditions) == 0 {
+		cfg.Renditions = DefaultRenditions
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultMaxBytes
+	}
+	if cfg.MaxRequestBytes <= 0 {
+		cfg.MaxRequestBytes = DefaultMaxRequestBytes
+	}
+	if cfg.MaxPixels <= 0 {
+		cfg.MaxPixels = DefaultMaxPixels
+	}
+	if cfg.MaxDimension <= 0 {
+		cfg.MaxDimension = DefaultMaxDimension
+	}
+	if cfg.QuotaBytes <= 0 {
+		cfg.QuotaBytes = DefaultQuotaBytes
+	}
+	if cfg.MaxArchiveEntries <= 0 {
+		cfg.MaxArchiveEntries = DefaultMaxArchiveEntries
+	}
+	if cfg.MaxArchiveBytes <= 0 {
+		cfg.MaxArchiveBytes = DefaultMaxArchiveBytes
+	}
+	if cfg.TrashRetentionDays <= 0 {
+		cfg.TrashRetentionDays = DefaultTrashRetentionDays
+	}
+	is := &imageService{
+		ImageDB: &imageValidator{&imageGorm{db}},
+		gallery: &galleryGorm{db},
+		user:    &userGorm{db},
+		store:   store,
+		jobs:    jobs,
+		cfg:     cfg,
+	}
+	jobs.Handle(JobProcessImage, is.processImage)
+	jobs.HandleFailure(JobProcessImage, is.processImageFailed)
+	jobs.Handle(JobScrubGallery, is.scrubGallery)
+	jobs.Handle(JobPurgeTrash, is.purgeTrash)
+	jobs.Schedule(JobPurgeTrash, trashPurgeInterval)
+	return is
+}
+
+func (is *imageService) Create(i *Image, r io.ReadCloser) error {
 	defer r.Close()
-	path, err := is.mkImagePath(gallerID)
+	err := runImageValidations(i,
+		requireImageGalleryID,
+		requireImageUserID,
+		normalizeOriginalFilename,
+		requireOriginalFilename)
 	if err != nil {
 		return err
 	}
+	gallery, err := is.gallery.ByID(i.GalleryID)
+	if err != nil {
+		return err
+	}
+
+	// Only the head of the file is needed to sniff its type and dimensions
+	// and to read its EXIF, the rest is streamed straight to the store.
+	// Unlike renditions, none of it is left to the background job: the
+	// checksum is computed on the way to the store, and the metadata has
+	// to be scrubbed before anybody can get the original.
+	head := make([]byte, imageHeadSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	head = head[:n]
 
-	dst, err := os.Create(path + filename)
+	if err := is.checkHead(i, head); err != nil {
+		return err
+	}
+	if err := setStorageFilename(i); err != nil {
+		return err
+	}
+	if i.ContentType == "image/jpeg" {
+		i.Exif, _ = parseExif(head)
+	}
+
+	// The quota is checked before anything is written and the upload
+	// is cut off as soon as it goes over the quota or the size limit.
+	// Other uploads of the user may be written at the same time, so
+	// it is checked again when the image is created.
+	user, err := is.user.ByID(i.UserID)
+	if err != nil {
+		return err
+	}
+	usage, err := is.Usage(user)
 	if err != nil {
 		return err
 	}
-	defer dst.Close()
+	if usage.Remaining() <= 0 {
+		return ErrQuotaExceeded
+	}
+	body := &maxBytesReader{
+		r:   io.MultiReader(bytes.NewReader(head), r),
+		n:   is.cfg.MaxBytes,
+		err: ErrImageTooLarge,
+	}
+	if usage.Remaining() < body.n {
+		body.n = usage.Remaining()
+		body.err = ErrQuotaExceeded
+	}
+	i.Status = ImageProcessing
+	i.Position, err = is.NextPosition(i.GalleryID)
+	if err != nil {
+		return err
+	}
+	var original io.Reader = body
+	if gallery.StripGPS {
+		original, err = is.scrubOriginal(i, body)
+		if err != nil {
+			return err
+		}
+	}
+	err = is.writeOriginal(i, original)
+	if err != nil {
+		is.store.Delete(i.Key())
+		return err
+	}
+	if err := is.ImageDB.CreateWithinQuota(i, is.cfg.QuotaBytes); err != nil {
+		is.store.Delete(i.Key())
+		return err
+	}
+	if _, err := is.jobs.Enqueue(JobProcessImage, imageJob{ImageID: i.ID}); err != nil {
+		// The upload failed, so the image doesn't go to the trash.
+		is.ImageDB.Purge(i.ID)
+		is.store.Delete(i.Key())
+		return err
+	}
+	return nil
+}
+
+// scrubOriginal reads the whole upload, as its metadata can be
+// anywhere in the file, and scrubs it. The EXIF is read again from
+// the scrubbed file, so the location isn't saved either. Uploads
+// whose metadata can't be found are rejected.
+func (is *imageService) scrubOriginal(i *Image, r io.Reader) (io.Reader, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	b, _, err = scrubMetadata(i.ContentType, b)
+	if err != nil {
+		return nil, err
+	}
+	if i.ContentType == "image/jpeg" {
+		i.Exif, _ = parseExif(b)
+	}
+	return bytes.NewReader(b), nil
+}
 
-	_, err = io.Copy(dst, r)
+// writeOriginal puts the content of the image to the store,
+// computing its size and checksum on the way.
+func (is *imageService) writeOriginal(i *Image, r io.Reader) error {
+	h := sha256.New()
+	c := &countingWriter{}
+	err := is.store.Put(i.Key(), io.TeeReader(r, io.MultiWriter(h, c)))
 	if err != nil {
 		return err
 	}
+	i.Size = c.n
+	i.Checksum = hex.EncodeToString(h.Sum(nil))
 	return nil
 }
 
-func (is *imageService) ByGalleryID(galleryID uint) ([]Image, error) {
-	path := is.imagePath(galleryID)
-	imgPathes, err := filepath.Glob(path + "*")
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
+
+func (is *imageService) Cover(gallery *Gallery) (*Image, error) {
+	if gallery.CoverImageID != nil {
+		i, err := is.ByID(*gallery.CoverImageID)
+		if err == nil && i.GalleryID == gallery.ID {
+			return i, nil
+		}
+		if err != nil && err != ErrNotFound {
+			return nil, err
+		}
+	}
+	images, err := is.ByGalleryID(gallery.ID)
+	if err != nil || len(images) == 0 {
+		return nil, err
+	}
+	return &images[0], nil
+}
+
+func (is *imageService) Reorder(galleryID uint, positions map[uint]int) error {
+	images, err := is.ByGalleryID(galleryID)
 	if err != nil {
+		return err
+	}
+	for id := range positions {
+		if !containsImage(images, id) {
+			return ErrNotFound
+		}
+	}
+
+	// An image moved to a taken position goes before the image
+	// there when it was moved up, and after it when moved down.
+	type entry struct {
+		image     *Image
+		position  int
+		direction int
+	}
+	entries := make([]entry, len(images))
+	for n := range images {
+		i := &images[n]
+		pos, ok := positions[i.ID]
+		if !ok {
+			pos = i.Position
+		}
+		e := entry{image: i, position: pos}
+		switch {
+		case pos < i.Position:
+			e.direction = -1
+		case pos > i.Position:
+			e.direction = 1
+		}
+		entries[n] = e
+	}
+	sort.SliceStable(entries, func(a, b int) bool {
+		if entries[a].position != entries[b].position {
+			return entries[a].position < entries[b].position
+		}
+		return entries[a].direction < entries[b].direction
+	})
+	for n, e := range entries {
+		if e.image.Position == n+1 {
+			continue
+		}
+		e.image.Position = n + 1
+		if err := is.ImageDB.Update(e.image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsImage(images []Image, id uint) bool {
+	for _, i := range images {
+		if i.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (is *imageService) ScrubGallery(galleryID uint) error {
+	_, err := is.jobs.Enqueue(JobScrubGallery, galleryJob{GalleryID: galleryID})
+	return err
+}
+
+func (is *imageService) Delete(i *Image) error {
+	if err := runImageValidations(i, checkFilename); err != nil {
+		return err
+	}
+	if err := is.moveBlobs(i, true); err != nil {
+		return err
+	}
+	return is.ImageDB.Delete(i.ID)
+}
+
+func (is *imageService) ByKey(key string) (*Image, string, error) {
+	rest := strings.TrimPrefix(key, galleriesKeyPrefix)
+	if rest == key || !validKey(key) {
+		return nil, "", ErrNotFound
+	}
+	parts := strings.Split(rest, "/")
+	galleryID, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, "", ErrNotFound
+	}
+	switch {
+	case len(parts) == 2:
+		i, err := is.ByFilename(uint(galleryID), parts[1])
+		return i, "", err
+	case len(parts) == 4 && parts[1] == "renditions":
+		// The rendition of an image in another format has
+		// the extension of that format added to the filename.
+		name := parts[2]
+		filename := parts[3]
+		i, err := is.ByFilename(uint(galleryID), filename)
+		if err == ErrNotFound {
+			i, err = is.ByFilename(uint(galleryID), strings.TrimSuffix(filename, path.Ext(filename)))
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if !i.HasRendition(name) || i.renditionKey(name) != key {
+			return nil, "", ErrNotFound
+		}
+		return i, name, nil
+	}
+	return nil, "", ErrNotFound
+}
+
+func (is *imageService) Open(i *Image) (io.ReadCloser, error) {
+	if err := runImageValidations(i, checkFilename); err != nil {
 		return nil, err
 	}
-	images := make([]Image, len(imgPathes))
-	for i := range imgPathes {
-		imgPathes[i] = strings.Replace(imgPathes[i], path, "", 1)
-		images[i] = Image{
-			Filename:  imgPathes[i],
-			GalleryID: galleryID,
+	return is.store.Get(i.Key())
+}
+
+func (is *imageService) readOriginal(i *Image) ([]byte, error) {
+	r, err := is.store.Get(i.Key())
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+type imageValidationFunc func(*Image) error
+
+func runImageValidations(image *Image, fns ...imageValidationFunc) error {
+	for _, fn := range fns {
+		if err := fn(image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func requireImageGalleryID(i *Image) error {
+	if i.GalleryID <= 0 {
+		return ErrGalleryIDRequired
+	}
+	return nil
+}
+
+func requireImageUserID(i *Image) error {
+	if i.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func requireOriginalFilename(i *Image) error {
+	if i.OriginalFilename == "" {
+		return ErrFilenameRequired
+	}
+	return nil
+}
+
+// normalizeOriginalFilename drops any directories the client sent
+// along with the name of the file, as well as control characters.
+func normalizeOriginalFilename(i *Image) error {
+	name := strings.ReplaceAll(i.OriginalFilename, "\\", "/")
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
 		}
+		return r
+	}, path.Base(name))
+	name = strings.TrimSpace(name)
+	if name == "." || name == "/" || name == ".." {
+		name = ""
+	}
+	if runes := []rune(name); len(runes) > maxOriginalFilenameLen {
+		name = string(runes[len(runes)-maxOriginalFilenameLen:])
+	}
+	i.OriginalFilename = name
+	return nil
+}
+
+// setStorageFilename names the image with a random name and the
+// extension of its content type, so no upload can choose where
+// it is stored or overwrite another one.
+func setStorageFilename(i *Image) error {
+	b, err := rand.GenBytes(storageFilenameBytes)
+	if err != nil {
+		return err
+	}
+	i.Filename = hex.EncodeToString(b) + imageExtensions[i.ContentType]
+	return nil
+}
+
+// checkFilename makes sure the filename can only point to
+// a blob right in the directory of the gallery, with a name
+// short enough for any filesystem.
+func checkFilename(i *Image) error {
+	name := i.Filename
+	if name == "" || name == "." || name == ".." || len(name) > maxFilenameBytes ||
+		strings.ContainsAny(name, "/\\\x00") {
+		return ErrInvalidFilename
+	}
+	return nil
+}
+
+// normalizeImageText trims the text fields written by the owner.
+func normalizeImageText(i *Image) error {
+	i.Title = strings.TrimSpace(i.Title)
+	i.Caption = strings.TrimSpace(i.Caption)
+	i.AltText = strings.TrimSpace(i.AltText)
+	return nil
+}
+
+func imageTextMaxLength(i *Image) error {
+	switch {
+	case utf8.RuneCountInString(i.Title) > maxImageTitleLen:
+		return ErrImageTitleTooLong
+	case utf8.RuneCountInString(i.AltText) > maxImageAltTextLen:
+		return ErrImageAltTextTooLong
+	case utf8.RuneCountInString(i.Caption) > maxImageCaptionLen:
+		return ErrImageCaptionTooLong
+	}
+	return nil
+}
+
+var _ ImageDB = &imageValidator{}
+
+type imageValidator struct {
+	ImageDB
+}
+
+func (iv *imageValidator) ByFilename(galleryID uint, filename string) (*Image, error) {
+	image := Image{
+		GalleryID: galleryID,
+		Filename:  filename,
+	}
+	if err := runImageValidations(&image, checkFilename); err != nil {
+		return nil, err
+	}
+	return iv.ImageDB.ByFilename(galleryID, filename)
+}
+
+func (iv *imageValidator) Update(image *Image) error {
+	err := runImageValidations(image,
+		normalizeImageText,
+		imageTextMaxLength)
+	if err != nil {
+		return err
+	}
+	return iv.ImageDB.Update(image)
+}
+
+func (iv *imageValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrInvalidId
+	}
+	return iv.ImageDB.Delete(id)
+}
+
+func (iv *imageValidator) Restore(id uint) error {
+	if id <= 0 {
+		return ErrInvalidId
+	}
+	return iv.ImageDB.Restore(id)
+}
+
+func (iv *imageValidator) Purge(id uint) error {
+	if id <= 0 {
+		return ErrInvalidId
+	}
+	return iv.ImageDB.Purge(id)
+}
+
+var _ ImageDB = &imageGorm{}
+
+type imageGorm struct {
+	db *gorm.DB
+}
+
+func (ig *imageGorm) ByID(id uint) (*Image, error) {
+	var image Image
+	db := ig.db.Where("id = ?", id)
+	err := first(db, &image)
+	return &image, err
+}
+
+func (ig *imageGorm) ByFilename(galleryID uint, filename string) (*Image, error) {
+	var image Image
+	db := ig.db.Where("gallery_id = ? AND filename = ?", galleryID, filename)
+	err := first(db, &image)
+	return &image, err
+}
+
+func (ig *imageGorm) ByGalleryID(galleryID uint) ([]Image, error) {
+	var images []Image
+	err := ig.db.Where("gallery_id = ?", galleryID).Order("position, created_at, id").Find(&images).Error
+	if err != nil {
+		return nil, err
 	}
 	return images, nil
 }
 
-func (is *imageService) mkImagePath(galleryID uint) (string, error) {
-	galleryPath := is.imagePath(galleryID)
-	err := os.MkdirAll(galleryPath, 0755)
+func (ig *imageGorm) GalleryIDs() ([]uint, error) {
+	var ids []uint
+	err := ig.db.Unscoped().Model(&Image{}).Order("gallery_id").Pluck("DISTINCT gallery_id", &ids).Error
+	return ids, err
+}
+
+func (ig *imageGorm) TrashedByID(id uint) (*Image, error) {
+	var image Image
+	db := ig.db.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id)
+	err := first(db, &image)
+	return &image, err
+}
+
+func (ig *imageGorm) TrashedForUserID(userID uint) ([]Image, error) {
+	var images []Image
+	err := ig.db.Unscoped().
+		Joins("JOIN galleries ON galleries.id = images.gallery_id AND galleries.deleted_at IS NULL").
+		Joins("LEFT JOIN memberships ON memberships.gallery_id = galleries.id AND memberships.user_id = ? "+
+			"AND memberships.role = ? AND memberships.accepted_at IS NOT NULL AND memberships.deleted_at IS NULL",
+			userID, RoleEditor).
+		Where("images.deleted_at IS NOT NULL").
+		Where("galleries.user_id = ? OR (images.user_id = ? AND memberships.id IS NOT NULL)", userID, userID).
+		Order("images.deleted_at DESC").
+		Find(&images).Error
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return galleryPath, nil
+	return images, nil
 }
 
-func (is *imageService) imagePath(galleryID uint) string {
-	return fmt.Sprintf("images/galleries/%v/", galleryID)
+func (ig *imageGorm) TrashedBefore(t time.Time) ([]Image, error) {
+	var images []Image
+	err := ig.db.Unscoped().Where("deleted_at < ?", t).Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
 }
 
-func (is *imageService) Delete(i *Image) error {
-	return os.Remove(i.RelativePath())
+func (ig *imageGorm) AllByGalleryID(galleryID uint) ([]Image, error) {
+	var images []Image
+	err := ig.db.Unscoped().Where("gallery_id = ?", galleryID).Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (ig *imageGorm) NextPosition(galleryID uint) (int, error) {
+	var next struct {
+		Position int
+	}
+	err := ig.db.Model(&Image{}).
+		Select("COALESCE(MAX(position), 0) + 1 AS position").
+		Where("gallery_id = ?", galleryID).
+		Scan(&next).Error
+	return next.Position, err
+}
+
+func (ig *imageGorm) CountByGalleryIDs(galleryIDs []uint) (map[uint]int, error) {
+	counts := make(map[uint]int)
+	if len(galleryIDs) == 0 {
+		return counts, nil
+	}
+	var rows []struct {
+		GalleryID uint
+		Count     int
+	}
+	err := ig.db.Model(&Image{}).
+		Select("gallery_id, COUNT(*) AS count").
+		Where("gallery_id IN (?)", galleryIDs).
+		Group("gallery_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.GalleryID] = row.Count
+	}
+	return counts, nil
+}
+
+func (ig *imageGorm) Create(image *Image) error {
+	return ig.db.Create(image).Error
+}
+
+// CreateWithinQuota locks the row of the user until the image is
+// created, so of the uploads of a user which finish at once, each one
+// is checked against the quota with the ones before it counted.
+func (ig *imageGorm) CreateWithinQuota(image *Image, defaultQuota int64) error {
+	return ig.db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		err := first(tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", image.UserID), &user)
+		if err != nil {
+			return err
+		}
+		usage, err := usageByUserID(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		quota := defaultQuota
+		if user.QuotaBytes != nil {
+			quota = *user.QuotaBytes
+		}
+		if usage.Bytes+image.Size > quota {
+			return ErrQuotaExceeded
+		}
+		return tx.Create(image).Error
+	})
+}
+
+func (ig *imageGorm) Update(image *Image) error {
+	return ig.db.Save(image).Error
+}
+
+func (ig *imageGorm) Delete(id uint) error {
+	image := Image{Model: gorm.Model{ID: id}}
+	return ig.db.Delete(&image).Error
+}
+
+func (ig *imageGorm) Restore(id uint) error {
+	return ig.db.Unscoped().Model(&Image{}).
+		Where("id = ?", id).
+		Update("deleted_at", gorm.Expr("NULL")).Error
+}
+
+// Purge deletes the tags of the image along with it.
+func (ig *imageGorm) Purge(id uint) error {
+	if err := ig.db.Where("image_id = ?", id).Delete(&ImageTag{}).Error; err != nil {
+		return err
+	}
+	image := Image{Model: gorm.Model{ID: id}}
+	return ig.db.Unscoped().Delete(&image).Error
 }
@@ -0,0 +1,249 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestCheckFilename(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     error
+	}{
+		{"0123456789abcdef.jpg", nil},
+		{"photo .jpg", nil},
+		{"..jpg", nil},
+		{strings.Repeat("a", maxFilenameBytes), nil},
+		{"", ErrInvalidFilename},
+		{".", ErrInvalidFilename},
+		{"..", ErrInvalidFilename},
+		{"../photo.jpg", ErrInvalidFilename},
+		{"../../etc/passwd", ErrInvalidFilename},
+		{"/etc/passwd", ErrInvalidFilename},
+		{"2/photo.jpg", ErrInvalidFilename},
+		{`..\photo.jpg`, ErrInvalidFilename},
+		{`C:\photo.jpg`, ErrInvalidFilename},
+		{"photo.jpg\x00.png", ErrInvalidFilename},
+		{strings.Repeat("a", maxFilenameBytes+1), ErrInvalidFilename},
+		{strings.Repeat("é", maxFilenameBytes/2+1), ErrInvalidFilename},
+	}
+	for _, tt := range tests {
+		if err := checkFilename(&Image{Filename: tt.filename}); err != tt.want {
+			t.Errorf("checkFilename(%q) = %v, want %v", tt.filename, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizeOriginalFilename(t *testing.T) {
+	long := strings.Repeat("a", maxOriginalFilenameLen)
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"photo.jpg", "photo.jpg"},
+		{"  photo.jpg ", "photo.jpg"},
+		{"../../photo.jpg", "photo.jpg"},
+		{"/home/me/photo.jpg", "photo.jpg"},
+		{`C:\Users\me\photo.jpg`, "photo.jpg"},
+		{`..\..\photo.jpg`, "photo.jpg"},
+		{"photo\x00.jpg", "photo.jpg"},
+		{"pho\nto\r\t.jpg", "photo.jpg"},
+		{"dir/\x00", ""},
+		{"..", ""},
+		{"../", ""},
+		{"/", ""},
+		{`\`, ""},
+		{".", ""},
+		{"", ""},
+		// The end of a long name is kept, so is its extension.
+		{"x" + long + ".jpg", long[4:] + ".jpg"},
+		{strings.Repeat("é", maxOriginalFilenameLen+1), strings.Repeat("é", maxOriginalFilenameLen)},
+	}
+	for _, tt := range tests {
+		i := &Image{OriginalFilename: tt.filename}
+		if err := normalizeOriginalFilename(i); err != nil {
+			t.Fatal(err)
+		}
+		if i.OriginalFilename != tt.want {
+			t.Errorf("normalizeOriginalFilename(%q) = %q, want %q", tt.filename, i.OriginalFilename, tt.want)
+		}
+	}
+}
+
+// filenameImages finds the image of gallery 1 with the filename.
+type filenameImages struct {
+	ImageDB
+	image *Image
+}
+
+func (fi filenameImages) ByFilename(galleryID uint, filename string) (*Image, error) {
+	if galleryID != fi.image.GalleryID || filename != fi.image.Filename {
+		return nil, ErrNotFound
+	}
+	image := *fi.image
+	return &image, nil
+}
+
+func TestByKey(t *testing.T) {
+	is := &imageService{
+		ImageDB: &imageValidator{filenameImages{image: &Image{
+			GalleryID:   1,
+			Filename:    "photo.webp",
+			ContentType: "image/webp",
+			Renditions:  []string{"thumb"},
+		}}},
+	}
+	tests := []struct {
+		key       string
+		rendition string
+		want      error
+	}{
+		{"galleries/1/photo.webp", "", nil},
+		{"galleries/1/renditions/thumb/photo.webp.jpg", "thumb", nil},
+		{"galleries/1/renditions/thumb/photo.webp", "", ErrNotFound},
+		{"galleries/1/renditions/large/photo.webp.jpg", "", ErrNotFound},
+		{"galleries/2/photo.webp", "", ErrNotFound},
+		{"galleries/x/photo.webp", "", ErrNotFound},
+		{"galleries/1", "", ErrNotFound},
+		{"galleries/1/", "", ErrNotFound},
+		{"galleries/1/a/photo.webp", "", ErrNotFound},
+		{"photo.webp", "", ErrNotFound},
+		{"trash/galleries/1/photo.webp", "", ErrNotFound},
+		{"/galleries/1/photo.webp", "", ErrNotFound},
+		{"galleries/1/../1/photo.webp", "", ErrNotFound},
+		{"galleries/../galleries/1/photo.webp", "", ErrNotFound},
+		{"galleries/1/./photo.webp", "", ErrNotFound},
+		{"galleries//1/photo.webp", "", ErrNotFound},
+		{`galleries/1\photo.webp`, "", ErrNotFound},
+		{"galleries/1/..", "", ErrNotFound},
+		{"galleries/1/photo.webp\x00", "", ErrInvalidFilename},
+		{"galleries/1/" + strings.Repeat("a", maxFilenameBytes+1), "", ErrInvalidFilename},
+	}
+	for _, tt := range tests {
+		i, rendition, err := is.ByKey(tt.key)
+		if err != tt.want {
+			t.Errorf("ByKey(%q) = %v, want %v", tt.key, err, tt.want)
+			continue
+		}
+		if err == nil && (i.Filename != "photo.webp" || rendition != tt.rendition) {
+			t.Errorf("ByKey(%q) = %s, %q, want photo.webp, %q", tt.key, i.Filename, rendition, tt.rendition)
+		}
+	}
+}
+
+// galleryImages keeps the images of every gallery,
+// ByGalleryID lists them by position like imageGorm.
+type galleryImages struct {
+	ImageDB
+	images  []Image
+	updates int
+}
+
+func (gi *galleryImages) ByGalleryID(galleryID uint) ([]Image, error) {
+	var images []Image
+	for _, i := range gi.images {
+		if i.GalleryID == galleryID {
+			images = append(images, i)
+		}
+	}
+	sort.SliceStable(images, func(a, b int) bool { return images[a].Position < images[b].Position })
+	return images, nil
+}
+
+func (gi *galleryImages) Update(image *Image) error {
+	for n := range gi.images {
+		if gi.images[n].ID == image.ID {
+			gi.images[n] = *image
+		}
+	}
+	gi.updates++
+	return nil
+}
+
+func TestReorder(t *testing.T) {
+	tests := []struct {
+		name      string
+		positions map[uint]int
+		want      []uint
+		updates   int
+	}{
+		{"nothing moved", map[uint]int{}, []uint{1, 2, 3, 4}, 0},
+		{"same positions", map[uint]int{1: 1, 3: 3}, []uint{1, 2, 3, 4}, 0},
+		{"moved up", map[uint]int{4: 1}, []uint{4, 1, 2, 3}, 4},
+		{"moved down", map[uint]int{1: 3}, []uint{2, 3, 1, 4}, 3},
+		{"swapped", map[uint]int{1: 2, 2: 1}, []uint{2, 1, 3, 4}, 2},
+		{"past the end", map[uint]int{2: 100}, []uint{1, 3, 4, 2}, 3},
+		{"before the start", map[uint]int{3: -5}, []uint{3, 1, 2, 4}, 3},
+		{"reversed", map[uint]int{1: 4, 2: 3, 3: 2, 4: 1}, []uint{4, 3, 2, 1}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &galleryImages{images: []Image{
+				{Model: gorm.Model{ID: 1}, GalleryID: 1, Position: 1},
+				{Model: gorm.Model{ID: 2}, GalleryID: 1, Position: 2},
+				{Model: gorm.Model{ID: 3}, GalleryID: 1, Position: 3},
+				{Model: gorm.Model{ID: 4}, GalleryID: 1, Position: 4},
+				{Model: gorm.Model{ID: 5}, GalleryID: 2, Position: 1},
+			}}
+			is := &imageService{ImageDB: db}
+			if err := is.Reorder(1, tt.positions); err != nil {
+				t.Fatal(err)
+			}
+			images, _ := db.ByGalleryID(1)
+			for n, i := range images {
+				if i.ID != tt.want[n] || i.Position != n+1 {
+					t.Errorf("image %d is at %d, want image %d", i.ID, i.Position, tt.want[n])
+				}
+			}
+			if db.updates != tt.updates {
+				t.Errorf("%d images saved, want %d", db.updates, tt.updates)
+			}
+			if other := db.images[4]; other.Position != 1 {
+				t.Errorf("image of the other gallery moved to %d", other.Position)
+			}
+		})
+	}
+}
+
+// Positions left with gaps by deleted images are renumbered.
+func TestReorderRenumbers(t *testing.T) {
+	db := &galleryImages{images: []Image{
+		{Model: gorm.Model{ID: 1}, GalleryID: 1, Position: 2},
+		{Model: gorm.Model{ID: 2}, GalleryID: 1, Position: 5},
+		{Model: gorm.Model{ID: 3}, GalleryID: 1, Position: 9},
+	}}
+	is := &imageService{ImageDB: db}
+	if err := is.Reorder(1, map[uint]int{3: 1}); err != nil {
+		t.Fatal(err)
+	}
+	images, _ := db.ByGalleryID(1)
+	for n, want := range []uint{3, 1, 2} {
+		if images[n].ID != want || images[n].Position != n+1 {
+			t.Errorf("image %d is at %d, want image %d at %d", images[n].ID, images[n].Position, want, n+1)
+		}
+	}
+}
+
+func TestReorderOtherGallery(t *testing.T) {
+	db := &galleryImages{images: []Image{
+		{Model: gorm.Model{ID: 1}, GalleryID: 1, Position: 1},
+		{Model: gorm.Model{ID: 2}, GalleryID: 1, Position: 2},
+		{Model: gorm.Model{ID: 3}, GalleryID: 2, Position: 1},
+	}}
+	is := &imageService{ImageDB: db}
+	for _, positions := range []map[uint]int{
+		{3: 1},
+		{2: 1, 3: 2},
+		{404: 1},
+	} {
+		if err := is.Reorder(1, positions); err != ErrNotFound {
+			t.Errorf("Reorder(%v) = %v, want %v", positions, err, ErrNotFound)
+		}
+	}
+	if db.updates != 0 {
+		t.Errorf("%d images saved after a rejected reorder", db.updates)
+	}
+}
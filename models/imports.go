@@ -0,0 +1,114 @@
+package models
+
+import (
+	"archive/zip"
+	"io"
+	"path"
+	"strings"
+)
+
+const (
+	// DefaultMaxArchiveEntries limits the number of files in an imported archive.
+	DefaultMaxArchiveEntries = 1000
+	// DefaultMaxArchiveBytes limits the size of an imported archive.
+	DefaultMaxArchiveBytes = 200 << 20 // 200 Megabytes
+)
+
+// ImportResult is the outcome of importing a single entry of an
+// archive. Image is set when it was imported, Err when it was not.
+type ImportResult struct {
+	Name  string
+	Image *Image
+	Err   error
+}
+
+func (r ImportResult) Imported() bool {
+	return r.Err == nil
+}
+
+// Reason returns why the entry was not imported, in words
+// which can be shown to the user.
+func (r ImportResult) Reason() string {
+	if pErr, ok := r.Err.(modelError); ok {
+		return pErr.Public()
+	}
+	return "Something went wrong"
+}
+
+// Import never writes an entry anywhere by its name: folders are dropped
+// from it and it is only kept as the original filename, so zip-slip
+// paths such as "../x" are harmless.
+func (is *imageService) Import(image Image, r io.ReaderAt, size int64) ([]ImportResult, error) {
+	if size > is.cfg.MaxArchiveBytes {
+		return nil, ErrArchiveTooLarge
+	}
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, ErrArchiveCorrupt
+	}
+	var files []*zip.File
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || skipArchiveEntry(f.Name) {
+			continue
+		}
+		files = append(files, f)
+	}
+	if len(files) > is.cfg.MaxArchiveEntries {
+		return nil, ErrArchiveTooManyFiles
+	}
+
+	results := make([]ImportResult, 0, len(files))
+	for _, f := range files {
+		res := ImportResult{Name: f.Name}
+		i := image
+		i.OriginalFilename = f.Name
+		res.Err = is.importEntry(&i, f)
+		if _, ok := res.Err.(modelError); res.Err != nil && !ok {
+			return results, res.Err
+		}
+		if res.Err == nil {
+			res.Image = &i
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func (is *imageService) importEntry(i *Image, f *zip.File) error {
+	// The declared size may be a lie, Create enforces the limit
+	// on what is actually read either way.
+	if f.UncompressedSize64 > uint64(is.cfg.MaxBytes) {
+		return ErrImageTooLarge
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return ErrArchiveCorrupt
+	}
+	return is.Create(i, &archiveEntryReader{rc})
+}
+
+// skipArchiveEntry reports whether the entry is an artifact
+// of the system the archive was made on, rather than a photo.
+func skipArchiveEntry(name string) bool {
+	name = strings.ReplaceAll(name, "\\", "/")
+	for _, part := range strings.Split(name, "/") {
+		if part == "__MACOSX" {
+			return true
+		}
+	}
+	return strings.HasPrefix(path.Base(name), ".")
+}
+
+// archiveEntryReader reports a damaged entry as a public error,
+// so it does not abort the whole import.
+type archiveEntryReader struct {
+	io.ReadCloser
+}
+
+func (r *archiveEntryReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == zip.ErrChecksum || err == zip.ErrFormat || err == zip.ErrAlgorithm {
+		err = ErrArchiveCorrupt
+	}
+	return n, err
+}
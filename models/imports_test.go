@@ -0,0 +1,146 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+type archiveEntry struct {
+	name    string
+	content []byte
+}
+
+// testArchive zips the entries without compressing them,
+// so their content can be found in the archive.
+func testArchive(t *testing.T, entries ...archiveEntry) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(e.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func importService(t *testing.T) (*imageService, *uploadImages, BlobStore) {
+	images := &uploadImages{}
+	store := NewDiskStore(t.TempDir())
+	return &imageService{
+		ImageDB: images,
+		gallery: uploadGalleries{},
+		user:    uploadUsers{},
+		store:   store,
+		jobs:    uploadJobs{},
+		cfg: ImageConfig{
+			MaxBytes:          1 << 20,
+			MaxDimension:      12000,
+			MaxPixels:         50000000,
+			QuotaBytes:        DefaultQuotaBytes,
+			MaxArchiveEntries: 3,
+			MaxArchiveBytes:   1 << 20,
+		},
+	}, images, store
+}
+
+func TestImport(t *testing.T) {
+	var photo bytes.Buffer
+	if err := png.Encode(&photo, image.NewGray(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+	damaged := append([]byte{}, photo.Bytes()...)
+	damaged[len(damaged)-1] ^= 0xFF
+
+	b := testArchive(t,
+		archiveEntry{"../../../etc/cron.d/photo.png", photo.Bytes()},
+		archiveEntry{"__MACOSX/._photo.png", photo.Bytes()},
+		archiveEntry{"trip/__MACOSX/photo.png", photo.Bytes()},
+		archiveEntry{".DS_Store", []byte("finder")},
+		archiveEntry{"trip/.hidden.png", photo.Bytes()},
+		archiveEntry{"trip/", nil},
+		archiveEntry{`C:\Users\me\notes.txt`, []byte("not a photo")},
+		archiveEntry{"damaged.png", damaged},
+	)
+	// The content of the entry is changed after its checksum was
+	// written, which is only found once it has been read.
+	end := bytes.LastIndex(b, damaged)
+	b[end+len(damaged)-1] ^= 0xFF
+
+	is, images, store := importService(t)
+	results, err := is.Import(Image{GalleryID: 1, UserID: 1}, bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("Import = %v", err)
+	}
+	want := []struct {
+		name     string
+		original string
+		err      error
+	}{
+		{"../../../etc/cron.d/photo.png", "photo.png", nil},
+		{`C:\Users\me\notes.txt`, "", ErrImageType},
+		{"damaged.png", "", ErrArchiveCorrupt},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("Import = %d results, want %d: %+v", len(results), len(want), results)
+	}
+	for n, w := range want {
+		res := results[n]
+		if res.Name != w.name || res.Err != w.err {
+			t.Errorf("result %d = %q, %v, want %q, %v", n, res.Name, res.Err, w.name, w.err)
+		}
+		if w.err == nil && (res.Image == nil || res.Image.OriginalFilename != w.original) {
+			t.Errorf("result %d imported as %+v, want %q", n, res.Image, w.original)
+		}
+	}
+
+	keys, err := store.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || len(images.created) != 1 || keys[0] != images.created[0].Key() {
+		t.Fatalf("store has %v for %d images, want the one imported", keys, len(images.created))
+	}
+	if !strings.HasPrefix(keys[0], "galleries/1/") || strings.Count(keys[0], "/") != 2 {
+		t.Errorf("imported image stored at %q, want it in the gallery", keys[0])
+	}
+}
+
+func TestImportLimits(t *testing.T) {
+	photo := archiveEntry{"photo.png", []byte("not even a photo")}
+	tests := []struct {
+		name string
+		b    []byte
+		want error
+	}{
+		{"not an archive", []byte("PK but not a zip"), ErrArchiveCorrupt},
+		{"too many files", testArchive(t, photo, photo, photo, photo), ErrArchiveTooManyFiles},
+		{"too large", append(testArchive(t, photo), make([]byte, 1<<20)...), ErrArchiveTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is, images, _ := importService(t)
+			results, err := is.Import(Image{GalleryID: 1, UserID: 1}, bytes.NewReader(tt.b), int64(len(tt.b)))
+			if err != tt.want || len(results) != 0 || len(images.created) != 0 {
+				t.Errorf("Import = %d results, %v, want %v", len(results), err, tt.want)
+			}
+		})
+	}
+
+	// Skipped files don't count towards the limit.
+	is, _, _ := importService(t)
+	b := testArchive(t, photo, photo, photo, archiveEntry{"__MACOSX/._photo.png", nil}, archiveEntry{".DS_Store", nil})
+	if results, err := is.Import(Image{GalleryID: 1, UserID: 1}, bytes.NewReader(b), int64(len(b))); err != nil || len(results) != 3 {
+		t.Errorf("Import = %d results, %v, want 3 results", len(results), err)
+	}
+}
@@ -0,0 +1,345 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+
+	// DefaultJobAttempts is how many times a job is tried before it is failed.
+	DefaultJobAttempts = 5
+	DefaultJobWorkers  = 4
+
+	jobPollInterval = 2 * time.Second
+	// jobLockTimeout is how long a job may run before it is considered
+	// abandoned, e.g. because its worker was killed by a restart.
+	jobLockTimeout = 15 * time.Minute
+	jobBackoffBase = 10 * time.Second
+	jobBackoffMax  = time.Hour
+)
+
+// Job is a unit of background work kept in the jobs table, so it survives
+// restarts. Kind selects the JobHandler which runs it, Payload is the JSON
+// of its arguments. A failed attempt is retried with an exponential backoff
+// until MaxAttempts is reached.
+type Job struct {
+	gorm.Model
+	Kind        string    `gorm:"not null;index"`
+	Payload     string    `gorm:"type:text"`
+	Status      string    `gorm:"not null;index"`
+	Attempts    int       `gorm:"not null"`
+	MaxAttempts int       `gorm:"not null"`
+	RunAt       time.Time `gorm:"not null;index"`
+	LockedAt    *time.Time
+	LastError   string `gorm:"type:text"`
+}
+
+// Decode unmarshals the payload of the job into v.
+func (j *Job) Decode(v interface{}) error {
+	return json.Unmarshal([]byte(j.Payload), v)
+}
+
+// LastAttempt reports whether the job won't be retried if it fails now.
+func (j *Job) LastAttempt() bool {
+	return j.Attempts >= j.MaxAttempts
+}
+
+// JobHandler runs a job of a single kind. Returning an error
+// schedules a retry, unless it was the last attempt.
+type JobHandler func(job *Job) error
+
+// JobService is used to enqueue background jobs and to run them
+// on a bounded pool of workers.
+type JobService interface {
+	JobDB
+	// Enqueue saves a job of the given kind, which is run
+	// as soon as a worker is free.
+	Enqueue(kind string, payload interface{}) (*Job, error)
+	// Handle registers the handler of a kind of job. It
+	// must be called before the workers are started.
+	Handle(kind string, handler JobHandler)
+	// HandleFailure registers what is done once a job of the kind
+	// failed for good, after its last attempt, be it by an error or
+	// a panic. It must be called before the workers are started.
+	HandleFailure(kind string, handler JobHandler)
+	// Schedule makes a job of the kind run every interval, with
+	// an empty payload. A single job row is kept for the kind, which
+	// is run again once it is done. It must be called before the
+	// workers are started.
+	Schedule(kind string, every time.Duration)
+	// Start runs the given number of workers in the background,
+	// or DefaultJobWorkers of them when it is not positive.
+	Start(workers int)
+	// Stop waits for the workers to finish their current jobs.
+	Stop()
+}
+
+// JobDB is used to interact with the jobs table in database.
+type JobDB interface {
+	ByID(id uint) (*Job, error)
+	// Claim locks the next job which is due, or the one abandoned
+	// for longer than staleAfter, and marks it as running.
+	// It returns ErrNotFound when there is nothing to run.
+	Claim(staleAfter time.Duration) (*Job, error)
+
+	Create(job *Job) error
+	// CreateUnlessQueued creates the job unless there is a pending
+	// or running job of the same kind already.
+	CreateUnlessQueued(job *Job) error
+	Update(job *Job) error
+}
+
+var _ JobService = &jobService{}
+
+type jobService struct {
+	JobDB
+	handlers  map[string]JobHandler
+	failures  map[string]JobHandler
+	schedules map[string]time.Duration
+	wake      chan struct{}
+	quit      chan struct{}
+	wg        sync.WaitGroup
+}
+
+func NewJobService(db *gorm.DB) JobService {
+	return &jobService{
+		JobDB:     &jobGorm{db},
+		handlers:  make(map[string]JobHandler),
+		failures:  make(map[string]JobHandler),
+		schedules: make(map[string]time.Duration),
+		wake:      make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+	}
+}
+
+func (js *jobService) Enqueue(kind string, payload interface{}) (*Job, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	job := Job{
+		Kind:        kind,
+		Payload:     string(b),
+		Status:      JobPending,
+		MaxAttempts: DefaultJobAttempts,
+		RunAt:       time.Now(),
+	}
+	if err := js.Create(&job); err != nil {
+		return nil, err
+	}
+	// Wake up an idle worker of this instance instead of waiting for the
+	// next poll, the jobs enqueued by other instances are found by polling.
+	select {
+	case js.wake <- struct{}{}:
+	default:
+	}
+	return &job, nil
+}
+
+func (js *jobService) Handle(kind string, handler JobHandler) {
+	js.handlers[kind] = handler
+}
+
+func (js *jobService) HandleFailure(kind string, handler JobHandler) {
+	js.failures[kind] = handler
+}
+
+func (js *jobService) Schedule(kind string, every time.Duration) {
+	js.schedules[kind] = every
+}
+
+func (js *jobService) Start(workers int) {
+	if workers <= 0 {
+		workers = DefaultJobWorkers
+	}
+	for kind := range js.schedules {
+		job := Job{
+			Kind:        kind,
+			Payload:     "{}",
+			Status:      JobPending,
+			MaxAttempts: DefaultJobAttempts,
+			RunAt:       time.Now(),
+		}
+		if err := js.CreateUnlessQueued(&job); err != nil {
+			log.Println(err)
+		}
+	}
+	for i := 0; i < workers; i++ {
+		js.wg.Add(1)
+		go js.work()
+	}
+}
+
+func (js *jobService) Stop() {
+	close(js.quit)
+	js.wg.Wait()
+}
+
+func (js *jobService) work() {
+	defer js.wg.Done()
+	for {
+		job, err := js.Claim(jobLockTimeout)
+		switch err {
+		case nil:
+			js.run(job)
+			continue
+		case ErrNotFound:
+		default:
+			log.Println(err)
+		}
+
+		select {
+		case <-js.quit:
+			return
+		case <-js.wake:
+		case <-time.After(jobPollInterval):
+		}
+	}
+}
+
+func (js *jobService) run(job *Job) {
+	err := js.call(job)
+	job.LockedAt = nil
+	switch {
+	case err == nil:
+		job.Status = JobDone
+		job.LastError = ""
+	case job.LastAttempt():
+		job.Status = JobFailed
+		job.LastError = err.Error()
+		js.fail(job)
+	default:
+		job.Status = JobPending
+		job.LastError = err.Error()
+		job.RunAt = time.Now().Add(jobBackoff(job.Attempts))
+	}
+	if err != nil {
+		log.Printf("models: job %d (%s) attempt %d failed: %v", job.ID, job.Kind, job.Attempts, err)
+	}
+	if every, ok := js.schedules[job.Kind]; ok && job.Status != JobPending {
+		job.Status = JobPending
+		job.Attempts = 0
+		job.RunAt = time.Now().Add(every)
+	}
+	if err := js.Update(job); err != nil {
+		log.Println(err)
+	}
+}
+
+// call runs the handler of the job.
+func (js *jobService) call(job *Job) error {
+	handler, ok := js.handlers[job.Kind]
+	if !ok {
+		return fmt.Errorf("models: no handler for jobs of kind %q", job.Kind)
+	}
+	return recoverJob(handler, job)
+}
+
+// fail runs the failure handler of a job which failed for good.
+func (js *jobService) fail(job *Job) {
+	handler, ok := js.failures[job.Kind]
+	if !ok {
+		return
+	}
+	if err := recoverJob(handler, job); err != nil {
+		log.Printf("models: failure handler of job %d (%s) failed: %v", job.ID, job.Kind, err)
+	}
+}
+
+// recoverJob runs the handler, turning a panic into an error
+// so a single bad job can't take its worker down.
+func recoverJob(handler JobHandler, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("models: job panicked: %v", r)
+		}
+	}()
+	return handler(job)
+}
+
+// jobBackoff doubles the delay before every next attempt.
+func jobBackoff(attempts int) time.Duration {
+	d := jobBackoffBase
+	for i := 1; i < attempts && d < jobBackoffMax; i++ {
+		d *= 2
+	}
+	if d > jobBackoffMax {
+		d = jobBackoffMax
+	}
+	return d
+}
+
+var _ JobDB = &jobGorm{}
+
+type jobGorm struct {
+	db *gorm.DB
+}
+
+func (jg *jobGorm) ByID(id uint) (*Job, error) {
+	var job Job
+	db := jg.db.Where("id = ?", id)
+	err := first(db, &job)
+	return &job, err
+}
+
+// Claim relies on SKIP LOCKED, so concurrent workers, of this
+// instance or of any other one, never claim the same job.
+func (jg *jobGorm) Claim(staleAfter time.Duration) (*Job, error) {
+	var job Job
+	now := time.Now()
+	err := jg.db.Raw(`
+		UPDATE jobs SET status = ?, locked_at = ?, attempts = attempts + 1, updated_at = ?
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE deleted_at IS NULL AND (
+				(status = ? AND run_at <= ?) OR
+				(status = ? AND locked_at < ?))
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING *`,
+		JobRunning, now, now,
+		JobPending, now,
+		JobRunning, now.Add(-staleAfter),
+	).Scan(&job).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (jg *jobGorm) Create(job *Job) error {
+	return jg.db.Create(job).Error
+}
+
+// CreateUnlessQueued checks and inserts in a single statement, so only
+// instances started at the very same moment could both create the job.
+func (jg *jobGorm) CreateUnlessQueued(job *Job) error {
+	now := time.Now()
+	return jg.db.Exec(`
+		INSERT INTO jobs (created_at, updated_at, kind, payload, status, attempts, max_attempts, run_at, last_error)
+		SELECT ?, ?, ?, ?, ?, 0, ?, ?, ''
+		WHERE NOT EXISTS (
+			SELECT 1 FROM jobs
+			WHERE deleted_at IS NULL AND kind = ? AND status IN (?, ?))`,
+		now, now, job.Kind, job.Payload, job.Status, job.MaxAttempts, job.RunAt,
+		job.Kind, JobPending, JobRunning,
+	).Error
+}
+
+func (jg *jobGorm) Update(job *Job) error {
+	return jg.db.Save(job).Error
+}
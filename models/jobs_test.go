@@ -0,0 +1,70 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeJobDB keeps the job run() saves, anything else
+// panics on the nil interface it embeds.
+type fakeJobDB struct {
+	JobDB
+	saved *Job
+}
+
+func (db *fakeJobDB) Update(job *Job) error {
+	db.saved = job
+	return nil
+}
+
+func TestJobFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		handler  JobHandler
+		status   string
+		failed   bool
+	}{
+		{"error on the last attempt", DefaultJobAttempts, func(*Job) error { return errors.New("broken") }, JobFailed, true},
+		{"panic on the last attempt", DefaultJobAttempts, func(*Job) error { panic("broken") }, JobFailed, true},
+		{"panic before the last attempt", 1, func(*Job) error { panic("broken") }, JobPending, false},
+		{"success on the last attempt", DefaultJobAttempts, func(*Job) error { return nil }, JobDone, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeJobDB{}
+			js := NewJobService(nil).(*jobService)
+			js.JobDB = db
+			js.Handle("test", tt.handler)
+			failed := false
+			js.HandleFailure("test", func(job *Job) error {
+				failed = true
+				return nil
+			})
+
+			js.run(&Job{Kind: "test", Status: JobRunning, Attempts: tt.attempts, MaxAttempts: DefaultJobAttempts})
+			if db.saved == nil || db.saved.Status != tt.status {
+				t.Fatalf("saved job %+v, want status %q", db.saved, tt.status)
+			}
+			if failed != tt.failed {
+				t.Errorf("failure handler called = %v, want %v", failed, tt.failed)
+			}
+		})
+	}
+}
+
+func TestJobFailureWithoutHandler(t *testing.T) {
+	db := &fakeJobDB{}
+	js := NewJobService(nil).(*jobService)
+	js.JobDB = db
+	failed := false
+	js.HandleFailure("test", func(job *Job) error {
+		failed = true
+		panic("the failure handler must not take the worker down either")
+	})
+
+	js.run(&Job{Kind: "test", Status: JobRunning, Attempts: 1, MaxAttempts: 1})
+	if !failed || db.saved.Status != JobFailed {
+		t.Errorf("failure handler called = %v and status %q, want true and %q", failed, db.saved.Status, JobFailed)
+	}
+}
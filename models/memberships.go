@@ -0,0 +1,328 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Role is what a user may do in a gallery. The owner of a gallery is
+// the user it belongs to, the other roles are given by memberships.
+type Role string
+
+const (
+	// RoleNone is the role of anyone who is not a member.
+	RoleNone Role = ""
+	// RoleOwner can do anything with the gallery.
+	RoleOwner Role = "owner"
+	// RoleEditor can upload images and change or delete their own.
+	RoleEditor Role = "editor"
+	// RoleContributor can upload images.
+	RoleContributor Role = "contributor"
+	// RoleViewer can see the gallery, whatever its visibility.
+	RoleViewer Role = "viewer"
+)
+
+// MemberRoles are the roles a user can be invited with.
+var MemberRoles = []Role{RoleEditor, RoleContributor, RoleViewer}
+
+// Membership gives a user a role in a gallery of another user. It is
+// an invitation until the user accepts it, declining deletes it.
+type Membership struct {
+	gorm.Model
+	GalleryID  uint   `gorm:"not null;unique_index:idx_gallery_member"`
+	UserID     uint   `gorm:"not null;unique_index:idx_gallery_member;index"`
+	Role       Role   `gorm:"not null"`
+	Email      string `gorm:"-"`
+	AcceptedAt *time.Time
+	// User and Gallery are filled in for the pages
+	// which list memberships.
+	User    *User    `gorm:"-"`
+	Gallery *Gallery `gorm:"-"`
+}
+
+func (m *Membership) Accepted() bool {
+	return m.AcceptedAt != nil
+}
+
+type MembershipService interface {
+	MembershipDB
+	// RoleOf returns the role of the user, who may be nil, in the
+	// gallery. Invitations which were not accepted give no role.
+	RoleOf(gallery *Gallery, user *User) (Role, error)
+	// Invite makes an invitation for the user with the email
+	// address of Email, who must have signed up already.
+	Invite(membership *Membership) error
+	// Members returns the memberships of the gallery
+	// with their users, invitations included.
+	Members(galleryID uint) ([]Membership, error)
+	// Invitations returns the invitations of the user which
+	// were not accepted yet, with their galleries.
+	Invitations(userID uint) ([]Membership, error)
+}
+
+type MembershipDB interface {
+	ByID(id uint) (*Membership, error)
+	ByGalleryAndUser(galleryID, userID uint) (*Membership, error)
+	ByGalleryID(galleryID uint) ([]Membership, error)
+	// ByUserID returns the memberships of the user, either the
+	// accepted ones or the invitations.
+	ByUserID(userID uint, accepted bool) ([]Membership, error)
+	Create(membership *Membership) error
+	Accept(id uint) error
+	Delete(id uint) error
+}
+
+func NewMembershipService(db *gorm.DB) MembershipService {
+	return &membershipService{
+		MembershipDB: &membershipValidator{&membershipGorm{db}},
+		user:         &userGorm{db},
+		gallery:      &galleryGorm{db},
+	}
+}
+
+type membershipService struct {
+	MembershipDB
+	user    UserDB
+	gallery GalleryDB
+}
+
+func (ms *membershipService) RoleOf(gallery *Gallery, user *User) (Role, error) {
+	if user == nil {
+		return RoleNone, nil
+	}
+	if gallery.UserID == user.ID {
+		return RoleOwner, nil
+	}
+	m, err := ms.ByGalleryAndUser(gallery.ID, user.ID)
+	if err == ErrNotFound {
+		return RoleNone, nil
+	}
+	if err != nil {
+		return RoleNone, err
+	}
+	if !m.Accepted() {
+		return RoleNone, nil
+	}
+	return m.Role, nil
+}
+
+func (ms *membershipService) Invite(m *Membership) error {
+	email := m.Email
+	if err := runMembershipValidations(m, normalizeMembershipEmail); err != nil {
+		return err
+	}
+	user, err := ms.user.ByEmail(m.Email)
+	if err == ErrNotFound {
+		return ErrInviteeNotFound
+	}
+	if err != nil {
+		return err
+	}
+	m.Email = email
+	m.UserID = user.ID
+	gallery, err := ms.gallery.ByID(m.GalleryID)
+	if err != nil {
+		return err
+	}
+	if gallery.UserID == user.ID {
+		return ErrAlreadyMember
+	}
+	_, err = ms.ByGalleryAndUser(m.GalleryID, user.ID)
+	if err == nil {
+		return ErrAlreadyMember
+	}
+	if err != ErrNotFound {
+		return err
+	}
+	m.AcceptedAt = nil
+	return ms.Create(m)
+}
+
+func (ms *membershipService) Members(galleryID uint) ([]Membership, error) {
+	members, err := ms.ByGalleryID(galleryID)
+	if err != nil {
+		return nil, err
+	}
+	for n := range members {
+		members[n].User, err = ms.user.ByID(members[n].UserID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return members, nil
+}
+
+func (ms *membershipService) Invitations(userID uint) ([]Membership, error) {
+	invitations, err := ms.ByUserID(userID, false)
+	if err != nil {
+		return nil, err
+	}
+	// Invitations into galleries which were deleted since are left
+	// out, they show up again if the gallery is restored.
+	found := invitations[:0]
+	for _, m := range invitations {
+		m.Gallery, err = ms.gallery.ByID(m.GalleryID)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, m)
+	}
+	return found, nil
+}
+
+type membershipValidator struct {
+	MembershipDB
+}
+
+func (mv *membershipValidator) ByID(id uint) (*Membership, error) {
+	var m Membership
+	m.ID = id
+	if err := runMembershipValidations(&m, membershipIDGreaterThan(0)); err != nil {
+		return nil, err
+	}
+	return mv.MembershipDB.ByID(id)
+}
+
+func (mv *membershipValidator) Create(m *Membership) error {
+	err := runMembershipValidations(m,
+		membershipGalleryIDRequired,
+		membershipUserIDRequired,
+		membershipRoleValid)
+	if err != nil {
+		return err
+	}
+	return mv.MembershipDB.Create(m)
+}
+
+func (mv *membershipValidator) Accept(id uint) error {
+	var m Membership
+	m.ID = id
+	if err := runMembershipValidations(&m, membershipIDGreaterThan(0)); err != nil {
+		return err
+	}
+	return mv.MembershipDB.Accept(id)
+}
+
+func (mv *membershipValidator) Delete(id uint) error {
+	var m Membership
+	m.ID = id
+	if err := runMembershipValidations(&m, membershipIDGreaterThan(0)); err != nil {
+		return err
+	}
+	return mv.MembershipDB.Delete(id)
+}
+
+type membershipValidationFunc func(*Membership) error
+
+func runMembershipValidations(m *Membership, fns ...membershipValidationFunc) error {
+	for _, fn := range fns {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func membershipIDGreaterThan(n uint) membershipValidationFunc {
+	return func(m *Membership) error {
+		if m.ID <= n {
+			return ErrInvalidId
+		}
+		return nil
+	}
+}
+
+func membershipGalleryIDRequired(m *Membership) error {
+	if m.GalleryID <= 0 {
+		return ErrGalleryIDRequired
+	}
+	return nil
+}
+
+func membershipUserIDRequired(m *Membership) error {
+	if m.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func membershipRoleValid(m *Membership) error {
+	for _, role := range MemberRoles {
+		if m.Role == role {
+			return nil
+		}
+	}
+	return ErrInvalidRole
+}
+
+// normalizeMembershipEmail matches the way
+// email addresses of users are stored.
+func normalizeMembershipEmail(m *Membership) error {
+	m.Email = strings.TrimSpace(strings.ToLower(m.Email))
+	if m.Email == "" {
+		return ErrRequireEmail
+	}
+	return nil
+}
+
+var _ MembershipDB = &membershipGorm{}
+
+type membershipGorm struct {
+	db *gorm.DB
+}
+
+func (mg *membershipGorm) ByID(id uint) (*Membership, error) {
+	var m Membership
+	err := first(mg.db.Where("id = ?", id), &m)
+	return &m, err
+}
+
+func (mg *membershipGorm) ByGalleryAndUser(galleryID, userID uint) (*Membership, error) {
+	var m Membership
+	db := mg.db.Where("gallery_id = ? AND user_id = ?", galleryID, userID)
+	err := first(db, &m)
+	return &m, err
+}
+
+func (mg *membershipGorm) ByGalleryID(galleryID uint) ([]Membership, error) {
+	var members []Membership
+	err := mg.db.Where("gallery_id = ?", galleryID).Order("created_at").Find(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (mg *membershipGorm) ByUserID(userID uint, accepted bool) ([]Membership, error) {
+	db := mg.db.Where("user_id = ?", userID)
+	if accepted {
+		db = db.Where("accepted_at IS NOT NULL")
+	} else {
+		db = db.Where("accepted_at IS NULL")
+	}
+	var members []Membership
+	if err := db.Order("created_at").Find(&members).Error; err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (mg *membershipGorm) Create(m *Membership) error {
+	return mg.db.Create(m).Error
+}
+
+func (mg *membershipGorm) Accept(id uint) error {
+	return mg.db.Model(&Membership{}).
+		Where("id = ?", id).
+		Update("accepted_at", time.Now()).Error
+}
+
+func (mg *membershipGorm) Delete(id uint) error {
+	m := Membership{Model: gorm.Model{ID: id}}
+	return mg.db.Unscoped().Delete(&m).Error
+}
@@ -0,0 +1,388 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"regexp"
+)
+
+const (
+	exifHeader        = "Exif\x00\x00"
+	xmpHeader         = "http://ns.adobe.com/xap/1.0/\x00"
+	xmpExtendedHeader = "http://ns.adobe.com/xmp/extension/\x00"
+	pngSignature      = "\x89PNG\r\n\x1a\n"
+)
+
+// scrubMetadata removes the GPS position and the serial numbers from
+// the metadata of a whole image file: the EXIF of JPEG, PNG and WebP
+// files and the XMP of all of them. Metadata is scrubbed in place
+// where it can be, metadata which can't be read is dropped. It
+// returns the scrubbed file and whether anything was changed, or
+// ErrImageMetadata when the file itself can't be taken apart, so
+// there is no telling where its metadata is.
+func scrubMetadata(contentType string, b []byte) ([]byte, bool, error) {
+	switch contentType {
+	case "image/jpeg":
+		return scrubJPEG(b)
+	case "image/png":
+		return scrubPNG(b)
+	case "image/webp":
+		return scrubWebP(b)
+	case "image/gif":
+		return scrubGIF(b)
+	}
+	return nil, false, ErrImageMetadata
+}
+
+func scrubJPEG(b []byte) ([]byte, bool, error) {
+	if len(b) < 4 || b[0] != 0xFF || b[1] != 0xD8 {
+		return nil, false, ErrImageMetadata
+	}
+	var drop [][2]int
+	changed := false
+	for p := 2; p < len(b); {
+		if b[p] != 0xFF || p+1 == len(b) {
+			return nil, false, ErrImageMetadata
+		}
+		marker := b[p+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte before a marker.
+			p++
+			continue
+		case marker == 0xD9:
+			// Anything after the end of the image, like the previews
+			// and depth maps of some phones or the video of motion
+			// photos, has metadata of its own and is dropped.
+			changed = changed || p+2 < len(b)
+			return cut(b[:p+2], drop), changed, nil
+		case marker == 0x01 || marker >= 0xD0 && marker <= 0xD7:
+			// Markers without a segment.
+			p += 2
+			continue
+		}
+		if p+4 > len(b) {
+			return nil, false, ErrImageMetadata
+		}
+		end := p + 2 + int(binary.BigEndian.Uint16(b[p+2:]))
+		if end < p+4 || end > len(b) {
+			return nil, false, ErrImageMetadata
+		}
+		seg := b[p+4 : end]
+		ok := true
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(seg, []byte(exifHeader)):
+			var scrubbed bool
+			if t, found := newTIFF(seg[len(exifHeader):]); !found {
+				ok = false
+			} else if scrubbed, ok = t.scrub(); scrubbed {
+				changed = true
+			}
+		case marker == 0xE1 && bytes.HasPrefix(seg, []byte(xmpExtendedHeader)):
+			// Extended XMP is split over several segments, so a
+			// property can be cut in two.
+			ok = false
+		case marker == 0xE1 && bytes.HasPrefix(seg, []byte(xmpHeader)),
+			// Photoshop keeps a copy of the XMP in APP13.
+			marker == 0xED:
+			var scrubbed bool
+			if scrubbed, ok = scrubXMP(seg); scrubbed {
+				changed = true
+			}
+		}
+		if !ok {
+			drop = append(drop, [2]int{p, end})
+			changed = true
+		}
+		p = end
+		if marker == 0xDA {
+			p = skipEntropyData(b, p)
+		}
+	}
+	// The file ends within the compressed image data,
+	// where there can't be any metadata.
+	return cut(b, drop), changed, nil
+}
+
+// skipEntropyData returns the offset of the marker ending the
+// compressed image data starting at p, or the end of b. Within
+// the data 0xFF is followed by a zero byte or a restart marker.
+func skipEntropyData(b []byte, p int) int {
+	for ; p+1 < len(b); p++ {
+		if b[p] == 0xFF && b[p+1] != 0 && (b[p+1] < 0xD0 || b[p+1] > 0xD7) {
+			return p
+		}
+	}
+	return len(b)
+}
+
+// pngRawProfiles are the text chunk keywords ImageMagick
+// stores EXIF and XMP under, hex encoded.
+var pngRawProfiles = map[string]bool{
+	"Raw profile type exif": true,
+	"Raw profile type APP1": true,
+	"Raw profile type xmp":  true,
+}
+
+func scrubPNG(b []byte) ([]byte, bool, error) {
+	if !bytes.HasPrefix(b, []byte(pngSignature)) {
+		return nil, false, ErrImageMetadata
+	}
+	var drop [][2]int
+	changed := false
+	for p := len(pngSignature); ; {
+		if p+12 > len(b) {
+			return nil, false, ErrImageMetadata
+		}
+		length := uint64(binary.BigEndian.Uint32(b[p:]))
+		if uint64(p)+12+length > uint64(len(b)) {
+			return nil, false, ErrImageMetadata
+		}
+		end := p + 12 + int(length)
+		data := b[p+8 : end-4]
+		ok, scrubbed := true, false
+		switch string(b[p+4 : p+8]) {
+		case "eXIf":
+			if t, found := newTIFF(data); !found {
+				ok = false
+			} else {
+				scrubbed, ok = t.scrub()
+			}
+		case "iTXt":
+			keyword, rest, _ := bytes.Cut(data, []byte{0})
+			if string(keyword) != "XML:com.adobe.xmp" {
+				break
+			}
+			// The compression flag and method, the language
+			// tag and the translated keyword come first.
+			if len(rest) < 2 || rest[0] != 0 {
+				ok = false
+				break
+			}
+			_, text, found := bytes.Cut(rest[2:], []byte{0})
+			if found {
+				_, text, found = bytes.Cut(text, []byte{0})
+			}
+			if !found {
+				ok = false
+				break
+			}
+			scrubbed, ok = scrubXMP(text)
+		case "tEXt", "zTXt":
+			keyword, _, _ := bytes.Cut(data, []byte{0})
+			ok = !pngRawProfiles[string(keyword)]
+		case "IEND":
+			changed = changed || end < len(b)
+			return cut(b[:end], drop), changed, nil
+		}
+		if scrubbed {
+			binary.BigEndian.PutUint32(b[end-4:], crc32.ChecksumIEEE(b[p+4:end-4]))
+			changed = true
+		}
+		if !ok {
+			drop = append(drop, [2]int{p, end})
+			changed = true
+		}
+		p = end
+	}
+}
+
+// VP8X flags telling that a WebP has EXIF or XMP chunks.
+const (
+	webpExifFlag = 0x08
+	webpXMPFlag  = 0x04
+)
+
+func scrubWebP(b []byte) ([]byte, bool, error) {
+	if len(b) < 12 || string(b[:4]) != "RIFF" || string(b[8:12]) != "WEBP" {
+		return nil, false, ErrImageMetadata
+	}
+	size := uint64(binary.LittleEndian.Uint32(b[4:]))
+	if size < 4 || size+8 > uint64(len(b)) {
+		return nil, false, ErrImageMetadata
+	}
+	// Anything after the RIFF container is dropped.
+	changed := size+8 < uint64(len(b))
+	b = b[:size+8]
+
+	var drop [][2]int
+	vp8x := -1
+	for p := 12; p < len(b); {
+		if p+8 > len(b) {
+			return nil, false, ErrImageMetadata
+		}
+		length := uint64(binary.LittleEndian.Uint32(b[p+4:]))
+		if uint64(p)+8+length > uint64(len(b)) {
+			return nil, false, ErrImageMetadata
+		}
+		data := b[p+8 : p+8+int(length)]
+		// Chunks are padded to an even length.
+		end := p + 8 + int(length+length&1)
+		if end > len(b) {
+			end = len(b)
+		}
+		ok, scrubbed, flag := true, false, byte(0)
+		switch string(b[p : p+4]) {
+		case "VP8X":
+			if length > 0 {
+				vp8x = p + 8
+			}
+		case "EXIF":
+			// Some encoders keep the header of the JPEG segment.
+			data = bytes.TrimPrefix(data, []byte(exifHeader))
+			if t, found := newTIFF(data); !found {
+				ok = false
+			} else {
+				scrubbed, ok = t.scrub()
+			}
+			flag = webpExifFlag
+		case "XMP ":
+			scrubbed, ok = scrubXMP(data)
+			flag = webpXMPFlag
+		}
+		changed = changed || scrubbed
+		if !ok {
+			drop = append(drop, [2]int{p, end})
+			if vp8x >= 0 {
+				b[vp8x] &^= flag
+			}
+			changed = true
+		}
+		p = end
+	}
+	b = cut(b, drop)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+	return b, changed, nil
+}
+
+func scrubGIF(b []byte) ([]byte, bool, error) {
+	if len(b) < 13 || string(b[:6]) != "GIF87a" && string(b[:6]) != "GIF89a" {
+		return nil, false, ErrImageMetadata
+	}
+	p := 13
+	if flags := b[10]; flags&0x80 != 0 {
+		// The global color table.
+		p += 3 << (flags&7 + 1)
+	}
+	var drop [][2]int
+	for p < len(b) {
+		start := p
+		var ok bool
+		switch b[p] {
+		case 0x21:
+			// Extension, XMP is kept in an application extension.
+			if p+2 > len(b) {
+				return nil, false, ErrImageMetadata
+			}
+			xmp := b[p+1] == 0xFF && bytes.HasPrefix(b[p+2:], []byte("\x0bXMP DataXMP"))
+			if p, ok = skipSubBlocks(b, p+2); !ok {
+				return nil, false, ErrImageMetadata
+			}
+			if xmp {
+				drop = append(drop, [2]int{start, p})
+			}
+		case 0x2C:
+			// Image descriptor and the image data.
+			if p+11 > len(b) {
+				return nil, false, ErrImageMetadata
+			}
+			if flags := b[p+9]; flags&0x80 != 0 {
+				p += 3 << (flags&7 + 1)
+			}
+			if p, ok = skipSubBlocks(b, p+11); !ok {
+				return nil, false, ErrImageMetadata
+			}
+		case 0x3B:
+			changed := len(drop) > 0 || p+1 < len(b)
+			return cut(b[:p+1], drop), changed, nil
+		default:
+			return nil, false, ErrImageMetadata
+		}
+	}
+	// The trailer is missing, which decoders put up with.
+	return cut(b, drop), len(drop) > 0, nil
+}
+
+// skipSubBlocks returns the offset after the data sub-blocks at p,
+// which end with an empty one.
+func skipSubBlocks(b []byte, p int) (int, bool) {
+	for p < len(b) {
+		n := int(b[p])
+		p++
+		if n == 0 {
+			return p, true
+		}
+		p += n
+	}
+	return 0, false
+}
+
+// xmpGPSAttribute matches GPS properties written as attributes, like
+// exif:GPSLatitude="33,30.0S". Local names are matched regardless of
+// case, as drones write drone-dji:GpsLatitude.
+var xmpGPSAttribute = regexp.MustCompile(`(?i)\s[\w.-]+:gps\w*\s*=\s*("[^"]*"|'[^']*')`)
+
+// xmpGPSElement matches the start tag of a GPS property
+// written as an element.
+var xmpGPSElement = regexp.MustCompile(`(?i)<([\w.-]+:gps\w*)[\s/>]`)
+
+// scrubXMP overwrites the GPS properties in an XMP packet with
+// spaces, so the packet stays valid XML of the same size. It returns
+// whether anything was scrubbed, and false for ok when a property
+// doesn't end, so the packet has to be dropped instead.
+func scrubXMP(b []byte) (scrubbed, ok bool) {
+	for _, loc := range xmpGPSAttribute.FindAllIndex(b, -1) {
+		if bytes.HasPrefix(bytes.TrimSpace(b[loc[0]:loc[1]]), []byte("xmlns:")) {
+			// A namespace declaration, not a property.
+			continue
+		}
+		blank(b[loc[0]:loc[1]])
+		scrubbed = true
+	}
+	for {
+		loc := xmpGPSElement.FindSubmatchIndex(b)
+		if loc == nil {
+			return scrubbed, true
+		}
+		name := b[loc[2]:loc[3]]
+		gt := bytes.IndexByte(b[loc[0]:], '>')
+		if gt < 0 {
+			return scrubbed, false
+		}
+		end := loc[0] + gt + 1
+		if b[end-2] != '/' {
+			closing := bytes.Index(b[end:], append([]byte("</"), name...))
+			if closing < 0 {
+				return scrubbed, false
+			}
+			gt = bytes.IndexByte(b[end+closing:], '>')
+			if gt < 0 {
+				return scrubbed, false
+			}
+			end += closing + gt + 1
+		}
+		blank(b[loc[0]:end])
+		scrubbed = true
+	}
+}
+
+func blank(b []byte) {
+	for i := range b {
+		b[i] = ' '
+	}
+}
+
+// cut returns b without the ranges, which are in order.
+func cut(b []byte, ranges [][2]int) []byte {
+	if len(ranges) == 0 {
+		return b
+	}
+	out := make([]byte, 0, len(b))
+	p := 0
+	for _, r := range ranges {
+		out = append(out, b[p:r[0]]...)
+		p = r[1]
+	}
+	return append(out, b[p:]...)
+}
@@ -0,0 +1,244 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/png"
+	"testing"
+)
+
+const testXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF>` +
+	`<rdf:Description xmlns:exif="http://ns.adobe.com/exif/1.0/" exif:GPSLatitude="33,30.0S" ` +
+	`drone-dji:GpsLongitude='+151.25' tiff:Make="Canon">` +
+	`<exif:GPSLongitude>151,15.0E</exif:GPSLongitude><exif:GPSAltitude/>` +
+	`</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+// gpsExif returns a TIFF with a GPS position and a camera make.
+func gpsExif() []byte {
+	tb := newTIFFBuilder()
+	gps := tb.ifd(
+		exifASCII(tagGPSLatitudeRef, "S"),
+		exifRationals(tagGPSLatitude, 33, 1, 30, 1, 0, 1),
+	)
+	ifd0 := tb.ifd(exifASCII(tagMake, "Canon"), exifLong(tagGPSIFD, gps))
+	binary.LittleEndian.PutUint32(tb.b[4:], ifd0)
+	return tb.b
+}
+
+// checkScrubbedXMP checks that none of the GPS properties of
+// testXMP are left in b, but everything else is.
+func checkScrubbedXMP(t *testing.T, b []byte) {
+	t.Helper()
+	for _, s := range []string{"33,30", "151", "GPS", "Gps"} {
+		if bytes.Contains(b, []byte(s)) {
+			t.Errorf("%q left after scrubbing", s)
+		}
+	}
+	for _, s := range []string{`xmlns:exif="http://ns.adobe.com/exif/1.0/"`, `tiff:Make="Canon"`, "</rdf:Description>"} {
+		if !bytes.Contains(b, []byte(s)) {
+			t.Errorf("%q was scrubbed", s)
+		}
+	}
+}
+
+func TestScrubXMP(t *testing.T) {
+	b := []byte(testXMP)
+	scrubbed, ok := scrubXMP(b)
+	if !scrubbed || !ok {
+		t.Fatalf("scrubXMP = %v, %v, want true, true", scrubbed, ok)
+	}
+	if len(b) != len(testXMP) {
+		t.Errorf("length changed from %d to %d", len(testXMP), len(b))
+	}
+	checkScrubbedXMP(t, b)
+
+	if _, ok := scrubXMP([]byte(`<rdf:Description><exif:GPSLatitude>33,30.0S`)); ok {
+		t.Error("scrubXMP scrubbed a property which doesn't end")
+	}
+}
+
+func TestScrubJPEG(t *testing.T) {
+	b := []byte{0xFF, 0xD8}
+	b = append(b, jpegSegment(0xE1, append([]byte(exifHeader), gpsExif()...))...)
+	b = append(b, jpegSegment(0xE1, append([]byte(xmpHeader), testXMP...))...)
+	b = append(b, jpegSegment(0xE1, append([]byte(xmpExtendedHeader), `<exif:GPSLatitude>33,`...))...)
+	// The EXIF segment can be anywhere before the image data,
+	// not just in the head.
+	for n := 0; n <= imageHeadSize; n += 60000 {
+		b = append(b, jpegSegment(0xE2, make([]byte, 60000))...)
+	}
+	b = append(b, jpegSegment(0xE1, append([]byte(exifHeader), gpsExif()...))...)
+	b = append(b, jpegSegment(0xDA, []byte{1, 2, 3})...)
+	b = append(b, 0x12, 0xFF, 0x00, 0x34, 0xFF, 0xD0, 0x56)
+	b = append(b, 0xFF, 0xD9)
+	b = append(b, "trailing video with its own location"...)
+
+	b, changed, err := scrubMetadata("image/jpeg", b)
+	if err != nil || !changed {
+		t.Fatalf("scrubMetadata = %v, %v, want it to scrub", changed, err)
+	}
+	checkScrubbedXMP(t, b)
+	if bytes.Contains(b, []byte(xmpExtendedHeader)) {
+		t.Error("extended XMP left after scrubbing")
+	}
+	if !bytes.HasSuffix(b, []byte{0x56, 0xFF, 0xD9}) {
+		t.Error("data after the end of the image left after scrubbing")
+	}
+	if exif, ok := parseExif(b); !ok || exif.CameraMake != "Canon" || exif.HasLocation() {
+		t.Errorf("parseExif after scrubbing = %+v, %v, want the make without the location", exif, ok)
+	}
+	if n := bytes.Count(b, []byte("\x00\x00\x00\x00\x00\x00\x00\x00")); n == 0 {
+		t.Error("GPS IFDs weren't emptied")
+	}
+	if bytes.Contains(b, []byte{33, 0, 0, 0, 1, 0, 0, 0, 30}) {
+		t.Error("latitude left after scrubbing")
+	}
+}
+
+// pngChunk returns a chunk with a correct CRC.
+func pngChunk(typ string, data []byte) []byte {
+	c := make([]byte, 8+len(data)+4)
+	binary.BigEndian.PutUint32(c, uint32(len(data)))
+	copy(c[4:], typ)
+	copy(c[8:], data)
+	binary.BigEndian.PutUint32(c[8+len(data):], crc32.ChecksumIEEE(c[4:8+len(data)]))
+	return c
+}
+
+func TestScrubPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	iend := len(encoded) - 12
+
+	var b []byte
+	b = append(b, encoded[:iend]...)
+	b = append(b, pngChunk("eXIf", gpsExif())...)
+	b = append(b, pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"+testXMP))...)
+	b = append(b, pngChunk("zTXt", []byte("Raw profile type exif\x00\x00compressed"))...)
+	b = append(b, pngChunk("tEXt", []byte("Title\x00Harbour"))...)
+	b = append(b, encoded[iend:]...)
+
+	b, changed, err := scrubMetadata("image/png", b)
+	if err != nil || !changed {
+		t.Fatalf("scrubMetadata = %v, %v, want it to scrub", changed, err)
+	}
+	// The decoder checks the CRC of every chunk.
+	if _, err := png.Decode(bytes.NewReader(b)); err != nil {
+		t.Fatalf("scrubbed PNG doesn't decode: %v", err)
+	}
+	checkScrubbedXMP(t, b)
+	if bytes.Contains(b, []byte("Raw profile type")) {
+		t.Error("raw EXIF profile left after scrubbing")
+	}
+	if !bytes.Contains(b, []byte("Harbour")) {
+		t.Error("title was scrubbed")
+	}
+	if bytes.Contains(b, []byte{33, 0, 0, 0, 1, 0, 0, 0, 30}) {
+		t.Error("latitude left after scrubbing")
+	}
+}
+
+// riffChunk returns a WebP chunk padded to an even length.
+func riffChunk(fourCC string, data []byte) []byte {
+	c := make([]byte, 8, 9+len(data))
+	copy(c, fourCC)
+	binary.LittleEndian.PutUint32(c[4:], uint32(len(data)))
+	c = append(c, data...)
+	if len(data)%2 == 1 {
+		c = append(c, 0)
+	}
+	return c
+}
+
+func TestScrubWebP(t *testing.T) {
+	b := []byte("RIFF\x00\x00\x00\x00WEBP")
+	b = append(b, riffChunk("VP8X", []byte{webpExifFlag | webpXMPFlag, 0, 0, 0, 0, 0, 0, 0, 0, 0})...)
+	b = append(b, riffChunk("VP8L", []byte("image"))...)
+	b = append(b, riffChunk("EXIF", append([]byte(exifHeader), gpsExif()...))...)
+	b = append(b, riffChunk("XMP ", []byte(testXMP+`<exif:GPSLatitude>33,30.0S`))...)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+	b = append(b, "after the container"...)
+
+	b, changed, err := scrubMetadata("image/webp", b)
+	if err != nil || !changed {
+		t.Fatalf("scrubMetadata = %v, %v, want it to scrub", changed, err)
+	}
+	if size := binary.LittleEndian.Uint32(b[4:]); int(size) != len(b)-8 {
+		t.Errorf("RIFF size = %d, want %d", size, len(b)-8)
+	}
+	// The XMP doesn't end, so it is dropped along with its flag.
+	if bytes.Contains(b, []byte("XMP ")) || bytes.Contains(b, []byte("33,30")) {
+		t.Error("XMP left after scrubbing")
+	}
+	if flags := b[20]; flags != webpExifFlag {
+		t.Errorf("VP8X flags = %#x, want %#x", flags, webpExifFlag)
+	}
+	if bytes.Contains(b, []byte{33, 0, 0, 0, 1, 0, 0, 0, 30}) {
+		t.Error("latitude left after scrubbing")
+	}
+	if !bytes.Contains(b, []byte("Canon")) || !bytes.Contains(b, []byte("image")) {
+		t.Error("more than the location was scrubbed")
+	}
+	if bytes.Contains(b, []byte("after the container")) {
+		t.Error("data after the container left after scrubbing")
+	}
+}
+
+func TestScrubGIF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)), nil); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	descriptor := 13
+	if flags := encoded[10]; flags&0x80 != 0 {
+		descriptor += 3 << (flags&7 + 1)
+	}
+
+	var b []byte
+	b = append(b, encoded[:descriptor]...)
+	b = append(b, 0x21, 0xFF, 11)
+	b = append(b, "XMP DataXMP"...)
+	// The packet is written as it is, its bytes make up the sub-blocks.
+	b = append(b, 5, 'G', 'P', 'S', '!', '!', 0)
+	b = append(b, encoded[descriptor:]...)
+
+	b, changed, err := scrubMetadata("image/gif", b)
+	if err != nil || !changed {
+		t.Fatalf("scrubMetadata = %v, %v, want it to scrub", changed, err)
+	}
+	if !bytes.Equal(b, encoded) {
+		t.Errorf("scrubbed GIF = %x, want %x", b, encoded)
+	}
+}
+
+func TestScrubCorruptFiles(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	truncatedPNG := buf.Bytes()[:buf.Len()-20]
+
+	tests := []struct {
+		contentType string
+		b           []byte
+	}{
+		{"image/jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF, 'E', 'x', 'i', 'f'}},
+		{"image/jpeg", []byte{0xFF, 0xD8, 0x00, 0x00}},
+		{"image/png", truncatedPNG},
+		{"image/webp", []byte("RIFF\xFF\xFF\x00\x00WEBPVP8X")},
+		{"image/webp", append([]byte("RIFF\x14\x00\x00\x00WEBP"), riffChunk("EXIF", nil)[:4]...)},
+		{"image/gif", []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00\x2C\x00")},
+		{"image/gif", []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00\x99")},
+		{"image/bmp", []byte("BM")},
+	}
+	for _, tt := range tests {
+		if _, _, err := scrubMetadata(tt.contentType, tt.b); err != ErrImageMetadata {
+			t.Errorf("scrubMetadata(%s, %q) = %v, want %v", tt.contentType, tt.b, err, ErrImageMetadata)
+		}
+	}
+}
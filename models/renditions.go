@@ -0,0 +1,185 @@
+package models
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const renditionJPEGQuality = 85
+
+// Rendition describes a resized copy which is generated for
+// every uploaded image. Images narrower than Width are not
+// upscaled, so they have no such rendition.
+type Rendition struct {
+	Name  string `json:"name"`
+	Width int    `json:"width"`
+}
+
+// DefaultRenditions are used when no renditions are configured.
+var DefaultRenditions = []Rendition{
+	{Name: "thumb", Width: 320},
+	{Name: "display", Width: 1024},
+	{Name: "large", Width: 2048},
+}
+
+var renditionNameRegexp = regexp.MustCompile(`^[a-z0-9_\-]+$`)
+
+func validateRenditions(renditions []Rendition) error {
+	seen := make(map[string]bool, len(renditions))
+	for _, r := range renditions {
+		if !renditionNameRegexp.MatchString(r.Name) || r.Width <= 0 || seen[r.Name] {
+			return ErrInvalidRendition
+		}
+		seen[r.Name] = true
+	}
+	return nil
+}
+
+// renditionFormat returns the extension and the encoder used for the
+// renditions of an image with the given content type. Animated GIFs
+// would lose their frames, so they are served as is. WebPs can have
+// an alpha channel, which JPEG doesn't, and there is no WebP encoder,
+// so their renditions are PNGs.
+func renditionFormat(contentType string) (string, func(io.Writer, image.Image) error, bool) {
+	switch contentType {
+	case "image/png", "image/webp":
+		return ".png", png.Encode, true
+	case "image/gif":
+		return "", nil, false
+	default:
+		return ".jpg", func(w io.Writer, img image.Image) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: renditionJPEGQuality})
+		}, true
+	}
+}
+
+// generateRenditions decodes the original image, turns it upright
+// and puts every rendition narrower than the original to the store.
+// It returns the names of the renditions which were stored.
+func (is *imageService) generateRenditions(i *Image) ([]string, error) {
+	ext, encode, ok := renditionFormat(i.ContentType)
+	if !ok {
+		return nil, nil
+	}
+	i.RenditionExt = ext
+
+	b, err := is.readOriginal(i)
+	if err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(b))
+	if err == image.ErrFormat {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Browsers turn the original the way its EXIF says, renditions
+	// have no EXIF, so they are turned before they are scaled.
+	if i.ContentType == "image/jpeg" {
+		src = orient(src, exifOrientation(b))
+	}
+
+	// Going from the largest rendition to the smallest one lets
+	// every rendition be scaled down from the previous one.
+	renditions := make([]Rendition, len(is.cfg.Renditions))
+	copy(renditions, is.cfg.Renditions)
+	sort.Slice(renditions, func(a, b int) bool {
+		return renditions[a].Width > renditions[b].Width
+	})
+
+	var names []string
+	for _, r := range renditions {
+		b := src.Bounds()
+		if b.Dx() <= r.Width {
+			continue
+		}
+		height := b.Dy() * r.Width / b.Dx()
+		if height < 1 {
+			height = 1
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, r.Width, height))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+
+		var buf bytes.Buffer
+		if err := encode(&buf, dst); err != nil {
+			return names, err
+		}
+		if err := is.store.Put(i.renditionKey(r.Name), &buf); err != nil {
+			return names, err
+		}
+		names = append(names, r.Name)
+		src = dst
+	}
+	return names, nil
+}
+
+// orient turns img the way the EXIF Orientation says it is shown:
+// 1 is upright, 2 to 4 are mirrored or upside down and 5 to 8 are
+// on their side, so width and height swap.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	if orientation >= 5 {
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := x, y
+			switch orientation {
+			case 2:
+				dx = w - 1 - x
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dy = h - 1 - y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):])
+		}
+	}
+	return dst
+}
+
+func (is *imageService) deleteRenditions(i *Image) error {
+	for _, name := range i.Renditions {
+		err := is.store.Delete(i.renditionKey(name))
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// renditionFilename keeps the original filename, so renditions of
+// different images never collide, and appends the extension of the
+// rendition format when the original one does not match it.
+func renditionFilename(filename, ext string) string {
+	orig := strings.ToLower(filepath.Ext(filename))
+	if orig == ext || (ext == ".jpg" && orig == ".jpeg") {
+		return filename
+	}
+	return filename + ext
+}
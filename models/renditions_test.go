@@ -0,0 +1,139 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestOrient(t *testing.T) {
+	// A 3x2 image whose pixels are numbered in their red channel:
+	//   1 2 3
+	//   4 5 6
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < 6; i++ {
+		src.Set(i%3, i/3, color.RGBA{R: uint8(i + 1), A: 255})
+	}
+	tests := []struct {
+		orientation int
+		want        [][]uint8
+	}{
+		{1, [][]uint8{{1, 2, 3}, {4, 5, 6}}},
+		{2, [][]uint8{{3, 2, 1}, {6, 5, 4}}},
+		{3, [][]uint8{{6, 5, 4}, {3, 2, 1}}},
+		{4, [][]uint8{{4, 5, 6}, {1, 2, 3}}},
+		{5, [][]uint8{{1, 4}, {2, 5}, {3, 6}}},
+		{6, [][]uint8{{4, 1}, {5, 2}, {6, 3}}},
+		{7, [][]uint8{{6, 3}, {5, 2}, {4, 1}}},
+		{8, [][]uint8{{3, 6}, {2, 5}, {1, 4}}},
+	}
+	for _, tt := range tests {
+		img := orient(src, tt.orientation)
+		b := img.Bounds()
+		if b.Dx() != len(tt.want[0]) || b.Dy() != len(tt.want) {
+			t.Errorf("orientation %d: size %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), len(tt.want[0]), len(tt.want))
+			continue
+		}
+		for y, row := range tt.want {
+			for x, want := range row {
+				if r, _, _, _ := img.At(x, y).RGBA(); uint8(r>>8) != want {
+					t.Errorf("orientation %d: pixel %d,%d is %d, want %d", tt.orientation, x, y, r>>8, want)
+				}
+			}
+		}
+	}
+}
+
+// orientedJPEG returns a JPEG of the given size whose
+// EXIF has the Orientation.
+func orientedJPEG(t *testing.T, w, h int, orientation uint16) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	tb := newTIFFBuilder()
+	value := make([]byte, 2)
+	binary.LittleEndian.PutUint16(value, orientation)
+	ifd0 := tb.ifd(exifEntry{tagOrientation, 3, 1, value})
+	binary.LittleEndian.PutUint32(tb.b[4:], ifd0)
+
+	b := []byte{0xFF, 0xD8}
+	b = append(b, jpegSegment(0xE1, append([]byte(exifHeader), tb.b...))...)
+	return append(b, buf.Bytes()[2:]...)
+}
+
+func TestGenerateRenditions(t *testing.T) {
+	var transparent bytes.Buffer
+	if err := png.Encode(&transparent, image.NewNRGBA(image.Rect(0, 0, 4, 2))); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		contentType string
+		b           []byte
+		ext         string
+		width       int
+		height      int
+	}{
+		{"upright", "image/jpeg", orientedJPEG(t, 40, 20, 1), ".jpg", 10, 5},
+		{"on its side", "image/jpeg", orientedJPEG(t, 40, 20, 6), ".jpg", 10, 20},
+		{"transparent", "image/png", transparent.Bytes(), ".png", 2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := &imageService{
+				store: NewDiskStore(t.TempDir()),
+				cfg:   ImageConfig{Renditions: []Rendition{{Name: "thumb", Width: tt.width}}},
+			}
+			i := &Image{GalleryID: 1, Filename: "photo", ContentType: tt.contentType}
+			if err := is.store.Put(i.Key(), bytes.NewReader(tt.b)); err != nil {
+				t.Fatal(err)
+			}
+			names, err := is.generateRenditions(i)
+			if err != nil || len(names) != 1 {
+				t.Fatalf("generateRenditions = %v, %v, want the thumb", names, err)
+			}
+			if i.RenditionExt != tt.ext {
+				t.Errorf("RenditionExt = %q, want %q", i.RenditionExt, tt.ext)
+			}
+			i.Renditions = names
+			r, err := is.store.Get(i.renditionKey("thumb"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			cfg, _, err := image.DecodeConfig(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Width != tt.width || cfg.Height != tt.height {
+				t.Errorf("thumb is %dx%d, want %dx%d", cfg.Width, cfg.Height, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+// Renditions made before the format was saved with the image keep
+// the extension they were made with.
+func TestRenditionKey(t *testing.T) {
+	tests := []struct {
+		contentType  string
+		renditionExt string
+		want         string
+	}{
+		{"image/jpeg", "", "galleries/1/renditions/thumb/photo.jpg"},
+		{"image/png", "", "galleries/1/renditions/thumb/photo.png"},
+		{"image/webp", "", "galleries/1/renditions/thumb/photo.jpg"},
+		{"image/webp", ".png", "galleries/1/renditions/thumb/photo.png"},
+	}
+	for _, tt := range tests {
+		i := &Image{GalleryID: 1, Filename: "photo", ContentType: tt.contentType, RenditionExt: tt.renditionExt}
+		if got := i.renditionKey("thumb"); got != tt.want {
+			t.Errorf("renditionKey of %s with %q = %q, want %q", tt.contentType, tt.renditionExt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package models
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"photo-gallery/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultUploadExpireHours is how long an upload which is
+	// not finished is kept since it was last written to.
+	DefaultUploadExpireHours = 24
+	// DefaultUploadMaxPending is how many unfinished
+	// uploads a user can have at once.
+	DefaultUploadMaxPending = 10
+
+	uploadIDBytes = 16
+)
+
+// UploadConfig tells where the UploadStore keeps unfinished
+// uploads, for how long and how many of them a user can have.
+type UploadConfig struct {
+	Path        string `json:"path"`
+	ExpireHours int    `json:"expire_hours"`
+	MaxPending  int    `json:"max_pending"`
+}
+
+// Upload is a resumable upload of a single image into a gallery.
+// Its bytes are appended as they arrive until Offset reaches
+// Length, then it is handed to ImageService.Create.
+type Upload struct {
+	ID        string    `json:"id"`
+	GalleryID uint      `json:"gallery_id"`
+	UserID    uint      `json:"user_id"`
+	Length    int64     `json:"length"`
+	Filename  string    `json:"filename"`
+	Metadata  string    `json:"metadata"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Offset is the size of the data received so far. It is not
+	// saved in the info file, the data file itself tells it.
+	Offset int64 `json:"-"`
+}
+
+// Done reports whether all the bytes of the upload have arrived.
+func (u *Upload) Done() bool {
+	return u.Offset >= u.Length
+}
+
+// UploadStore keeps unfinished uploads on disk, every upload as an
+// info file with its JSON and a data file with the bytes received.
+// Since the data file is only ever appended to, its size is the
+// offset to resume the upload from, even after a restart.
+type UploadStore struct {
+	dir        string
+	ttl        time.Duration
+	maxPending int
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+	// createMu serializes Create, so uploads made at
+	// once are counted against each other.
+	createMu sync.Mutex
+}
+
+func NewUploadStore(cfg UploadConfig) (*UploadStore, error) {
+	if cfg.Path == "" {
+		cfg.Path = "uploads"
+	}
+	if cfg.ExpireHours <= 0 {
+		cfg.ExpireHours = DefaultUploadExpireHours
+	}
+	if cfg.MaxPending <= 0 {
+		cfg.MaxPending = DefaultUploadMaxPending
+	}
+	if err := os.MkdirAll(cfg.Path, 0700); err != nil {
+		return nil, err
+	}
+	return &UploadStore{
+		dir:        cfg.Path,
+		ttl:        time.Duration(cfg.ExpireHours) * time.Hour,
+		maxPending: cfg.MaxPending,
+		locks:      make(map[string]*sync.Mutex),
+	}, nil
+}
+
+// Create gives the upload an ID and an expiration time and saves it
+// with no data received yet. The full length of the unfinished uploads
+// of the user counts towards their quota, of which remaining bytes are
+// left by their images, as does the length of the new one.
+func (s *UploadStore) Create(u *Upload, remaining int64) error {
+	s.createMu.Lock()
+	defer s.createMu.Unlock()
+	pending, err := s.ByUserID(u.UserID)
+	if err != nil {
+		return err
+	}
+	if len(pending) >= s.maxPending {
+		return ErrTooManyUploads
+	}
+	for _, p := range pending {
+		remaining -= p.Length
+	}
+	if u.Length > remaining {
+		return ErrQuotaExceeded
+	}
+
+	b, err := rand.GenBytes(uploadIDBytes)
+	if err != nil {
+		return err
+	}
+	u.ID = hex.EncodeToString(b)
+	u.Offset = 0
+	u.ExpiresAt = time.Now().Add(s.ttl)
+	f, err := os.OpenFile(s.dataPath(u.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return s.saveInfo(u)
+}
+
+// Get returns the upload with its current offset. Expired uploads
+// are deleted on the way and reported as ErrNotFound.
+func (s *UploadStore) Get(id string) (*Upload, error) {
+	if !validUploadID(id) {
+		return nil, ErrNotFound
+	}
+	u, err := s.readInfo(id)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(u.ExpiresAt) {
+		s.Delete(id)
+		return nil, ErrNotFound
+	}
+	fi, err := os.Stat(s.dataPath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.Offset = fi.Size()
+	return u, nil
+}
+
+func (s *UploadStore) readInfo(id string) (*Upload, error) {
+	b, err := os.ReadFile(s.infoPath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var u Upload
+	if err := json.Unmarshal(b, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// Lock serializes the writes to an upload, the returned func unlocks it.
+func (s *UploadStore) Lock(id string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[id] = l
+	}
+	s.mu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// Append writes what r has to the end of the upload, but never more
+// than its length, and moves its expiration time. The offset of the
+// upload is updated even when writing failed halfway.
+func (s *UploadStore) Append(u *Upload, r io.Reader) error {
+	f, err := os.OpenFile(s.dataPath(u.ID), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, u.Length-u.Offset))
+	u.Offset += n
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+	u.ExpiresAt = time.Now().Add(s.ttl)
+	return s.saveInfo(u)
+}
+
+// Open returns the data of the upload.
+func (s *UploadStore) Open(u *Upload) (io.ReadCloser, error) {
+	return os.Open(s.dataPath(u.ID))
+}
+
+func (s *UploadStore) Delete(id string) error {
+	if !validUploadID(id) {
+		return ErrNotFound
+	}
+	err := os.Remove(s.dataPath(id))
+	if iErr := os.Remove(s.infoPath(id)); err == nil || os.IsNotExist(err) {
+		err = iErr
+	}
+	s.mu.Lock()
+	delete(s.locks, id)
+	s.mu.Unlock()
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// ByUserID returns the uploads of the user which have not expired.
+// Their offsets are not read.
+func (s *UploadStore) ByUserID(userID uint) ([]*Upload, error) {
+	infos, err := filepath.Glob(filepath.Join(s.dir, "*.info"))
+	if err != nil {
+		return nil, err
+	}
+	var uploads []*Upload
+	now := time.Now()
+	for _, p := range infos {
+		u, err := s.readInfo(strings.TrimSuffix(filepath.Base(p), ".info"))
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if u.UserID == userID && now.Before(u.ExpiresAt) {
+			uploads = append(uploads, u)
+		}
+	}
+	return uploads, nil
+}
+
+// Expire deletes the uploads which were abandoned for longer than
+// their expiration time and returns how many there were.
+func (s *UploadStore) Expire() (int, error) {
+	infos, err := filepath.Glob(filepath.Join(s.dir, "*.info"))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	now := time.Now()
+	for _, p := range infos {
+		id := strings.TrimSuffix(filepath.Base(p), ".info")
+		u, err := s.readInfo(id)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		if now.Before(u.ExpiresAt) {
+			continue
+		}
+		unlock := s.Lock(id)
+		err = s.Delete(id)
+		unlock()
+		if err != nil && err != ErrNotFound {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// ExpireEvery runs Expire in the background every interval.
+func (s *UploadStore) ExpireEvery(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			n, err := s.Expire()
+			if err != nil {
+				log.Println(err)
+			}
+			if n > 0 {
+				log.Printf("models: deleted %d abandoned uploads", n)
+			}
+		}
+	}()
+}
+
+// saveInfo replaces the info file through a rename, so it is
+// never left half written.
+func (s *UploadStore) saveInfo(u *Upload) error {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".info-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.infoPath(u.ID))
+}
+
+func (s *UploadStore) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".info")
+}
+
+func (s *UploadStore) dataPath(id string) string {
+	return filepath.Join(s.dir, id+".bin")
+}
+
+// validUploadID makes sure an ID from a URL can only
+// name files right in the directory of the store.
+func validUploadID(id string) bool {
+	if len(id) != 2*uploadIDBytes {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
@@ -0,0 +1,43 @@
+package models
+
+import "testing"
+
+func TestUploadStoreCreate(t *testing.T) {
+	s, err := NewUploadStore(UploadConfig{Path: t.TempDir(), MaxPending: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func(userID uint, length, remaining int64) error {
+		return s.Create(&Upload{GalleryID: 1, UserID: userID, Length: length}, remaining)
+	}
+
+	if err := create(1, 60, 100); err != nil {
+		t.Fatal(err)
+	}
+	// The first upload takes 60 of the 100 bytes left,
+	// though none of it has arrived yet.
+	if err := create(1, 50, 100); err != ErrQuotaExceeded {
+		t.Errorf("Create over the quota = %v, want %v", err, ErrQuotaExceeded)
+	}
+	if err := create(1, 40, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := create(1, 0, 100); err != ErrTooManyUploads {
+		t.Errorf("Create of a third upload = %v, want %v", err, ErrTooManyUploads)
+	}
+	// Other users have their own quota and uploads.
+	if err := create(2, 100, 100); err != nil {
+		t.Errorf("Create for another user = %v", err)
+	}
+
+	uploads, err := s.ByUserID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(uploads[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := create(1, 0, 100); err != nil {
+		t.Errorf("Create after deleting an upload = %v", err)
+	}
+}
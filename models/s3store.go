@@ -0,0 +1,382 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3Service         = "s3"
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3DateLayout      = "20060102T150405Z"
+)
+
+// S3Config points an S3Store to a bucket of an S3 compatible
+// service, such as AWS S3 or MinIO.
+type S3Config struct {
+	// Endpoint is the base URL of the service,
+	// e.g. "https://s3.eu-central-1.amazonaws.com" or "http://localhost:9000".
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+}
+
+var _ BlobStore = &S3Store{}
+var _ RangeGetter = &S3Store{}
+
+// S3Store is a BlobStore which keeps blobs as objects of an S3 bucket.
+// Requests are addressed path-style and signed with AWS Signature
+// Version 4, which every S3 compatible service supports.
+type S3Store struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Host == "" || cfg.Bucket == "" {
+		return nil, ErrInvalidS3Config
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	return &S3Store{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// Put uploads the blob in a single request. S3 needs to know the
+// length of an object upfront, so content of unknown length is
+// spooled to a temporary file first.
+func (s3 *S3Store) Put(key string, r io.Reader) error {
+	body, size, err := sizedReader(r)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	req, err := s3.request(http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	resp, err := s3.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s3 *S3Store) Get(key string) (io.ReadCloser, error) {
+	req, err := s3.request(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s3.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// GetRange sends the range along to S3, which answers a single range
+// with a part of the object, and several with all of it.
+func (s3 *S3Store) GetRange(key, rng string) (*BlobRange, error) {
+	req, err := s3.request(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", rng)
+	resp, err := s3.do(req)
+	if err != nil {
+		return nil, err
+	}
+	part := BlobRange{ReadCloser: resp.Body, Length: resp.ContentLength}
+	if resp.StatusCode == http.StatusPartialContent {
+		part.ContentRange = resp.Header.Get("Content-Range")
+	}
+	return &part, nil
+}
+
+// Delete never returns ErrNotFound, since S3 does not tell
+// whether there was an object to delete.
+func (s3 *S3Store) Delete(key string) error {
+	req, err := s3.request(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s3.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Move copies the object on the server side, then deletes the source.
+func (s3 *S3Store) Move(from, to string) error {
+	if !validKey(from) {
+		return ErrInvalidKey
+	}
+	req, err := s3.request(http.MethodPut, to, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Amz-Copy-Source", s3Escape("/"+s3.cfg.Bucket+"/"+from, false))
+	resp, err := s3.do(req)
+	if err != nil {
+		return err
+	}
+	if err := resp.Body.Close(); err != nil {
+		return err
+	}
+	return s3.Delete(from)
+}
+
+// List pages through the keys with ListObjectsV2.
+func (s3 *S3Store) List(prefix string) ([]string, error) {
+	if !validPrefix(prefix) {
+		return nil, ErrInvalidKey
+	}
+	var keys []string
+	token := ""
+	for {
+		u := *s3.endpoint
+		u.Path = s3.endpoint.Path + "/" + s3.cfg.Bucket
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = q.Encode()
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s3.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range page.Contents {
+			keys = append(keys, c.Key)
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (s3 *S3Store) request(method, key string, body io.Reader) (*http.Request, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+	u := *s3.endpoint
+	u.Path = s3.endpoint.Path + "/" + s3.cfg.Bucket + "/" + key
+	return http.NewRequest(method, u.String(), body)
+}
+
+// do signs and sends the request. Responses other than 2xx are
+// turned into errors, and their body is closed.
+func (s3 *S3Store) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+	s3.sign(req, time.Now())
+	resp, err := s3.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	case http.StatusRequestedRangeNotSatisfiable:
+		return nil, ErrRangeNotSatisfiable
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	return nil, fmt.Errorf("models: s3 %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, msg)
+}
+
+// sign adds the Authorization header of AWS Signature Version 4 to the
+// request. The host and every header already set on it are signed.
+func (s3 *S3Store) sign(req *http.Request, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(s3DateLayout)
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, s3.cfg.Region, s3Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s3.cfg.SecretKey), date)
+	key = hmacSHA256(key, s3.cfg.Region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s3.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+func s3CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		vals := q[k]
+		sort.Strings(vals)
+		for _, v := range vals {
+			pairs = append(pairs, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func s3EscapePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return s3Escape(p, false)
+}
+
+// s3Escape percent-encodes everything but the unreserved characters of
+// RFC 3986. Slashes are kept in paths, but not in query values.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// sizedReader returns the content of r together with its length,
+// spooling it to a temporary file when the length is not known.
+func sizedReader(r io.Reader) (io.ReadCloser, int64, error) {
+	if f, ok := r.(io.ReadSeeker); ok {
+		cur, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, 0, err
+		}
+		end, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, 0, err
+		}
+		if _, err := f.Seek(cur, io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+		return io.NopCloser(f), end - cur, nil
+	}
+
+	f, err := os.CreateTemp("", "photo-gallery-s3-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	tmp := tempFile{f}
+	n, err := io.Copy(tmp, r)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		return nil, 0, err
+	}
+	return tmp, n, nil
+}
+
+// tempFile is a temporary file which is removed once it is closed.
+type tempFile struct {
+	*os.File
+}
+
+func (f tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
@@ -6,10 +6,14 @@ import (
 )
 
 type Services struct {
-	Gallery GalleryService
-	User    UserService
-	Image   ImageService
-	db      *gorm.DB
+	Gallery    GalleryService
+	User       UserService
+	Image      ImageService
+	Jobs       JobService
+	ShareLink  ShareLinkService
+	Membership MembershipService
+	Tag        TagService
+	db         *gorm.DB
 }
 
 type ServicesConfig func(*Services) error
@@ -39,16 +43,49 @@ func WithUser(pepper, hmacKey string) ServicesConfig {
 	}
 }
 
-func WithGallery() ServicesConfig {
+func WithGallery(pepper, hmacKey string) ServicesConfig {
 	return func(s *Services) error {
-		s.Gallery = NewGalleryService(s.db)
+		s.Gallery = NewGalleryService(s.db, pepper, hmacKey)
 		return nil
 	}
 }
 
-func WithImage() ServicesConfig {
+func WithShareLink(hmacKey string) ServicesConfig {
 	return func(s *Services) error {
-		s.Image = NewImageService()
+		s.ShareLink = NewShareLinkService(s.db, hmacKey)
+		return nil
+	}
+}
+
+func WithMembership() ServicesConfig {
+	return func(s *Services) error {
+		s.Membership = NewMembershipService(s.db)
+		return nil
+	}
+}
+
+func WithTag() ServicesConfig {
+	return func(s *Services) error {
+		s.Tag = NewTagService(s.db)
+		return nil
+	}
+}
+
+func WithJobs() ServicesConfig {
+	return func(s *Services) error {
+		s.Jobs = NewJobService(s.db)
+		return nil
+	}
+}
+
+// WithImage must come after WithJobs, since
+// images are processed by background jobs.
+func WithImage(store BlobStore, cfg ImageConfig) ServicesConfig {
+	return func(s *Services) error {
+		if err := validateRenditions(cfg.Renditions); err != nil {
+			return err
+		}
+		s.Image = NewImageService(s.db, store, s.Jobs, cfg)
 		return nil
 	}
 }
@@ -68,13 +105,21 @@ func (s *Services) CloseConnection() error {
 }
 
 func (s *Services) DestructiveReset() error {
-	err := s.db.DropTableIfExists(&User{}, &Gallery{}).Error
+	err := s.db.DropTableIfExists(&User{}, &Gallery{}, &Image{}, &Job{}, &ShareLink{}, &Membership{}, &GallerySlug{}, &Tag{}, &GalleryTag{}, &ImageTag{}).Error
 	if err != nil {
 		return err
 	}
 	return s.AutoMigrate()
 }
 
+// AutoMigrate makes the usernames unique before the unique index
+// is added to them, which replaces the plain one they had.
 func (s *Services) AutoMigrate() error {
-	return s.db.AutoMigrate(&User{}, &Gallery{}).Error
+	if err := dedupeUsernames(s.db); err != nil {
+		return err
+	}
+	if err := s.db.Exec("DROP INDEX IF EXISTS idx_users_username").Error; err != nil {
+		return err
+	}
+	return s.db.AutoMigrate(&User{}, &Gallery{}, &Image{}, &Job{}, &ShareLink{}, &Membership{}, &GallerySlug{}, &Tag{}, &GalleryTag{}, &ImageTag{}).Error
 }
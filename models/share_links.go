@@ -0,0 +1,278 @@
+package models
+
+import (
+	"photo-gallery/hash"
+	"photo-gallery/rand"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ShareLink lets whoever has its token see a gallery, whatever its
+// visibility and password, until the link expires, runs out of views
+// or is revoked. Only the HMAC of the token is stored, like remember
+// tokens, so Token is only known right after Create.
+//
+// A zero ExpiresAt or MaxViews means the link has no such limit.
+// Views counts how often the gallery was opened through the link,
+// VisitedAt is when it was opened last.
+type ShareLink struct {
+	gorm.Model
+	GalleryID uint   `gorm:"not null;index"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not null;unique_index"`
+	ExpiresAt *time.Time
+	MaxViews  int `gorm:"not null;default:0"`
+	Views     int `gorm:"not null;default:0"`
+	VisitedAt *time.Time
+}
+
+// shareLinkVisitDuration is how long a visit through a link lasts,
+// the images of the gallery load and it can be downloaded during it.
+const shareLinkVisitDuration = time.Hour
+
+// Expired reports whether the link stopped working because of its age.
+func (l *ShareLink) Expired() bool {
+	return l.ExpiresAt != nil && !time.Now().Before(*l.ExpiresAt)
+}
+
+// UsedUp reports whether the gallery was opened through
+// the link as many times as it allows.
+func (l *ShareLink) UsedUp() bool {
+	return l.MaxViews > 0 && l.Views >= l.MaxViews
+}
+
+// Active reports whether the link still opens the gallery.
+func (l *ShareLink) Active() bool {
+	return !l.Expired() && !l.UsedUp()
+}
+
+// Visiting reports whether those who opened the gallery through the
+// link may still load its images. A link which ran out of views lets
+// them do so until the visit which took the last view is over.
+func (l *ShareLink) Visiting() bool {
+	if l.Active() {
+		return true
+	}
+	return !l.Expired() && l.VisitedAt != nil &&
+		time.Since(*l.VisitedAt) < shareLinkVisitDuration
+}
+
+type ShareLinkService interface {
+	ShareLinkDB
+}
+
+type ShareLinkDB interface {
+	ByID(id uint) (*ShareLink, error)
+	// ByToken returns the link of the token unless it expired, it is
+	// used for the files of a gallery which was opened through it.
+	// Whether it has views left is up to the caller, see Visiting.
+	ByToken(token string) (*ShareLink, error)
+	// ByGalleryID returns the links of the gallery
+	// which were not revoked, the newest first.
+	ByGalleryID(galleryID uint) ([]ShareLink, error)
+	// Visit counts a view of the gallery through the link of the token.
+	// It returns ErrNotFound when the link expired or has no views left.
+	Visit(token string) (*ShareLink, error)
+	// Create gives the link a token, which is only kept hashed.
+	Create(link *ShareLink) error
+	// Revoke stops the link from working at once.
+	Revoke(id uint) error
+}
+
+func NewShareLinkService(db *gorm.DB, hmacKey string) ShareLinkService {
+	return &shareLinkService{
+		ShareLinkDB: &shareLinkValidator{
+			ShareLinkDB: &shareLinkGorm{db},
+			hmac:        hash.NewHMAC(hmacKey),
+		},
+	}
+}
+
+type shareLinkService struct {
+	ShareLinkDB
+}
+
+// shareLinkTokenBytes is how much randomness goes into a token.
+const shareLinkTokenBytes = 32
+
+type shareLinkValidator struct {
+	ShareLinkDB
+	hmac hash.HMAC
+}
+
+func (sv *shareLinkValidator) ByID(id uint) (*ShareLink, error) {
+	var link ShareLink
+	link.ID = id
+	if err := runShareLinkValidations(&link, sv.idGreaterThan(0)); err != nil {
+		return nil, err
+	}
+	return sv.ShareLinkDB.ByID(id)
+}
+
+func (sv *shareLinkValidator) ByToken(token string) (*ShareLink, error) {
+	link := ShareLink{Token: token}
+	if err := runShareLinkValidations(&link, sv.tokenRequired, sv.hmacToken); err != nil {
+		return nil, err
+	}
+	return sv.ShareLinkDB.ByToken(link.TokenHash)
+}
+
+func (sv *shareLinkValidator) Visit(token string) (*ShareLink, error) {
+	link := ShareLink{Token: token}
+	if err := runShareLinkValidations(&link, sv.tokenRequired, sv.hmacToken); err != nil {
+		return nil, err
+	}
+	return sv.ShareLinkDB.Visit(link.TokenHash)
+}
+
+func (sv *shareLinkValidator) Create(link *ShareLink) error {
+	err := runShareLinkValidations(link,
+		sv.galleryIDRequired,
+		sv.maxViewsValid,
+		sv.expiresInFuture,
+		sv.setToken,
+		sv.hmacToken)
+	if err != nil {
+		return err
+	}
+	return sv.ShareLinkDB.Create(link)
+}
+
+func (sv *shareLinkValidator) Revoke(id uint) error {
+	var link ShareLink
+	link.ID = id
+	if err := runShareLinkValidations(&link, sv.idGreaterThan(0)); err != nil {
+		return err
+	}
+	return sv.ShareLinkDB.Revoke(id)
+}
+
+type shareLinkValidationFunc func(*ShareLink) error
+
+func runShareLinkValidations(link *ShareLink, fns ...shareLinkValidationFunc) error {
+	for _, fn := range fns {
+		if err := fn(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sv *shareLinkValidator) idGreaterThan(n uint) shareLinkValidationFunc {
+	return func(link *ShareLink) error {
+		if link.ID <= n {
+			return ErrInvalidId
+		}
+		return nil
+	}
+}
+
+func (sv *shareLinkValidator) galleryIDRequired(link *ShareLink) error {
+	if link.GalleryID <= 0 {
+		return ErrGalleryIDRequired
+	}
+	return nil
+}
+
+func (sv *shareLinkValidator) maxViewsValid(link *ShareLink) error {
+	if link.MaxViews < 0 {
+		return ErrInvalidMaxViews
+	}
+	return nil
+}
+
+func (sv *shareLinkValidator) expiresInFuture(link *ShareLink) error {
+	if link.ExpiresAt != nil && !link.ExpiresAt.After(time.Now()) {
+		return ErrInvalidExpiry
+	}
+	return nil
+}
+
+func (sv *shareLinkValidator) setToken(link *ShareLink) error {
+	token, err := rand.GenString(shareLinkTokenBytes)
+	if err != nil {
+		return err
+	}
+	link.Token = token
+	return nil
+}
+
+// tokenRequired makes sure a token from a URL is one
+// setToken could have made, before it is looked up.
+func (sv *shareLinkValidator) tokenRequired(link *ShareLink) error {
+	n, err := rand.NBytesLen(link.Token)
+	if err != nil || n != shareLinkTokenBytes {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (sv *shareLinkValidator) hmacToken(link *ShareLink) error {
+	if link.Token == "" {
+		return nil
+	}
+	link.TokenHash = sv.hmac.HashFun(link.Token)
+	return nil
+}
+
+var _ ShareLinkDB = &shareLinkGorm{}
+
+type shareLinkGorm struct {
+	db *gorm.DB
+}
+
+func (sg *shareLinkGorm) ByID(id uint) (*ShareLink, error) {
+	var link ShareLink
+	err := first(sg.db.Where("id = ?", id), &link)
+	return &link, err
+}
+
+func (sg *shareLinkGorm) ByToken(tokenHash string) (*ShareLink, error) {
+	var link ShareLink
+	db := sg.db.Where("token_hash = ? AND (expires_at IS NULL OR expires_at > ?)", tokenHash, time.Now())
+	err := first(db, &link)
+	return &link, err
+}
+
+func (sg *shareLinkGorm) ByGalleryID(galleryID uint) ([]ShareLink, error) {
+	var links []ShareLink
+	err := sg.db.Where("gallery_id = ?", galleryID).
+		Order("created_at DESC").
+		Find(&links).Error
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// Visit checks the limits and counts the view in one statement,
+// so two visitors can't both take the last view of a link.
+func (sg *shareLinkGorm) Visit(tokenHash string) (*ShareLink, error) {
+	db := sg.db.Model(&ShareLink{}).
+		Where("token_hash = ?", tokenHash).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Where("max_views = 0 OR views < max_views").
+		UpdateColumns(map[string]interface{}{
+			"views":      gorm.Expr("views + 1"),
+			"visited_at": time.Now(),
+		})
+	if db.Error != nil {
+		return nil, db.Error
+	}
+	if db.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	var link ShareLink
+	err := first(sg.db.Where("token_hash = ?", tokenHash), &link)
+	return &link, err
+}
+
+func (sg *shareLinkGorm) Create(link *ShareLink) error {
+	return sg.db.Create(link).Error
+}
+
+func (sg *shareLinkGorm) Revoke(id uint) error {
+	link := ShareLink{Model: gorm.Model{ID: id}}
+	return sg.db.Delete(&link).Error
+}
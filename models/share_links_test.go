@@ -0,0 +1,32 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShareLinkVisiting(t *testing.T) {
+	ago := func(d time.Duration) *time.Time {
+		at := time.Now().Add(-d)
+		return &at
+	}
+	tests := []struct {
+		name string
+		link ShareLink
+		want bool
+	}{
+		{"views left", ShareLink{MaxViews: 2, Views: 1, VisitedAt: ago(2 * time.Hour)}, true},
+		{"no limit", ShareLink{Views: 100}, true},
+		{"last view just taken", ShareLink{MaxViews: 1, Views: 1, VisitedAt: ago(time.Minute)}, true},
+		{"last view taken long ago", ShareLink{MaxViews: 1, Views: 1, VisitedAt: ago(2 * time.Hour)}, false},
+		{"used up without a visit", ShareLink{MaxViews: 1, Views: 1}, false},
+		{"expired", ShareLink{ExpiresAt: ago(time.Second), VisitedAt: ago(time.Minute)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.link.Visiting(); got != tt.want {
+				t.Errorf("Visiting() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package models
+
+import (
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Tag is a name galleries and images are tagged with. Tags are shared
+// by all users, so the page of a tag lists the public galleries and
+// images of everyone who used it.
+type Tag struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	Name      string `gorm:"not null;unique_index"`
+}
+
+// Path returns the path of the page of the tag.
+func (t Tag) Path() string {
+	return "/tags/" + url.PathEscape(t.Name)
+}
+
+// Tags are shown and edited as a list separated by commas.
+type Tags []Tag
+
+func (ts Tags) String() string {
+	names := make([]string, len(ts))
+	for n, t := range ts {
+		names[n] = t.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// GalleryTag links a tag to a gallery.
+type GalleryTag struct {
+	GalleryID uint `gorm:"primary_key;auto_increment:false"`
+	TagID     uint `gorm:"primary_key;auto_increment:false;index"`
+}
+
+// ImageTag links a tag to an image.
+type ImageTag struct {
+	ImageID uint `gorm:"primary_key;auto_increment:false"`
+	TagID   uint `gorm:"primary_key;auto_increment:false;index"`
+}
+
+const (
+	maxTagLen = 50
+	// maxTags is how many tags a gallery or an image can have.
+	maxTags = 20
+	// maxTagged is how many galleries and how
+	// many images the page of a tag lists.
+	maxTagged = 60
+)
+
+// ParseTags splits a list of tags separated by commas,
+// the names are normalized when the tags are set.
+func ParseTags(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// TagService is used to tag galleries and images.
+type TagService interface {
+	TagDB
+	// SetGalleryTags makes the tags with the names the tags of
+	// the gallery, the tags which don't exist yet are created.
+	SetGalleryTags(galleryID uint, names []string) error
+	// SetImageTags does the same as SetGalleryTags for an image.
+	SetImageTags(imageID uint, names []string) error
+}
+
+type TagDB interface {
+	ByName(name string) (*Tag, error)
+	// ByGalleryID returns the tags of the gallery by name.
+	ByGalleryID(galleryID uint) (Tags, error)
+	// ByImageIDs returns the tags of each of the images by name,
+	// images without tags are left out.
+	ByImageIDs(imageIDs []uint) (map[uint]Tags, error)
+	// Suggest returns up to limit of the tags of the galleries and
+	// images of the user whose names start with prefix, by name.
+	// Tags only used in the trash are not suggested.
+	Suggest(userID uint, prefix string, limit int) (Tags, error)
+	// Galleries and Images return the galleries and images tagged
+	// with the tag which anyone can see without a password, the
+	// ones updated or uploaded last first. Images are only listed
+	// once they are ready.
+	Galleries(tagID uint) ([]Gallery, error)
+	Images(tagID uint) ([]Image, error)
+
+	// FindOrCreate looks the tag up by name,
+	// and creates it when there is none.
+	FindOrCreate(tag *Tag) error
+	// ReplaceGalleryTags and ReplaceImageTags make the
+	// tags with the IDs the tags of the gallery or image.
+	ReplaceGalleryTags(galleryID uint, tagIDs []uint) error
+	ReplaceImageTags(imageID uint, tagIDs []uint) error
+}
+
+func NewTagService(db *gorm.DB) TagService {
+	return &tagService{
+		TagDB: &tagValidator{&tagGorm{db}},
+	}
+}
+
+var _ TagService = &tagService{}
+
+type tagService struct {
+	TagDB
+}
+
+func (ts *tagService) SetGalleryTags(galleryID uint, names []string) error {
+	ids, err := ts.tagIDs(names)
+	if err != nil {
+		return err
+	}
+	return ts.ReplaceGalleryTags(galleryID, ids)
+}
+
+func (ts *tagService) SetImageTags(imageID uint, names []string) error {
+	ids, err := ts.tagIDs(names)
+	if err != nil {
+		return err
+	}
+	return ts.ReplaceImageTags(imageID, ids)
+}
+
+// tagIDs finds or creates the tags with the names, names which are
+// the same once normalized are the same tag.
+func (ts *tagService) tagIDs(names []string) ([]uint, error) {
+	seen := make(map[uint]bool)
+	var ids []uint
+	for _, name := range names {
+		tag := Tag{Name: name}
+		if err := ts.FindOrCreate(&tag); err != nil {
+			return nil, err
+		}
+		if seen[tag.ID] {
+			continue
+		}
+		seen[tag.ID] = true
+		ids = append(ids, tag.ID)
+	}
+	if len(ids) > maxTags {
+		return nil, ErrTooManyTags
+	}
+	return ids, nil
+}
+
+var _ TagDB = &tagValidator{}
+
+type tagValidator struct {
+	TagDB
+}
+
+// ByName returns ErrNotFound for names no tag can have.
+func (tv *tagValidator) ByName(name string) (*Tag, error) {
+	tag := Tag{Name: name}
+	if err := runTagValidations(&tag, tv.normalizeName, tv.nameValid); err != nil {
+		return nil, ErrNotFound
+	}
+	return tv.TagDB.ByName(tag.Name)
+}
+
+func (tv *tagValidator) Suggest(userID uint, prefix string, limit int) (Tags, error) {
+	tag := Tag{Name: prefix}
+	if err := runTagValidations(&tag, tv.normalizeName); err != nil {
+		return nil, err
+	}
+	return tv.TagDB.Suggest(userID, tag.Name, limit)
+}
+
+func (tv *tagValidator) FindOrCreate(tag *Tag) error {
+	err := runTagValidations(tag,
+		tv.normalizeName,
+		tv.nameValid)
+	if err != nil {
+		return err
+	}
+	return tv.TagDB.FindOrCreate(tag)
+}
+
+type tagValidationFunc func(*Tag) error
+
+func runTagValidations(tag *Tag, fns ...tagValidationFunc) error {
+	for _, fn := range fns {
+		if err := fn(tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeName makes tags which differ only in case
+// or surrounding spaces the same, like normalizeEmail.
+func (tv *tagValidator) normalizeName(tag *Tag) error {
+	tag.Name = strings.ToLower(tag.Name)
+	tag.Name = strings.TrimSpace(tag.Name)
+	return nil
+}
+
+// nameValid keeps out the commas which separate tags when they are
+// edited, and the slashes which would end the name in its path.
+func (tv *tagValidator) nameValid(tag *Tag) error {
+	if tag.Name == "" || utf8.RuneCountInString(tag.Name) > maxTagLen {
+		return ErrInvalidTag
+	}
+	for _, r := range tag.Name {
+		if r == ',' || r == '/' || unicode.IsControl(r) {
+			return ErrInvalidTag
+		}
+	}
+	return nil
+}
+
+var _ TagDB = &tagGorm{}
+
+type tagGorm struct {
+	db *gorm.DB
+}
+
+func (tg *tagGorm) ByName(name string) (*Tag, error) {
+	var tag Tag
+	db := tg.db.Where("name = ?", name)
+	err := first(db, &tag)
+	return &tag, err
+}
+
+func (tg *tagGorm) ByGalleryID(galleryID uint) (Tags, error) {
+	var tags Tags
+	err := tg.db.
+		Joins("JOIN gallery_tags ON gallery_tags.tag_id = tags.id").
+		Where("gallery_tags.gallery_id = ?", galleryID).
+		Order("tags.name").
+		Find(&tags).Error
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (tg *tagGorm) ByImageIDs(imageIDs []uint) (map[uint]Tags, error) {
+	byImage := make(map[uint]Tags)
+	if len(imageIDs) == 0 {
+		return byImage, nil
+	}
+	var rows []struct {
+		ImageID uint
+		Tag
+	}
+	err := tg.db.Table("tags").
+		Select("image_tags.image_id, tags.*").
+		Joins("JOIN image_tags ON image_tags.tag_id = tags.id").
+		Where("image_tags.image_id IN (?)", imageIDs).
+		Order("tags.name").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		byImage[row.ImageID] = append(byImage[row.ImageID], row.Tag)
+	}
+	return byImage, nil
+}
+
+func (tg *tagGorm) Suggest(userID uint, prefix string, limit int) (Tags, error) {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+	var tags Tags
+	err := tg.db.
+		Where(`tags.name LIKE ? ESCAPE '\'`, escaped+"%").
+		Where(`tags.id IN (?) OR tags.id IN (?)`,
+			tg.db.Table("gallery_tags").Select("gallery_tags.tag_id").
+				Joins("JOIN galleries ON galleries.id = gallery_tags.gallery_id AND galleries.deleted_at IS NULL").
+				Where("galleries.user_id = ?", userID).QueryExpr(),
+			tg.db.Table("image_tags").Select("image_tags.tag_id").
+				Joins("JOIN images ON images.id = image_tags.image_id AND images.deleted_at IS NULL").
+				Joins("JOIN galleries ON galleries.id = images.gallery_id AND galleries.deleted_at IS NULL").
+				Where("images.user_id = ?", userID).QueryExpr()).
+		Order("tags.name").
+		Limit(limit).
+		Find(&tags).Error
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (tg *tagGorm) Galleries(tagID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	err := explorable(tg.db.Joins("JOIN gallery_tags ON gallery_tags.gallery_id = galleries.id")).
+		Where("gallery_tags.tag_id = ?", tagID).
+		Order("galleries.updated_at DESC, galleries.id DESC").
+		Limit(maxTagged).
+		Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
+func (tg *tagGorm) Images(tagID uint) ([]Image, error) {
+	var images []Image
+	db := tg.db.
+		Joins("JOIN image_tags ON image_tags.image_id = images.id").
+		Joins("JOIN galleries ON galleries.id = images.gallery_id AND galleries.deleted_at IS NULL")
+	err := explorable(db).
+		Where("image_tags.tag_id = ?", tagID).
+		Where("images.status = ?", ImageReady).
+		Order("images.created_at DESC, images.id DESC").
+		Limit(maxTagged).
+		Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (tg *tagGorm) FindOrCreate(tag *Tag) error {
+	return tg.db.Where(Tag{Name: tag.Name}).FirstOrCreate(tag).Error
+}
+
+func (tg *tagGorm) ReplaceGalleryTags(galleryID uint, tagIDs []uint) error {
+	return tg.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("gallery_id = ?", galleryID).Delete(&GalleryTag{}).Error; err != nil {
+			return err
+		}
+		for _, id := range tagIDs {
+			if err := tx.Create(&GalleryTag{GalleryID: galleryID, TagID: id}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (tg *tagGorm) ReplaceImageTags(imageID uint, tagIDs []uint) error {
+	return tg.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("image_id = ?", imageID).Delete(&ImageTag{}).Error; err != nil {
+			return err
+		}
+		for _, id := range tagIDs {
+			if err := tx.Create(&ImageTag{ImageID: imageID, TagID: id}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
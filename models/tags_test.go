@@ -0,0 +1,151 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTagName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"beach", "beach"},
+		{"Beach", "beach"},
+		{" beach", "beach"},
+		{"\tBEACH \n", "beach"},
+		{" Beach　", "beach"},
+		{"ÉTÉ", "été"},
+		{"Straße", "straße"},
+		{"New  York", "new  york"},
+		{"Sea-Side_2024", "sea-side_2024"},
+		{"   ", ""},
+		{"", ""},
+	}
+	tv := &tagValidator{}
+	for _, tt := range tests {
+		tag := Tag{Name: tt.name}
+		if err := tv.normalizeName(&tag); err != nil {
+			t.Fatal(err)
+		}
+		if tag.Name != tt.want {
+			t.Errorf("normalizeName(%q) = %q, want %q", tt.name, tag.Name, tt.want)
+		}
+	}
+}
+
+// createdTags creates the tags it is asked for.
+type createdTags struct {
+	TagDB
+	names []string
+}
+
+func (ct *createdTags) FindOrCreate(tag *Tag) error {
+	ct.names = append(ct.names, tag.Name)
+	return nil
+}
+
+func TestFindOrCreateTag(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		err  error
+	}{
+		{" Beach ", "beach", nil},
+		{strings.Repeat("é", maxTagLen), strings.Repeat("é", maxTagLen), nil},
+		{"", "", ErrInvalidTag},
+		{" \t ", "", ErrInvalidTag},
+		{"beach,sea", "", ErrInvalidTag},
+		{"../beach", "", ErrInvalidTag},
+		{"beach\x00", "", ErrInvalidTag},
+		{"bea\nch", "", ErrInvalidTag},
+		{strings.Repeat("é", maxTagLen+1), "", ErrInvalidTag},
+	}
+	for _, tt := range tests {
+		db := &createdTags{}
+		tv := &tagValidator{db}
+		err := tv.FindOrCreate(&Tag{Name: tt.name})
+		if err != tt.err {
+			t.Errorf("FindOrCreate(%q) = %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil && (len(db.names) != 1 || db.names[0] != tt.want) {
+			t.Errorf("FindOrCreate(%q) created %q, want %q", tt.name, db.names, tt.want)
+		}
+		if err != nil && len(db.names) != 0 {
+			t.Errorf("FindOrCreate(%q) created %q", tt.name, db.names)
+		}
+	}
+}
+
+// Tags of trashed galleries and images are not suggested, and
+// the page of a tag lists neither them nor unfinished uploads.
+func TestTagQueriesSkipTrash(t *testing.T) {
+	services, err := testingServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := services.db
+	user := User{Username: "tagger", Email: "tagger@example.com"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	tag := func(name string) uint {
+		tag := Tag{Name: name}
+		if err := db.Create(&tag).Error; err != nil {
+			t.Fatal(err)
+		}
+		return tag.ID
+	}
+	gallery := func(slug string, tagID uint) *Gallery {
+		g := &Gallery{UserID: user.ID, Title: slug, Slug: slug, ShareSlug: slug, Visibility: VisibilityPublic}
+		if err := db.Create(g).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Create(&GalleryTag{GalleryID: g.ID, TagID: tagID}).Error; err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+	image := func(g *Gallery, status string, tagID uint) *Image {
+		i := &Image{GalleryID: g.ID, UserID: user.ID, Filename: status, Status: status}
+		if err := db.Create(i).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Create(&ImageTag{ImageID: i.ID, TagID: tagID}).Error; err != nil {
+			t.Fatal(err)
+		}
+		return i
+	}
+
+	live, trashed := tag("sea"), tag("sea-trash")
+	shown := gallery("shown", live)
+	gone := gallery("gone", trashed)
+	ready := image(shown, ImageReady, live)
+	image(shown, ImageProcessing, live)
+	image(shown, ImageFailed, live)
+	db.Delete(image(shown, ImageReady, trashed))
+	image(gone, ImageReady, trashed)
+	db.Delete(gone)
+
+	tags, err := services.Tag.Suggest(user.ID, "Sea", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].ID != live {
+		t.Errorf("Suggest = %v, want only the tag outside the trash", tags)
+	}
+	images, err := services.Tag.Images(live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || images[0].ID != ready.ID {
+		t.Errorf("Images = %d images, want only the ready one", len(images))
+	}
+	if galleries, err := services.Tag.Galleries(trashed); err != nil || len(galleries) != 0 {
+		t.Errorf("Galleries of the trashed tag = %d, %v, want none", len(galleries), err)
+	}
+	if images, err := services.Tag.Images(trashed); err != nil || len(images) != 0 {
+		t.Errorf("Images of the trashed tag = %d, %v, want none", len(images), err)
+	}
+}
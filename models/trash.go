@@ -0,0 +1,181 @@
+package models
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	JobPurgeTrash = "trash:purge"
+
+	trashPurgeInterval = time.Hour
+	// trashKeyPrefix is put in front of the keys of the blobs of
+	// deleted images, which keeps them from being served.
+	trashKeyPrefix = "trash/"
+)
+
+// Trash lists the deleted galleries of a user and the deleted images
+// they can restore, see ImageDB.TrashedForUserID. Images of deleted
+// galleries are not listed on their own, they are restored and purged
+// together with their gallery.
+type Trash struct {
+	Galleries []Gallery
+	Images    []Image
+	Retention time.Duration
+}
+
+func (t *Trash) Empty() bool {
+	return len(t.Galleries) == 0 && len(t.Images) == 0
+}
+
+// PurgeAt returns when an item deleted at deletedAt is purged.
+func (t *Trash) PurgeAt(deletedAt *time.Time) time.Time {
+	if deletedAt == nil {
+		return time.Time{}
+	}
+	return deletedAt.Add(t.Retention)
+}
+
+func (is *imageService) retention() time.Duration {
+	return time.Duration(is.cfg.TrashRetentionDays) * 24 * time.Hour
+}
+
+func (is *imageService) Trash(userID uint) (*Trash, error) {
+	galleries, err := is.gallery.TrashedByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	images, err := is.TrashedForUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &Trash{
+		Galleries: galleries,
+		Images:    images,
+		Retention: is.retention(),
+	}, nil
+}
+
+func (is *imageService) TrashGallery(galleryID uint) error {
+	images, err := is.ByGalleryID(galleryID)
+	if err != nil {
+		return err
+	}
+	for n := range images {
+		if err := is.moveBlobs(&images[n], true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (is *imageService) RestoreGallery(gallery *Gallery) error {
+	if err := is.gallery.Restore(gallery.ID); err != nil {
+		return err
+	}
+	images, err := is.ByGalleryID(gallery.ID)
+	if err != nil {
+		return err
+	}
+	for n := range images {
+		if err := is.moveBlobs(&images[n], false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (is *imageService) RestoreImage(i *Image) error {
+	if _, err := is.gallery.ByID(i.GalleryID); err != nil {
+		return err
+	}
+	if err := is.moveBlobs(i, false); err != nil {
+		return err
+	}
+	return is.ImageDB.Restore(i.ID)
+}
+
+func (is *imageService) PurgeImage(i *Image) error {
+	if err := runImageValidations(i, checkFilename); err != nil {
+		return err
+	}
+	for _, key := range i.blobKeys() {
+		for _, k := range []string{key, trashKeyPrefix + key} {
+			if err := is.store.Delete(k); err != nil && err != ErrNotFound {
+				return err
+			}
+		}
+	}
+	return is.ImageDB.Purge(i.ID)
+}
+
+func (is *imageService) PurgeGallery(galleryID uint) error {
+	images, err := is.AllByGalleryID(galleryID)
+	if err != nil {
+		return err
+	}
+	for n := range images {
+		if err := is.PurgeImage(&images[n]); err != nil {
+			return err
+		}
+	}
+	// Blobs without an image record, such as renditions written
+	// by a job which was still running for a deleted image.
+	prefix := galleryKeyPrefix(galleryID)
+	for _, p := range []string{prefix, trashKeyPrefix + prefix} {
+		keys, err := is.store.List(p)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := is.store.Delete(key); err != nil && err != ErrNotFound {
+				return err
+			}
+		}
+	}
+	return is.gallery.Purge(galleryID)
+}
+
+// purgeTrash is run by a scheduled job, it purges whatever has been
+// in the trash for longer than the retention period.
+func (is *imageService) purgeTrash(job *Job) error {
+	before := time.Now().Add(-is.retention())
+	galleries, err := is.gallery.TrashedBefore(before)
+	if err != nil {
+		return err
+	}
+	for _, g := range galleries {
+		if err := is.PurgeGallery(g.ID); err != nil {
+			return err
+		}
+	}
+	images, err := is.TrashedBefore(before)
+	if err != nil {
+		return err
+	}
+	for n := range images {
+		if err := is.PurgeImage(&images[n]); err != nil {
+			return err
+		}
+	}
+	if n := len(galleries) + len(images); n > 0 {
+		log.Printf("models: purged %d galleries and %d images from the trash", len(galleries), len(images))
+	}
+	return nil
+}
+
+// moveBlobs moves the original and the renditions of the image
+// into the trash, or out of it. Blobs which are missing already
+// are skipped, so a move which failed halfway can be repeated.
+func (is *imageService) moveBlobs(i *Image, toTrash bool) error {
+	for _, key := range i.blobKeys() {
+		from, to := key, trashKeyPrefix+key
+		if !toTrash {
+			from, to = to, from
+		}
+		if err := is.store.Move(from, to); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+
+	_ "golang.org/x/image/webp"
+)
+
+// imageExtensions maps the content types, sniffed from the magic bytes,
+// which can be uploaded to the extension of the stored file.
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// checkHead sniffs the content type of the image and reads its dimensions
+// from the header, so oversized images are rejected before anybody tries
+// to decode them.
+func (is *imageService) checkHead(i *Image, head []byte) error {
+	i.ContentType = http.DetectContentType(head)
+	if _, ok := imageExtensions[i.ContentType]; !ok {
+		return ErrImageType
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(head))
+	if err != nil {
+		return ErrImageCorrupt
+	}
+	if cfg.Width > is.cfg.MaxDimension || cfg.Height > is.cfg.MaxDimension ||
+		int64(cfg.Width)*int64(cfg.Height) > int64(is.cfg.MaxPixels) {
+		return ErrImageDimensions
+	}
+	i.Width = cfg.Width
+	i.Height = cfg.Height
+	return nil
+}
+
+// maxBytesReader fails with err as soon as
+// more than n bytes are read from it.
+type maxBytesReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (mr *maxBytesReader) Read(p []byte) (int, error) {
+	if mr.n < 0 {
+		return 0, mr.err
+	}
+	if int64(len(p)) > mr.n+1 {
+		p = p[:mr.n+1]
+	}
+	n, err := mr.r.Read(p)
+	mr.n -= int64(n)
+	if mr.n < 0 {
+		return n, mr.err
+	}
+	return n, err
+}
@@ -0,0 +1,236 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+// pngHead returns the signature and header of a PNG of the given size,
+// which is all it takes to claim it.
+func pngHead(w, h uint32) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr, w)
+	binary.BigEndian.PutUint32(ihdr[4:], h)
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 2 // truecolor
+	return append([]byte(pngSignature), pngChunk("IHDR", ihdr)...)
+}
+
+// gifHead returns the header of a GIF of the given size.
+func gifHead(w, h uint16) []byte {
+	b := []byte("GIF89a\x00\x00\x00\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint16(b[6:], w)
+	binary.LittleEndian.PutUint16(b[8:], h)
+	return b
+}
+
+// jpegHead returns the start of a baseline JPEG of the given size,
+// up to its scan.
+func jpegHead(w, h uint16) []byte {
+	sof := []byte{8, 0, 0, 0, 0, 1, 1, 0x11, 0}
+	binary.BigEndian.PutUint16(sof[1:], h)
+	binary.BigEndian.PutUint16(sof[3:], w)
+	b := append([]byte{0xFF, 0xD8}, jpegSegment(0xC0, sof)...)
+	return append(b, jpegSegment(0xDA, []byte{1, 1, 0, 0, 63, 0})...)
+}
+
+// webpHead returns the start of an extended WebP of the given size.
+func webpHead(w, h uint32) []byte {
+	vp8x := make([]byte, 10)
+	vp8x[4], vp8x[5], vp8x[6] = byte(w-1), byte((w-1)>>8), byte((w-1)>>16)
+	vp8x[7], vp8x[8], vp8x[9] = byte(h-1), byte((h-1)>>8), byte((h-1)>>16)
+	b := []byte("RIFF\x00\x00\x00\x00WEBP")
+	b = append(b, riffChunk("VP8X", vp8x)...)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+	return b
+}
+
+func TestCheckHead(t *testing.T) {
+	var encodedPNG, encodedJPEG, encodedGIF bytes.Buffer
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	if err := png.Encode(&encodedPNG, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&encodedJPEG, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.Encode(&encodedGIF, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	is := &imageService{cfg: ImageConfig{MaxDimension: 12000, MaxPixels: 50000000}}
+	tests := []struct {
+		name        string
+		head        []byte
+		want        error
+		contentType string
+	}{
+		{"png", encodedPNG.Bytes(), nil, "image/png"},
+		{"jpeg", encodedJPEG.Bytes(), nil, "image/jpeg"},
+		{"gif", encodedGIF.Bytes(), nil, "image/gif"},
+		{"webp", webpHead(3, 2), nil, "image/webp"},
+
+		// The type comes from the content, whatever the file claims to be.
+		{"html", []byte("<html><script>alert(1)</script>"), ErrImageType, ""},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"/>`), ErrImageType, ""},
+		{"bmp", []byte("BM\x00\x00\x00\x00\x00\x00\x00\x00"), ErrImageType, ""},
+		{"text", []byte("just some text"), ErrImageType, ""},
+		{"empty", nil, ErrImageType, ""},
+		{"png after html", append([]byte("<html>"), encodedPNG.Bytes()...), ErrImageType, ""},
+		{"png signature only", []byte(pngSignature), ErrImageCorrupt, ""},
+		{"jpeg marker only", []byte{0xFF, 0xD8, 0xFF, 0x00}, ErrImageCorrupt, ""},
+
+		// Small files can claim dimensions that would take
+		// gigabytes to decode.
+		{"wide png", pngHead(100000, 1), ErrImageDimensions, ""},
+		{"tall png", pngHead(1, 100000), ErrImageDimensions, ""},
+		{"huge png", pngHead(1<<20, 1<<20), ErrImageDimensions, ""},
+		{"overflowing png", pngHead(1<<31-1, 1<<31-1), ErrImageCorrupt, ""},
+		{"png over the pixels", pngHead(10000, 10000), ErrImageDimensions, ""},
+		{"png at the limits", pngHead(12000, 4000), nil, "image/png"},
+		{"huge gif", gifHead(65535, 65535), ErrImageDimensions, ""},
+		{"huge jpeg", jpegHead(65535, 65535), ErrImageDimensions, ""},
+		{"jpeg over the pixels", jpegHead(8000, 8000), ErrImageDimensions, ""},
+		{"huge webp", webpHead(1<<24, 1<<24), ErrImageDimensions, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &Image{}
+			if err := is.checkHead(i, tt.head); err != tt.want {
+				t.Fatalf("checkHead = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && i.ContentType != tt.contentType {
+				t.Errorf("ContentType = %q, want %q", i.ContentType, tt.contentType)
+			}
+			if tt.want != nil && (i.Width != 0 || i.Height != 0) {
+				t.Errorf("dimensions of a rejected image set to %dx%d", i.Width, i.Height)
+			}
+		})
+	}
+}
+
+func TestMaxBytesReader(t *testing.T) {
+	tests := []struct {
+		size int
+		want error
+	}{
+		{0, nil},
+		{99, nil},
+		{100, nil},
+		{101, ErrImageTooLarge},
+		{1 << 20, ErrImageTooLarge},
+	}
+	for _, tt := range tests {
+		r := &maxBytesReader{
+			r:   strings.NewReader(strings.Repeat("x", tt.size)),
+			n:   100,
+			err: ErrImageTooLarge,
+		}
+		b, err := io.ReadAll(r)
+		if err != tt.want {
+			t.Errorf("reading %d bytes: err = %v, want %v", tt.size, err, tt.want)
+		}
+		if len(b) > 101 {
+			t.Errorf("reading %d bytes: read %d, want no more than the limit and one", tt.size, len(b))
+		}
+	}
+}
+
+type uploadImages struct {
+	ImageDB
+	created []*Image
+}
+
+func (ui *uploadImages) UsageByUserID(userID uint) (*Usage, error) {
+	return &Usage{}, nil
+}
+
+func (ui *uploadImages) NextPosition(galleryID uint) (int, error) {
+	return len(ui.created), nil
+}
+
+func (ui *uploadImages) CreateWithinQuota(image *Image, defaultQuota int64) error {
+	ui.created = append(ui.created, image)
+	return nil
+}
+
+type uploadGalleries struct{ GalleryDB }
+
+func (uploadGalleries) ByID(id uint) (*Gallery, error) {
+	return &Gallery{Model: gorm.Model{ID: id}}, nil
+}
+
+type uploadUsers struct{ UserDB }
+
+func (uploadUsers) ByID(id uint) (*User, error) {
+	return &User{Model: gorm.Model{ID: id}}, nil
+}
+
+type uploadJobs struct{ JobService }
+
+func (uploadJobs) Enqueue(kind string, payload interface{}) (*Job, error) {
+	return &Job{}, nil
+}
+
+// Nothing of a rejected upload is left in the store.
+func TestCreateRejectsOversizedUploads(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewGray(image.Rect(0, 0, 30, 20))); err != nil {
+		t.Fatal(err)
+	}
+	padded := append(append([]byte{}, encoded.Bytes()...), make([]byte, 1000)...)
+	tests := []struct {
+		name string
+		b    []byte
+		want error
+	}{
+		{"within the limits", encoded.Bytes(), nil},
+		{"over the size", padded, ErrImageTooLarge},
+		{"over the size after the head", append(encoded.Bytes(), make([]byte, imageHeadSize)...), ErrImageTooLarge},
+		{"decompression bomb", pngHead(50000, 50000), ErrImageDimensions},
+		{"not an image", []byte("<svg onload=alert(1)>"), ErrImageType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			images := &uploadImages{}
+			store := NewDiskStore(t.TempDir())
+			is := &imageService{
+				ImageDB: images,
+				gallery: uploadGalleries{},
+				user:    uploadUsers{},
+				store:   store,
+				jobs:    uploadJobs{},
+				cfg: ImageConfig{
+					MaxBytes:     int64(encoded.Len() + 100),
+					MaxDimension: 12000,
+					MaxPixels:    50000000,
+					QuotaBytes:   DefaultQuotaBytes,
+				},
+			}
+			i := &Image{GalleryID: 1, UserID: 1, OriginalFilename: "photo.jpg"}
+			err := is.Create(i, io.NopCloser(bytes.NewReader(tt.b)))
+			if err != tt.want {
+				t.Fatalf("Create = %v, want %v", err, tt.want)
+			}
+			keys, err := store.List(galleriesKeyPrefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want != nil && (len(keys) != 0 || len(images.created) != 0) {
+				t.Errorf("rejected upload left %v and %d images", keys, len(images.created))
+			}
+			if tt.want == nil && (len(keys) != 1 || i.Size != int64(len(tt.b)) || i.ContentType != "image/png") {
+				t.Errorf("upload saved as %v, %d bytes of %s", keys, i.Size, i.ContentType)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+// DefaultQuotaBytes is used for users without a quota
+// override when ImageConfig doesn't set one.
+const DefaultQuotaBytes = 1 << 30 // 1 Gigabyte
+
+// Usage is how much storage the images of a user take,
+// counting the originals only, and how much they may take.
+type Usage struct {
+	Bytes      int64
+	Images     int
+	QuotaBytes int64
+}
+
+// Remaining returns how many bytes the user may still upload.
+func (u *Usage) Remaining() int64 {
+	if u.Bytes >= u.QuotaBytes {
+		return 0
+	}
+	return u.QuotaBytes - u.Bytes
+}
+
+func (u *Usage) Percent() int {
+	if u.QuotaBytes <= 0 {
+		return 100
+	}
+	p := int(u.Bytes * 100 / u.QuotaBytes)
+	if p > 100 {
+		return 100
+	}
+	return p
+}
+
+func (u *Usage) Used() string {
+	return formatBytes(u.Bytes)
+}
+
+func (u *Usage) Quota() string {
+	return formatBytes(u.QuotaBytes)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Usage returns the storage usage of the user together with their
+// quota, which is either the override set by an admin or the default.
+func (is *imageService) Usage(user *User) (*Usage, error) {
+	usage, err := is.ImageDB.UsageByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	usage.QuotaBytes = is.cfg.QuotaBytes
+	if user.QuotaBytes != nil {
+		usage.QuotaBytes = *user.QuotaBytes
+	}
+	return usage, nil
+}
+
+func (ig *imageGorm) UsageByUserID(userID uint) (*Usage, error) {
+	return usageByUserID(ig.db, userID)
+}
+
+func usageByUserID(db *gorm.DB, userID uint) (*Usage, error) {
+	var usage Usage
+	err := db.Unscoped().Model(&Image{}).
+		Select("COALESCE(SUM(size), 0) AS bytes, COUNT(*) AS images").
+		Where("user_id = ?", userID).
+		Scan(&usage).Error
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
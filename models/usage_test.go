@@ -0,0 +1,54 @@
+package models
+
+import (
+	"sync"
+	"testing"
+)
+
+// Of two uploads which together go over the quota and
+// finish at once, only one may be created.
+func TestCreateWithinQuota(t *testing.T) {
+	services, err := testingServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	quota := int64(100)
+	user := User{Username: "uploader", Email: "uploader@example.com", QuotaBytes: &quota}
+	if err := services.db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	ig := &imageGorm{db: services.db}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for n := range errs {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			image := &Image{GalleryID: 1, UserID: user.ID, Filename: "upload", Size: 60}
+			errs[n] = ig.CreateWithinQuota(image, DefaultQuotaBytes)
+		}(n)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		switch err {
+		case nil:
+			created++
+		case ErrQuotaExceeded:
+		default:
+			t.Fatal(err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d images were created, want 1", created)
+	}
+	usage, err := ig.UsageByUserID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Bytes != 60 {
+		t.Errorf("usage = %d bytes, want 60", usage.Bytes)
+	}
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"fmt"
 	"photo-gallery/hash"
 	"photo-gallery/rand"
 	"regexp"
@@ -11,14 +12,22 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// User is an account of the application. Admins can manage other
+// users, and QuotaBytes overrides the default storage quota when set.
+// Username is unique and names the user in the URLs of their
+// galleries, it can't be changed after signing up. Users who
+// signed up before it was checked were given one which is fit
+// for a URL when the unique index was added, see dedupeUsernames.
 type User struct {
 	gorm.Model
-	Username          string
+	Username          string `gorm:"unique_index"`
 	Email             string `gorm:"not null;unique_index"`
 	Password          string `gorm:"-"`
 	PasswordHash      string `gorm:"not null"`
 	RememberToken     string `gorm:"-"`
 	RememberTokenHash string `gorm:"not null;unique_index"`
+	Admin             bool   `gorm:"not null;default:false"`
+	QuotaBytes        *int64
 }
 
 // Here and below, such instantiations of unused variables are a kind of invariants,
@@ -45,8 +54,12 @@ type UserDB interface {
 	// Single user querying methods
 	ByID(id uint) (*User, error)
 	ByEmail(email string) (*User, error)
+	ByUsername(username string) (*User, error)
 	ByRememberedToken(token string) (*User, error)
 
+	// Multiple users querying methods
+	All() ([]User, error)
+
 	// User altering methods
 	Create(user *User) error
 	Update(user *User) error
@@ -118,6 +131,17 @@ func (uv *userValidator) ByEmail(email string) (*User, error) {
 	return uv.UserDB.ByEmail(user.Email)
 }
 
+func (uv *userValidator) ByUsername(username string) (*User, error) {
+	user := User{
+		Username: username,
+	}
+	if err := runUserValidations(&user, uv.normalizeUsername); err != nil {
+		return nil, err
+	}
+
+	return uv.UserDB.ByUsername(user.Username)
+}
+
 func (uv *userValidator) ByRememberedToken(token string) (*User, error) {
 	user := User{
 		RememberToken: token,
@@ -141,7 +165,11 @@ func (uv *userValidator) Create(user *User) error {
 		uv.normalizeEmail,
 		uv.requireEmail,
 		uv.checkEmailFormat,
-		uv.checkEmailAvailable)
+		uv.checkEmailAvailable,
+		uv.normalizeUsername,
+		uv.requireUsername,
+		uv.checkUsernameFormat,
+		uv.checkUsernameAvailable)
 	if err != nil {
 		return err
 	}
@@ -323,6 +351,114 @@ func (uv *userValidator) checkEmailAvailable(user *User) error {
 	return nil
 }
 
+func (uv *userValidator) normalizeUsername(user *User) error {
+	user.Username = strings.ToLower(user.Username)
+	user.Username = strings.TrimSpace(user.Username)
+	return nil
+}
+
+func (uv *userValidator) requireUsername(user *User) error {
+	if user.Username == "" {
+		return ErrRequireUsername
+	}
+	return nil
+}
+
+func (uv *userValidator) checkUsernameFormat(user *User) error {
+	if !validUsername(user.Username) {
+		return ErrInvalidUsername
+	}
+	return nil
+}
+
+func (uv *userValidator) checkUsernameAvailable(user *User) error {
+	existing, err := uv.ByUsername(user.Username)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.ID != existing.ID {
+		return ErrUsernameTaken
+	}
+	return nil
+}
+
+var usernameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{1,29}$`)
+
+// validUsername reports whether the username is
+// one a user can sign up with now.
+func validUsername(username string) bool {
+	return usernameRegexp.MatchString(username)
+}
+
+// dedupeUsernames makes the usernames of all users, deleted ones
+// included, unique and fit for a URL, so the unique index can be added.
+// Users keep theirs when it is fit and nobody who signed up before them
+// has it, the others get one made from it and their ID, such as
+// "alice-42". Galleries of users with such a username were only found
+// by their ID before, so no link to them breaks.
+func dedupeUsernames(db *gorm.DB) error {
+	if !db.HasTable(&User{}) {
+		return nil
+	}
+	var users []User
+	err := db.Unscoped().Select("id, username").Order("id").Find(&users).Error
+	if err != nil {
+		return err
+	}
+	taken := make(map[string]bool)
+	keep := make(map[uint]bool)
+	for _, user := range users {
+		if validUsername(user.Username) && !taken[user.Username] {
+			taken[user.Username] = true
+			keep[user.ID] = true
+		}
+	}
+	for _, user := range users {
+		if keep[user.ID] {
+			continue
+		}
+		username := freeUsername(user, taken)
+		taken[username] = true
+		err := db.Unscoped().Model(&User{}).
+			Where("id = ?", user.ID).
+			UpdateColumn("username", username).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// freeUsername makes a username for the user which isn't taken
+// from the letters, digits, dashes and underscores of theirs.
+func freeUsername(user User, taken map[string]bool) string {
+	base := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return -1
+	}, strings.ToLower(strings.TrimSpace(user.Username)))
+	base = strings.TrimLeft(base, "-_")
+	if len(base) > 16 {
+		base = base[:16]
+	}
+	if validUsername(base) && !taken[base] {
+		return base
+	}
+	if base == "" {
+		base = "user"
+	}
+	username := fmt.Sprintf("%s-%d", base, user.ID)
+	for n := 2; taken[username]; n++ {
+		username = fmt.Sprintf("%s-%d-%d", base, user.ID, n)
+	}
+	return username
+}
+
 var _ UserDB = &userGorm{}
 
 type userGorm struct {
@@ -343,6 +479,13 @@ func (ug *userGorm) ByEmail(email string) (*User, error) {
 	return &user, err
 }
 
+func (ug *userGorm) ByUsername(username string) (*User, error) {
+	var user User
+	db := ug.db.Where("username = ?", username)
+	err := first(db, &user)
+	return &user, err
+}
+
 func (ug *userGorm) ByRememberedToken(hashedToken string) (*User, error) {
 	var user User
 
@@ -354,6 +497,15 @@ func (ug *userGorm) ByRememberedToken(hashedToken string) (*User, error) {
 	return &user, nil
 }
 
+func (ug *userGorm) All() ([]User, error) {
+	var users []User
+	err := ug.db.Order("id").Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 func (ug *userGorm) Create(user *User) error {
 	return ug.db.Create(user).Error
 }
@@ -6,7 +6,7 @@ import (
 	"time"
 )
 
-func testingUserService() (*UserService, error) {
+func testingServices() (*Services, error) {
 	const (
 		host     = "localhost"
 		port     = "5432"
@@ -16,14 +16,27 @@ func testingUserService() (*UserService, error) {
 		sslmode  = "disable"
 	)
 	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
-	us, err := NewUserService(psqlInfo)
+	services, err := NewServices(
+		WithGorm("postgres", psqlInfo),
+		WithLogMode(false),
+		WithUser("test-pepper", "test-hmac-key"),
+	)
 	if err != nil {
 		return nil, err
 	}
-	us.db.LogMode(false)
 
-	us.DestructiveReset()
-	return us, nil
+	if err := services.DestructiveReset(); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+func testingUserService() (UserService, error) {
+	services, err := testingServices()
+	if err != nil {
+		return nil, err
+	}
+	return services.User, nil
 }
 
 func TestCreateUser(t *testing.T) {
@@ -53,3 +66,31 @@ func TestCreateUser(t *testing.T) {
 	}
 
 }
+
+func TestFreeUsername(t *testing.T) {
+	taken := map[string]bool{"alice": true, "bob-7": true}
+	tests := []struct {
+		username string
+		id       uint
+		want     string
+	}{
+		{"Carol", 3, "carol"},
+		{"alice", 5, "alice-5"},
+		{"", 6, "user-6"},
+		{"Bob", 7, "bob"},
+		{"  ÉLodie.Dupont!  ", 8, "lodiedupont"},
+		{"x", 9, "x-9"},
+		{"__a-very-long-username-indeed", 10, "a-very-long-user"},
+	}
+	for _, tt := range tests {
+		user := User{Username: tt.username}
+		user.ID = tt.id
+		got := freeUsername(user, taken)
+		if got != tt.want {
+			t.Errorf("freeUsername(%q) = %q, want %q", tt.username, got, tt.want)
+		}
+		if !validUsername(got) {
+			t.Errorf("freeUsername(%q) = %q, which is not a valid username", tt.username, got)
+		}
+	}
+}
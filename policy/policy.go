@@ -0,0 +1,161 @@
+// Package policy decides who may do what with a gallery and its
+// images. It only looks at the gallery and at what is known about the
+// visitor, so the rules can be checked without a request or database.
+//
+// A visitor who is not allowed to see a gallery must not learn that it
+// exists, so every check is NotFound for them. Visitors who can see the
+// gallery but may not do something with it are Forbidden.
+package policy
+
+import "photo-gallery/models"
+
+// Decision is the outcome of a check.
+type Decision int
+
+const (
+	Allow Decision = iota
+	// Locked means the visitor has to give the
+	// password of the gallery before seeing it.
+	Locked
+	// Forbidden means the visitor can see the
+	// gallery but may not do this with it.
+	Forbidden
+	// NotFound means the visitor can't see the gallery.
+	NotFound
+)
+
+func (d Decision) Allowed() bool {
+	return d == Allow
+}
+
+// Visitor is what is known about who is asking: a user with their role
+// in the gallery, or an anonymous visitor, and how they came to it.
+type Visitor struct {
+	// User is nil for visitors who are not signed in.
+	User *models.User
+	// Role is the one of User in the gallery.
+	Role models.Role
+	// ByLink is set when the gallery was reached through a link
+	// which can't be guessed: its share slug or the URL of one of
+	// its images, rather than its numeric ID.
+	ByLink bool
+	// Unlocked is set when the visitor gave the password of the gallery.
+	Unlocked bool
+	// Shared is set when the visitor came through a share link, which
+	// opens the gallery whatever its visibility and password.
+	Shared bool
+}
+
+func (v Visitor) userID() uint {
+	if v.User == nil {
+		return 0
+	}
+	return v.User.ID
+}
+
+// CanView decides whether the visitor can see the gallery and its
+// images. Owners, members and those who came through a share link
+// always can, others go by the visibility and password.
+func CanView(g *models.Gallery, v Visitor) Decision {
+	switch {
+	case v.Role != models.RoleNone, v.Shared:
+		return Allow
+	case g.Visibility == models.VisibilityPrivate:
+		return NotFound
+	case g.Visibility == models.VisibilityUnlisted && !v.ByLink:
+		return NotFound
+	case g.HasPassword() && !v.Unlocked:
+		return Locked
+	}
+	return Allow
+}
+
+// CanDownload decides whether the visitor can download all originals of
+// the gallery at once. Those who can add images always can, others
+// only when the owner allows downloads.
+func CanDownload(g *models.Gallery, v Visitor) Decision {
+	if d := CanView(g, v); d != Allow {
+		return d
+	}
+	if g.AllowDownloads || canUpload(v.Role) {
+		return Allow
+	}
+	return Forbidden
+}
+
+// CanUpload decides whether the visitor can add images to the gallery,
+// which owners, editors and contributors can.
+func CanUpload(g *models.Gallery, v Visitor) Decision {
+	if canUpload(v.Role) {
+		return Allow
+	}
+	return deny(g, v)
+}
+
+// CanEdit decides whether the visitor can change the gallery itself: its
+// settings, the order of its images and its cover, its share links and
+// its members. Only its owner can.
+func CanEdit(g *models.Gallery, v Visitor) Decision {
+	if v.Role == models.RoleOwner {
+		return Allow
+	}
+	return deny(g, v)
+}
+
+// CanDelete decides whether the visitor can move the
+// gallery to the trash, which only its owner can.
+func CanDelete(g *models.Gallery, v Visitor) Decision {
+	return CanEdit(g, v)
+}
+
+// CanEditImage decides whether the visitor can change the title, alt text
+// and caption of an image of the gallery. Owners can change any image,
+// editors the ones they uploaded.
+func CanEditImage(g *models.Gallery, image *models.Image, v Visitor) Decision {
+	switch {
+	case image.GalleryID != g.ID:
+		return NotFound
+	case v.Role == models.RoleOwner:
+		return Allow
+	case v.Role == models.RoleEditor && image.UserID == v.userID():
+		return Allow
+	}
+	return deny(g, v)
+}
+
+// CanDeleteImage decides whether the visitor can move an image
+// of the gallery to the trash, the same as CanEditImage.
+func CanDeleteImage(g *models.Gallery, image *models.Image, v Visitor) Decision {
+	return CanEditImage(g, image, v)
+}
+
+// Permissions sums up the checks for the templates, which show the
+// visitor only what they can do.
+func Permissions(g *models.Gallery, v Visitor) models.Permissions {
+	return models.Permissions{
+		Download:   CanDownload(g, v).Allowed(),
+		Upload:     CanUpload(g, v).Allowed(),
+		Edit:       CanEdit(g, v).Allowed(),
+		Delete:     CanDelete(g, v).Allowed(),
+		EditImages: v.Role == models.RoleOwner,
+		EditOwn:    v.Role == models.RoleEditor,
+		UserID:     v.userID(),
+	}
+}
+
+func canUpload(role models.Role) bool {
+	switch role {
+	case models.RoleOwner, models.RoleEditor, models.RoleContributor:
+		return true
+	}
+	return false
+}
+
+// deny is the decision for a visitor who may not do something,
+// which depends on whether they may know the gallery exists.
+func deny(g *models.Gallery, v Visitor) Decision {
+	if CanView(g, v) == Allow {
+		return Forbidden
+	}
+	return NotFound
+}
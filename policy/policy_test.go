@@ -0,0 +1,131 @@
+package policy
+
+import (
+	"photo-gallery/models"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+func testingGallery(visibility string, password bool) *models.Gallery {
+	g := &models.Gallery{
+		Model:      gorm.Model{ID: 1},
+		UserID:     1,
+		Visibility: visibility,
+	}
+	if password {
+		g.PasswordHash = "hash"
+	}
+	return g
+}
+
+func testingVisitor(id uint, role models.Role) Visitor {
+	return Visitor{
+		User: &models.User{Model: gorm.Model{ID: id}},
+		Role: role,
+	}
+}
+
+func TestCanView(t *testing.T) {
+	anonymous := Visitor{}
+	tests := []struct {
+		name    string
+		gallery *models.Gallery
+		visitor Visitor
+		want    Decision
+	}{
+		{"public", testingGallery(models.VisibilityPublic, false), anonymous, Allow},
+		{"unlisted by ID", testingGallery(models.VisibilityUnlisted, false), anonymous, NotFound},
+		{"unlisted by link", testingGallery(models.VisibilityUnlisted, false), Visitor{ByLink: true}, Allow},
+		{"private by link", testingGallery(models.VisibilityPrivate, false), Visitor{ByLink: true}, NotFound},
+		{"private owner", testingGallery(models.VisibilityPrivate, false), testingVisitor(1, models.RoleOwner), Allow},
+		{"private viewer", testingGallery(models.VisibilityPrivate, false), testingVisitor(2, models.RoleViewer), Allow},
+		{"private non-member", testingGallery(models.VisibilityPrivate, false), testingVisitor(2, models.RoleNone), NotFound},
+		{"private share link", testingGallery(models.VisibilityPrivate, true), Visitor{Shared: true}, Allow},
+		{"password", testingGallery(models.VisibilityPublic, true), anonymous, Locked},
+		{"password unlocked", testingGallery(models.VisibilityPublic, true), Visitor{Unlocked: true}, Allow},
+		{"password member", testingGallery(models.VisibilityPublic, true), testingVisitor(2, models.RoleContributor), Allow},
+		{"unlisted password by ID", testingGallery(models.VisibilityUnlisted, true), anonymous, NotFound},
+	}
+	for _, tt := range tests {
+		if got := CanView(tt.gallery, tt.visitor); got != tt.want {
+			t.Errorf("%s: expected %v, received %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestCanChangeGallery(t *testing.T) {
+	public := testingGallery(models.VisibilityPublic, false)
+	private := testingGallery(models.VisibilityPrivate, false)
+	tests := []struct {
+		name    string
+		gallery *models.Gallery
+		visitor Visitor
+		upload  Decision
+		edit    Decision
+	}{
+		{"owner", private, testingVisitor(1, models.RoleOwner), Allow, Allow},
+		{"editor", private, testingVisitor(2, models.RoleEditor), Allow, Forbidden},
+		{"contributor", private, testingVisitor(2, models.RoleContributor), Allow, Forbidden},
+		{"viewer", private, testingVisitor(2, models.RoleViewer), Forbidden, Forbidden},
+		{"non-member of public", public, testingVisitor(2, models.RoleNone), Forbidden, Forbidden},
+		{"non-member of private", private, testingVisitor(2, models.RoleNone), NotFound, NotFound},
+	}
+	for _, tt := range tests {
+		if got := CanUpload(tt.gallery, tt.visitor); got != tt.upload {
+			t.Errorf("%s: expected upload %v, received %v", tt.name, tt.upload, got)
+		}
+		if got := CanEdit(tt.gallery, tt.visitor); got != tt.edit {
+			t.Errorf("%s: expected edit %v, received %v", tt.name, tt.edit, got)
+		}
+		if got := CanDelete(tt.gallery, tt.visitor); got != tt.edit {
+			t.Errorf("%s: expected delete %v, received %v", tt.name, tt.edit, got)
+		}
+	}
+}
+
+func TestCanDeleteImage(t *testing.T) {
+	g := testingGallery(models.VisibilityPrivate, false)
+	own := &models.Image{GalleryID: 1, UserID: 2}
+	other := &models.Image{GalleryID: 1, UserID: 3}
+	elsewhere := &models.Image{GalleryID: 2, UserID: 2}
+	tests := []struct {
+		name    string
+		image   *models.Image
+		visitor Visitor
+		want    Decision
+	}{
+		{"owner", other, testingVisitor(1, models.RoleOwner), Allow},
+		{"editor own", own, testingVisitor(2, models.RoleEditor), Allow},
+		{"editor other", other, testingVisitor(2, models.RoleEditor), Forbidden},
+		{"contributor own", own, testingVisitor(2, models.RoleContributor), Forbidden},
+		{"non-member", own, testingVisitor(2, models.RoleNone), NotFound},
+		{"other gallery", elsewhere, testingVisitor(2, models.RoleEditor), NotFound},
+	}
+	for _, tt := range tests {
+		if got := CanDeleteImage(g, tt.image, tt.visitor); got != tt.want {
+			t.Errorf("%s: expected %v, received %v", tt.name, tt.want, got)
+		}
+		if tt.image.GalleryID == g.ID && Permissions(g, tt.visitor).EditImage(*tt.image) != (tt.want == Allow) {
+			t.Errorf("%s: expected the permissions to agree with the decision", tt.name)
+		}
+	}
+}
+
+func TestCanDownload(t *testing.T) {
+	g := testingGallery(models.VisibilityPublic, false)
+	if got := CanDownload(g, Visitor{}); got != Forbidden {
+		t.Errorf("Expected Forbidden without downloads allowed. Received %v", got)
+	}
+	if got := CanDownload(g, testingVisitor(2, models.RoleContributor)); got != Allow {
+		t.Errorf("Expected contributors to download. Received %v", got)
+	}
+	g.AllowDownloads = true
+	if got := CanDownload(g, Visitor{}); got != Allow {
+		t.Errorf("Expected Allow with downloads allowed. Received %v", got)
+	}
+	g.PasswordHash = "hash"
+	if got := CanDownload(g, Visitor{}); got != Locked {
+		t.Errorf("Expected Locked with a password. Received %v", got)
+	}
+}
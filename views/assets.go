@@ -0,0 +1,91 @@
+package views
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var ASSETSDIR string = "assets/"
+
+// AssetsURLPrefix is the path under which the static assets are served.
+const AssetsURLPrefix = "/assets/"
+
+// fingerprintLen is how much of the checksum of an
+// asset goes into its fingerprinted name.
+const fingerprintLen = 10
+
+// Asset is a file of the assets directory.
+type Asset struct {
+	// Name is the path of the file in the assets directory.
+	Name string
+	// Fingerprinted is the name with a fingerprint of the content
+	// before the extension, such as "styles.0123456789.css".
+	Fingerprinted string
+	// ETag is the strong entity tag of the content.
+	ETag string
+}
+
+var assets struct {
+	once sync.Once
+	// byName has every asset under both of its names.
+	byName map[string]Asset
+}
+
+// loadAssets fingerprints the assets the first time they are needed.
+// Assets are expected not to change while the application runs, a new
+// fingerprint takes a restart.
+func loadAssets() {
+	assets.byName = make(map[string]Asset)
+	err := filepath.WalkDir(ASSETSDIR, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(ASSETSDIR, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		sum := sha256.Sum256(b)
+		checksum := hex.EncodeToString(sum[:])
+		ext := path.Ext(name)
+		a := Asset{
+			Name:          name,
+			Fingerprinted: strings.TrimSuffix(name, ext) + "." + checksum[:fingerprintLen] + ext,
+			ETag:          `"` + checksum + `"`,
+		}
+		assets.byName[a.Name] = a
+		assets.byName[a.Fingerprinted] = a
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// AssetPath returns the URL of the named asset with its fingerprint,
+// so it changes whenever the asset does and can be cached for good.
+// Unknown assets keep their plain name.
+func AssetPath(name string) string {
+	if a, ok := LookupAsset(name); ok {
+		return AssetsURLPrefix + a.Fingerprinted
+	}
+	return AssetsURLPrefix + name
+}
+
+// LookupAsset finds the asset by either of its names.
+func LookupAsset(name string) (Asset, bool) {
+	assets.once.Do(loadAssets)
+	a, ok := assets.byName[name]
+	return a, ok
+}
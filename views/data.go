@@ -27,6 +27,9 @@ type Data struct {
 	User  *models.User
 	CSRF  template.HTML
 	Yield interface{}
+	// Status is the code the page is sent with,
+	// http.StatusOK when it is not set.
+	Status int
 }
 
 func (d *Data) SetAlert(err error) {
@@ -0,0 +1,232 @@
+package views
+
+import (
+	"html"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	mdHeading     = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdBullet      = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	mdNumbered    = regexp.MustCompile(`^\s*\d{1,9}[.)]\s+(.*)$`)
+	mdQuote       = regexp.MustCompile(`^\s*>\s?(.*)$`)
+	mdFence       = regexp.MustCompile("^\\s*```")
+	mdRule        = regexp.MustCompile(`^\s*([-*_])(\s*([-*_])){2,}\s*$`)
+	mdEscapable   = "\\`*_[]()#+-.!>"
+	mdLinkSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+)
+
+// Markdown renders the Markdown of a description as HTML. Everything
+// the text has is escaped, the only HTML in the result is made here:
+// paragraphs, headings, lists, quotes, code, emphasis and links whose
+// URLs are relative or use http, https or mailto. Raw HTML in the text
+// shows up as text.
+func Markdown(src string) template.HTML {
+	var md markdown
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	for n := 0; n < len(lines); n++ {
+		line := lines[n]
+		switch {
+		case mdFence.MatchString(line):
+			md.closeBlocks()
+			var code []string
+			for n++; n < len(lines) && !mdFence.MatchString(lines[n]); n++ {
+				code = append(code, lines[n])
+			}
+			md.b.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+		case strings.TrimSpace(line) == "":
+			md.closeBlocks()
+		case mdHeading.MatchString(line):
+			md.closeBlocks()
+			m := mdHeading.FindStringSubmatch(line)
+			// Level 1 and 2 are the ones of the page itself.
+			level := len(m[1]) + 2
+			if level > 6 {
+				level = 6
+			}
+			tag := "h" + string(rune('0'+level))
+			md.b.WriteString("<" + tag + ">" + mdInline(m[2]) + "</" + tag + ">\n")
+		case mdRule.MatchString(line):
+			md.closeBlocks()
+			md.b.WriteString("<hr>\n")
+		case mdBullet.MatchString(line):
+			md.item("ul", mdBullet.FindStringSubmatch(line)[1])
+		case mdNumbered.MatchString(line):
+			md.item("ol", mdNumbered.FindStringSubmatch(line)[1])
+		case mdQuote.MatchString(line):
+			if md.block != "blockquote" {
+				md.closeBlocks()
+				md.block = "blockquote"
+			}
+			md.para = append(md.para, mdQuote.FindStringSubmatch(line)[1])
+		case md.list != "" && unicode.IsSpace(rune(line[0])):
+			// An indented line goes on with the list item.
+			md.para = append(md.para, strings.TrimSpace(line))
+		default:
+			if md.list != "" || md.block != "" {
+				md.closeBlocks()
+			}
+			md.para = append(md.para, line)
+		}
+	}
+	md.closeBlocks()
+	return template.HTML(md.b.String())
+}
+
+// markdown is the state of Markdown while it renders: the lines of the
+// paragraph, quote or list item being read, and the list they are in.
+type markdown struct {
+	b     strings.Builder
+	para  []string
+	block string
+	list  string
+}
+
+func (md *markdown) item(list, text string) {
+	md.flush()
+	if md.list != list {
+		md.closeBlocks()
+		md.list = list
+		md.b.WriteString("<" + list + ">\n")
+	}
+	md.para = []string{text}
+	md.block = "li"
+}
+
+// flush writes the lines read so far, the lines of a
+// paragraph are kept apart by line breaks.
+func (md *markdown) flush() {
+	if len(md.para) == 0 {
+		return
+	}
+	parts := make([]string, len(md.para))
+	for n, line := range md.para {
+		parts[n] = mdInline(strings.TrimSpace(line))
+	}
+	text := strings.Join(parts, "<br>\n")
+	switch md.block {
+	case "li":
+		md.b.WriteString("<li>" + text + "</li>\n")
+	case "blockquote":
+		md.b.WriteString("<blockquote><p>" + text + "</p></blockquote>\n")
+	default:
+		md.b.WriteString("<p>" + text + "</p>\n")
+	}
+	md.para = nil
+}
+
+func (md *markdown) closeBlocks() {
+	md.flush()
+	if md.list != "" {
+		md.b.WriteString("</" + md.list + ">\n")
+	}
+	md.list = ""
+	md.block = ""
+}
+
+// mdInline renders code, emphasis and links in a line of text.
+func mdInline(s string) string {
+	var b strings.Builder
+	plain := 0
+	writePlain := func(end int) {
+		b.WriteString(html.EscapeString(s[plain:end]))
+	}
+	for i := 0; i < len(s); {
+		var out string
+		var next int
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && strings.IndexByte(mdEscapable, s[i+1]) >= 0:
+			out, next = html.EscapeString(s[i+1:i+2]), i+2
+		case s[i] == '`':
+			if end := strings.IndexByte(s[i+1:], '`'); end >= 0 {
+				out = "<code>" + html.EscapeString(s[i+1:i+1+end]) + "</code>"
+				next = i + end + 2
+			}
+		case strings.HasPrefix(s[i:], "**") || strings.HasPrefix(s[i:], "__"):
+			if end := mdClosing(s, i+2, s[i:i+2]); end >= 0 {
+				out = "<strong>" + mdInline(s[i+2:end]) + "</strong>"
+				next = end + 2
+			}
+		case (s[i] == '*' || s[i] == '_') && mdOpens(s, i):
+			if end := mdClosing(s, i+1, s[i:i+1]); end >= 0 {
+				out = "<em>" + mdInline(s[i+1:end]) + "</em>"
+				next = end + 1
+			}
+		case s[i] == '[':
+			out, next = mdLink(s, i)
+		}
+		if out == "" {
+			_, size := utf8.DecodeRuneInString(s[i:])
+			i += size
+			continue
+		}
+		writePlain(i)
+		b.WriteString(out)
+		i, plain = next, next
+	}
+	writePlain(len(s))
+	return b.String()
+}
+
+// mdOpens reports whether the * or _ at i starts emphasis, which
+// an underscore in the middle of a word does not.
+func mdOpens(s string, i int) bool {
+	if i+1 >= len(s) || s[i+1] == ' ' {
+		return false
+	}
+	if s[i] == '_' && i > 0 {
+		r, _ := utf8.DecodeLastRuneInString(s[:i])
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}
+	return true
+}
+
+// mdClosing returns where the delimiter which closes emphasis
+// opened before from is, or -1 when there is none.
+func mdClosing(s string, from int, delim string) int {
+	end := strings.Index(s[from:], delim)
+	if end <= 0 || s[from+end-1] == ' ' {
+		return -1
+	}
+	return from + end
+}
+
+// mdLink renders a link [text](url) starting at i. Links whose URLs
+// are not safe are left as they are, and so shown as text.
+func mdLink(s string, i int) (string, int) {
+	mid := strings.Index(s[i:], "](")
+	if mid < 0 {
+		return "", 0
+	}
+	mid += i
+	end := strings.IndexByte(s[mid+2:], ')')
+	if end < 0 {
+		return "", 0
+	}
+	end += mid + 2
+	href := strings.TrimSpace(s[mid+2 : end])
+	if !mdSafeURL(href) {
+		return "", 0
+	}
+	return `<a href="` + html.EscapeString(href) + `" rel="nofollow ugc noopener">` + mdInline(s[i+1:mid]) + "</a>", end + 1
+}
+
+func mdSafeURL(href string) bool {
+	if href == "" {
+		return false
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "" {
+		// Relative links can't hide a scheme before a colon.
+		return !strings.Contains(strings.SplitN(href, "/", 2)[0], ":")
+	}
+	return mdLinkSchemes[strings.ToLower(u.Scheme)]
+}
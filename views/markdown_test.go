@@ -0,0 +1,52 @@
+package views
+
+import "testing"
+
+func TestMarkdown(t *testing.T) {
+	const rel = ` rel="nofollow ugc noopener"`
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		// Links with a scheme other than http, https or mailto are text.
+		{"javascript link", "[x](javascript:alert(1))", "<p>[x](javascript:alert(1))</p>\n"},
+		{"mixed case javascript link", "[x](JaVaScRiPt:alert(1))", "<p>[x](JaVaScRiPt:alert(1))</p>\n"},
+		{"javascript link after a space", "[x]( javascript:alert(1))", "<p>[x]( javascript:alert(1))</p>\n"},
+		{"javascript link with a tab", "[x](java\tscript:alert(1))", "<p>[x](java\tscript:alert(1))</p>\n"},
+		{"data link", "[x](data:text/html;base64,PHNjcmlwdD4=)", "<p>[x](data:text/html;base64,PHNjcmlwdD4=)</p>\n"},
+		{"encoded colon", "[x](javascript&#58;alert)", `<p><a href="javascript&amp;#58;alert"` + rel + ">x</a></p>\n"},
+		{"relative link", "[x](/a:b)", `<p><a href="/a:b"` + rel + ">x</a></p>\n"},
+		{"mailto link", "[x](mailto:a@b.c)", `<p><a href="mailto:a@b.c"` + rel + ">x</a></p>\n"},
+
+		// Quotes and brackets in a URL can't end the attribute.
+		{"quotes in href", `[x](http://a.com/"onmouseover="alert)`, `<p><a href="http://a.com/&#34;onmouseover=&#34;alert"` + rel + ">x</a></p>\n"},
+		{"tag in href", `[x](http://a.com/>"<script>)`, `<p><a href="http://a.com/&gt;&#34;&lt;script&gt;"` + rel + ">x</a></p>\n"},
+		{"ampersand in href", "[x](https://a.com/?q=1&r=2)", `<p><a href="https://a.com/?q=1&amp;r=2"` + rel + ">x</a></p>\n"},
+
+		// Raw HTML shows up as text.
+		{"script", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"img onerror", `<img src=x onerror="alert(1)">`, "<p>&lt;img src=x onerror=&#34;alert(1)&#34;&gt;</p>\n"},
+		{"html in code", "`<b>`", "<p><code>&lt;b&gt;</code></p>\n"},
+		{"html in a fence", "```\n<script>\n```", "<pre><code>&lt;script&gt;</code></pre>\n"},
+		{"html in link text", "[<b>x</b>](/x)", `<p><a href="/x"` + rel + ">&lt;b&gt;x&lt;/b&gt;</a></p>\n"},
+
+		// Emphasis.
+		{"nested emphasis", "**bold *em* bold**", "<p><strong>bold <em>em</em> bold</strong></p>\n"},
+		{"unclosed strong", "**bold", "<p>**bold</p>\n"},
+		{"unclosed em", "*em", "<p>*em</p>\n"},
+		{"unclosed em in strong", "**a *b**", "<p><strong>a *b</strong></p>\n"},
+		{"underscores in a word", "snake_case_word", "<p>snake_case_word</p>\n"},
+		{"emphasis in a link", "[*em* and `code`](/x)", `<p><a href="/x"` + rel + "><em>em</em> and <code>code</code></a></p>\n"},
+
+		// Entities are text too, they are not decoded.
+		{"entities", "Tom & Jerry &lt;b&gt; &#60;", "<p>Tom &amp; Jerry &amp;lt;b&amp;gt; &amp;#60;</p>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(Markdown(tt.src)); got != tt.want {
+				t.Errorf("Markdown(%q) =\n%q\nwant\n%q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
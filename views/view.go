@@ -30,6 +30,8 @@ func NewView(layout string, files ...string) *View {
 		"csrfField": func() (template.HTML, error) {
 			return "", errors.New("csrffield is not implemented")
 		},
+		"asset":    AssetPath,
+		"markdown": Markdown,
 	}).ParseFiles(files...)
 	if err != nil {
 		panic(err)
@@ -80,6 +82,9 @@ func (v *View) Render(w http.ResponseWriter, r *http.Request, data interface{})
 		http.Error(w, "Somthing went wrong. If the problem persists, please contact us", http.StatusInternalServerError)
 		return
 	}
+	if vd.Status != 0 {
+		w.WriteHeader(vd.Status)
+	}
 	io.Copy(w, &buffer)
 }
 